@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
 
 	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
 func BenchmarkTx1(b *testing.B) {
@@ -14,21 +17,19 @@ func BenchmarkTx1(b *testing.B) {
 	cfg.OutputPaths = []string{"zap1.log"}
 	logger, _ := cfg.Build()
 	session := postgres()
-	limiter := make(chan struct{}, 100)
+	limiter := metrics.NewLimiter(metrics.LimiterOpts{Name: "hot_path_tx1", Capacity: 100})
 
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		wg.Add(1)
-		limiter <- struct{}{}
-		go func(i int) {
-			defer wg.Done()
-			defer func() { <-limiter }()
-			tx1(logger, session, &wg, limiter, req{
-				Author: fmt.Sprintf("Author %d", i),
-				Book:   fmt.Sprintf("Book %d", i),
-			})
-		}(i)
+		if err := limiter.Acquire(context.Background()); err != nil {
+			b.Fatalf("could not acquire limiter: %v", err)
+		}
+		go tx1(logger, session, &wg, limiter, req{
+			Author: fmt.Sprintf("Author %d", i),
+			Book:   fmt.Sprintf("Book %d", i),
+		})
 	}
 	wg.Wait()
 }
@@ -39,21 +40,19 @@ func BenchmarkTx2(b *testing.B) {
 	cfg.OutputPaths = []string{"zap2.log"}
 	logger, _ := cfg.Build()
 	session := postgres()
-	limiter := make(chan struct{}, 100)
+	limiter := metrics.NewLimiter(metrics.LimiterOpts{Name: "hot_path_tx2", Capacity: 100})
 
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		wg.Add(1)
-		limiter <- struct{}{}
-		go func(i int) {
-			defer wg.Done()
-			defer func() { <-limiter }()
-			tx2(logger, session, &wg, limiter, req{
-				Author: fmt.Sprintf("Author %d", i),
-				Book:   fmt.Sprintf("Book %d", i),
-			})
-		}(i)
+		if err := limiter.Acquire(context.Background()); err != nil {
+			b.Fatalf("could not acquire limiter: %v", err)
+		}
+		go tx2(logger, session, &wg, limiter, req{
+			Author: fmt.Sprintf("Author %d", i),
+			Book:   fmt.Sprintf("Book %d", i),
+		})
 	}
 	wg.Wait()
 }
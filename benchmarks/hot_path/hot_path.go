@@ -8,6 +8,8 @@ import (
 	"github.com/upper/db/v4"
 	"github.com/upper/db/v4/adapter/postgresql"
 	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
 type req struct {
@@ -15,9 +17,9 @@ type req struct {
 	Book   string
 }
 
-func tx1(logger *zap.Logger, session db.Session, wg *sync.WaitGroup, limiter chan struct{}, r req) {
+func tx1(logger *zap.Logger, session db.Session, wg *sync.WaitGroup, limiter *metrics.Limiter, r req) {
 	defer wg.Done()
-	defer func() { <-limiter }()
+	defer limiter.Release()
 	_ = session.Tx(func(sess db.Session) error {
 		authorID := uuid.New().String()
 		a := author{ID: authorID, Name: r.Author}
@@ -41,9 +43,9 @@ func tx1(logger *zap.Logger, session db.Session, wg *sync.WaitGroup, limiter cha
 	})
 }
 
-func tx2(logger *zap.Logger, session db.Session, wg *sync.WaitGroup, limiter chan struct{}, r req) {
+func tx2(logger *zap.Logger, session db.Session, wg *sync.WaitGroup, limiter *metrics.Limiter, r req) {
 	defer wg.Done()
-	defer func() { <-limiter }()
+	defer limiter.Release()
 	_ = session.Tx(func(sess db.Session) error {
 		authorID := uuid.New().String()
 		a := author{ID: authorID, Name: r.Author}
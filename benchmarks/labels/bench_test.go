@@ -0,0 +1,50 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+var registry = metrics.New(metrics.RegistryOpts{Prefix: "bench"})
+
+var responseTime = registry.HistogramVec("http_response_time_seconds", "Response time of the HTTP requests", "method", "path")
+
+func BenchmarkWithLabelsMap(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		responseTime.With(map[string]string{
+			"method": "GET",
+			"path":   "/m1",
+		}).Observe(0.1)
+	}
+}
+
+func BenchmarkWithLabelValues(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		responseTime.WithLabelValues("GET", "/m1").Observe(0.1)
+	}
+}
+
+func BenchmarkCurried(b *testing.B) {
+	getResponseTime := responseTime.Curry("GET")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getResponseTime.WithLabelValues("/m1").Observe(0.1)
+	}
+}
+
+func BenchmarkPreBound(b *testing.B) {
+	m1ResponseTime := responseTime.WithLabelValues("GET", "/m1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m1ResponseTime.Observe(0.1)
+	}
+}
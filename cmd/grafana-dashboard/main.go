@@ -0,0 +1,73 @@
+// Command grafana-dashboard generates a Grafana dashboard from the metrics catalog of a running application,
+// i.e: go run ./cmd/grafana-dashboard -title "Simple Metrics" -out observability/dashboards/simple-metrics.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+func main() {
+	catalogURL := flag.String("catalog", "http://localhost:8081/metrics/catalog", "the application metrics catalog url")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "the admin server bearer token")
+	title := flag.String("title", metrics.DefaultDashboardTitle, "the dashboard title")
+	uid := flag.String("uid", "", "the dashboard uid (default derived from the title)")
+	out := flag.String("out", "", "the dashboard output file, i.e: observability/dashboards/app.json (default stdout)")
+	flag.Parse()
+
+	infos, err := fetchCatalog(*catalogURL, *token)
+	if err != nil {
+		log.Fatalln("could not fetch metrics catalog:", err)
+	}
+	dashboard, err := metrics.NewDashboard(infos, metrics.DashboardOpts{Title: *title, UID: *uid})
+	if err != nil {
+		log.Fatalln("could not generate dashboard:", err)
+	}
+
+	if *out == "" {
+		_, _ = os.Stdout.Write(dashboard)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(*out), 0o755); err != nil {
+		log.Fatalln("could not create dashboard directory:", err)
+	}
+	if err := os.WriteFile(*out, dashboard, 0o644); err != nil {
+		log.Fatalln("could not write dashboard:", err)
+	}
+}
+
+func fetchCatalog(url, token string) ([]metrics.MetricInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	var infos []metrics.MetricInfo
+	if err := json.NewDecoder(res.Body).Decode(&infos); err != nil {
+		return nil, fmt.Errorf("could not decode metrics catalog: %w", err)
+	}
+	return infos, nil
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-workshops/ppp/cmd/simple-metrics/routes"
+	"github.com/go-workshops/ppp/pkg/admin"
 	"github.com/go-workshops/ppp/pkg/logging"
+	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
 func main() {
+	sloRules := flag.Bool("slo-rules", false, "print the SLO Prometheus rules and exit")
+	adminAddr := flag.String("admin-addr", admin.DefaultAddr, "the admin server address, i.e: :8081 for Prometheus to scrape from docker")
+	flag.Parse()
+
 	err := logging.Init(logging.Config{
 		LoggingLevel:  "debug",
 		LoggingOutput: []string{"stdout", "app.log"},
@@ -17,10 +30,59 @@ func main() {
 		log.Fatalln("could not initialize logger:", err)
 	}
 	defer logging.Sync()
+	metrics.SetAppName("simple-metrics")
+
+	m1SLO, err := metrics.NewSLO(metrics.SLOOpts{
+		Name:      "m1_latency",
+		Objective: 0.99,
+		Threshold: 500 * time.Millisecond,
+	})
+	if err != nil {
+		log.Fatalln("could not create slo:", err)
+	}
+	if *sloRules {
+		_, _ = os.Stdout.Write(metrics.SLORules(m1SLO))
+		return
+	}
+
+	// The metrics, profiling and health endpoints are served on a separate admin listener, away from the user traffic.
+	// Listening on loopback unless -admin-addr says otherwise, i.e: for Prometheus to scrape from docker,
+	// using the locally generated observability/admin-token bearer token.
+	// Without ADMIN_TOKEN, the profiling and logging level endpoints are only served on loopback.
+	adminSrv, err := admin.NewServer(admin.Config{
+		Addr:         *adminAddr,
+		BearerTokens: tokens(os.Getenv("ADMIN_TOKEN")),
+	})
+	if err != nil {
+		log.Fatalln("could not create admin server:", err)
+	}
 
 	srv := &http.Server{
 		Addr:    ":8080",
-		Handler: routes.NewRouter(),
+		Handler: routes.NewRouter(m1SLO),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := adminSrv.Run(ctx); err != nil {
+		log.Fatalln("could not run admin server:", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("could not shutdown server:", err)
+	}
+}
+
+func tokens(token string) []string {
+	if token == "" {
+		return nil
 	}
-	log.Fatalln(srv.ListenAndServe())
+	return []string{token}
 }
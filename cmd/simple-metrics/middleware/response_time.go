@@ -2,25 +2,29 @@ package middleware
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
-var responseTimeHistogramMetric = metrics.HistogramVecWithBuckets(
-	"http_response_time_ms",
-	[]float64{50, 100, 200, 300, 400, 500, 1000},
+// The classic buckets are still exposed alongside the native ones, until all dashboards are migrated.
+var responseTimeHistogramMetric = metrics.NativeHistogramVec(
+	"http_response_time_seconds",
+	metrics.HistogramOpts{
+		Unit:                  metrics.UnitSeconds,
+		Buckets:               []float64{.05, .1, .2, .3, .4, .5, 1},
+		NativeMaxBucketNumber: 160,
+	},
 	"Response time of the HTTP requests",
 	"path",
 )
 
 func ResponseTime(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+		timer := metrics.NewTimer(
+			responseTimeHistogramMetric.WithLabelValues(r.URL.Path),
+			metrics.WithExemplar(r.Context()),
+		)
+		defer timer.ObserveDuration()
 		h.ServeHTTP(w, r)
-		duration := float64(time.Since(start).Milliseconds())
-		responseTimeHistogramMetric.With(map[string]string{
-			"path": r.URL.Path,
-		}).Observe(duration)
 	})
 }
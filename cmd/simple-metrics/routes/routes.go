@@ -9,22 +9,27 @@ import (
 	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
-func NewRouter() http.Handler {
+func NewRouter(m1SLO *metrics.SLO) http.Handler {
 	mux := http.NewServeMux()
 	rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	mux.Handle("/metrics", metrics.PrometheusHandler())
-	mux.HandleFunc("/m1", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/m1", m1SLO.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(time.Duration(rand.Int63n(900)) * time.Millisecond)
 		w.WriteHeader(http.StatusOK)
-	})
+	})))
 	mux.HandleFunc("/m2", func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(time.Duration(rand.Int63n(900)) * time.Millisecond)
 		w.WriteHeader(http.StatusOK)
 	})
 
+	limiter := metrics.NewLimiter(metrics.LimiterOpts{
+		Name:     "http",
+		Capacity: 50,
+		Timeout:  time.Second,
+	})
 	return middleware.New(
 		mux,
 		middleware.ResponseTime,
+		limiter.Middleware,
 	)
 }
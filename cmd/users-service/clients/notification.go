@@ -2,23 +2,20 @@ package clients
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 
-	"go.uber.org/zap"
-
-	sharedContext "github.com/go-workshops/ppp/pkg/context"
-	"github.com/go-workshops/ppp/pkg/tracing"
+	"github.com/go-workshops/ppp/pkg/httpclient"
 )
 
+// ErrNotificationFailed is returned when the notification service does not respond with a 2xx status code.
+var ErrNotificationFailed = fmt.Errorf("notification failed")
+
 func NewNotification(url string) *Notification {
 	return &Notification{
-		url: url,
-		client: &http.Client{
-			Transport: &tracing.HTTPTransport{
-				Transport: http.DefaultTransport,
-			},
-		},
+		url:    url,
+		client: httpclient.New(httpclient.Opts{Target: "notification_service"}),
 	}
 }
 
@@ -27,21 +24,23 @@ type Notification struct {
 	url    string
 }
 
+// Notify sends the user notification. The failed calls are logged by the HTTP client,
+// and the returned error is left to the caller to log.
 func (c *Notification) Notify(ctx context.Context, userID string) error {
-	logger := sharedContext.Logger(ctx)
 	q := url.Values{"user_id": {userID}}
-	req, err := http.NewRequest(http.MethodGet, c.url+"/notify?"+q.Encode(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/notify?"+q.Encode(), nil)
 	if err != nil {
-		logger.Error("could not create request", zap.Error(err))
-		return err
+		return fmt.Errorf("could not create notification request: %w", err)
 	}
 
-	res, err := c.client.Do(req.WithContext(ctx))
+	res, err := c.client.Do(req)
 	if err != nil {
-		logger.Error("could not send notification request", zap.Error(err))
-		return err
+		return fmt.Errorf("could not send notification request: %w", err)
 	}
 	defer func() { _ = res.Body.Close() }()
 
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: status code %d", ErrNotificationFailed, res.StatusCode)
+	}
 	return nil
 }
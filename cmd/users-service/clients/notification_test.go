@@ -0,0 +1,35 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		err    error
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "not found", status: http.StatusNotFound, err: ErrNotificationFailed},
+		{name: "unavailable", status: http.StatusServiceUnavailable, err: ErrNotificationFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/notify" || r.URL.Query().Get("user_id") != "42" {
+					t.Errorf("unexpected notification request %s", r.URL)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			if err := NewNotification(srv.URL).Notify(context.Background(), "42"); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"time"
 
 	"go.opentelemetry.io/otel"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/go-workshops/ppp/cmd/users-service/clients"
 	"github.com/go-workshops/ppp/cmd/users-service/routes"
 	"github.com/go-workshops/ppp/cmd/users-service/services"
+	"github.com/go-workshops/ppp/pkg/admin"
 	"github.com/go-workshops/ppp/pkg/logging"
 	"github.com/go-workshops/ppp/pkg/tracing"
 )
@@ -26,24 +28,58 @@ func main() {
 		log.Fatalf("could not initialize logger: %v", err)
 	}
 
-	se, err := tracing.NewOTLPExporter("localhost:4317", 5*time.Second)
+	// The OTEL_TRACES_EXPORTER and OTEL_EXPORTER_OTLP_* variables override the local collector defaults,
+	// i.e: OTEL_TRACES_EXPORTER=console prints the spans instead. Without an endpoint, the exporters use
+	// the default endpoint of their protocol, i.e: localhost:4318 for OTEL_EXPORTER_OTLP_PROTOCOL=http/protobuf.
+	exporterCfg, err := tracing.ExporterConfigFromEnv(tracing.ExporterConfig{
+		OTLP: tracing.OTLPExporterConfig{Insecure: true, Timeout: 5 * time.Second},
+	})
+	if err != nil {
+		log.Fatalf("could not read span exporter config: %v", err)
+	}
+	exporters, err := tracing.NewSpanExporters(exporterCfg)
+	if err != nil {
+		log.Fatalf("could not initialize span exporters: %v", err)
+	}
+	// The OTEL_TRACES_SAMPLER sampler applies to every route except for the health checks, which are never sampled,
+	// and the user registrations, which are always sampled.
+	sampler, err := tracing.SamplerFromEnv()
 	if err != nil {
-		log.Fatalf("could not initialize OTLP exporter: %v", err)
+		log.Fatalf("could not initialize trace sampler: %v", err)
 	}
 	cfg := tracing.TracerProviderConfig{
 		TracingEnabled: true,
-		SpanExporter:   se,
+		Exporters:      exporters,
 		ServiceName:    "users-service",
 		BatchTimeout:   30 * time.Second,
 		ExportTimeout:  5 * time.Second,
 		MaxBatchSize:   512,
 		MaxQueueSize:   2048,
+		Sampler: tracing.NewRouteSampler([]tracing.RouteRule{
+			{Route: "/health", Sampler: traceSDK.NeverSample()},
+			{Route: "/register", Sampler: traceSDK.AlwaysSample()},
+		}, sampler),
 	}
 	provider, err := tracing.NewTracerProvider(cfg)
 	if err != nil {
 		log.Fatalf("could not initialize tracing provider: %v", err)
 	}
 	otel.SetTracerProvider(provider)
+
+	// The span exporters health is part of the readiness check, and detailed on /health/tracing.
+	adminSrv, err := admin.NewServer(admin.Config{
+		Addr:         "127.0.0.1:8011",
+		HealthChecks: map[string]admin.HealthCheck{"tracing": provider.HealthCheck},
+		Handlers:     map[string]http.Handler{"/health/tracing": provider.HealthHandler()},
+	})
+	if err != nil {
+		log.Fatalf("could not create admin server: %v", err)
+	}
+	go func() {
+		if err := adminSrv.ListenAndServe(); err != nil {
+			log.Fatalf("could not run admin server: %v", err)
+		}
+	}()
 	otel.SetTextMapPropagator(tracing.NewTextMapPropagator(ctx))
 
 	notificationClient := clients.NewNotification("http://localhost:8002")
@@ -22,9 +22,14 @@ type Config struct {
 func NewRouter(cfg Config) http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/register", instrument(register(cfg.UsersService, cfg.NotificationClient), "register_user"))
+	mux.Handle("/health", instrument(health, "health"))
 	return mux
 }
 
 func instrument(h http.HandlerFunc, operation string) http.Handler {
 	return tracing.InstrumentHTTP(tracing.HTTPMiddleware(h), operation)
 }
+
+func health(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	notificationRoutes "github.com/go-workshops/ppp/cmd/notification-service/routes"
+	"github.com/go-workshops/ppp/cmd/users-service/clients"
+	"github.com/go-workshops/ppp/cmd/users-service/services"
+	"github.com/go-workshops/ppp/pkg/tracing"
+	"github.com/go-workshops/ppp/pkg/tracing/tracingtest"
+)
+
+func TestRegisterTrace(t *testing.T) {
+	recorder := tracingtest.NewRecorderWithPropagator(t, tracing.NewTextMapPropagator(context.Background()))
+	srv := newServer(t)
+
+	res, err := http.Get(srv.URL + "/register")
+	if err != nil {
+		t.Fatalf("could not register user: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	recorder.WaitForSpan(t, "notify_user_endpoint")
+	recorder.WaitForSpan(t, "register_user_endpoint")
+	recorder.AssertTree(t, tracingtest.Span{
+		Name: "register_user_endpoint",
+		Kind: trace.SpanKindServer,
+		Children: []tracingtest.Span{
+			{
+				Name:       "register_user_txn",
+				Kind:       trace.SpanKindClient,
+				Attributes: map[string]any{"db": "postgresql"},
+				Events:     []string{"create user identity", "create user profile"},
+			},
+			{
+				Name:       "GET notification_service",
+				Kind:       trace.SpanKindClient,
+				Attributes: map[string]any{"http.request.method": "GET", "http.response.status_code": 200},
+				Children: []tracingtest.Span{
+					{
+						// The trace context crosses to the notification service.
+						Name: "notify_user_endpoint",
+						Kind: trace.SpanKindServer,
+						Children: []tracingtest.Span{
+							{
+								Name:       "notify_user",
+								Kind:       trace.SpanKindClient,
+								Attributes: map[string]any{"http": "mailgun_service", "user_id": tracingtest.Any},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestRegisterTraceLegacyHeaders(t *testing.T) {
+	recorder := tracingtest.NewRecorderWithPropagator(t, tracing.NewTextMapPropagator(context.Background()))
+	srv := newServer(t)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID := "00f067aa0ba902b7"
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/register", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set(tracing.TraceIDHeader, traceID)
+	req.Header.Set(tracing.SpanIDHeader, spanID)
+	req.Header.Set(tracing.TraceFlagsHeader, "01")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not register user: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	notify := recorder.WaitForSpan(t, "notify_user_endpoint")
+	register := recorder.WaitForSpan(t, "register_user_endpoint")
+	if got := register.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("expected trace id %s, got %s", traceID, got)
+	}
+	if got := register.Parent().SpanID().String(); got != spanID {
+		t.Errorf("expected parent span id %s, got %s", spanID, got)
+	}
+	if !register.Parent().IsRemote() {
+		t.Error("expected the remote parent span")
+	}
+	// The trace context crosses to the notification service.
+	if got := notify.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("expected notification trace id %s, got %s", traceID, got)
+	}
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	notificationSrv := httptest.NewServer(notificationRoutes.NewRouter())
+	t.Cleanup(notificationSrv.Close)
+	srv := httptest.NewServer(NewRouter(Config{
+		UsersService:       services.NewUsers(),
+		NotificationClient: clients.NewNotification(notificationSrv.URL),
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
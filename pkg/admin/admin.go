@@ -0,0 +1,311 @@
+// Package admin provides the admin HTTP server, which serves the internal endpoints
+// (metrics, profiling, logging level and health checks) on a separate listener from the user traffic,
+// so they are never exposed on the ingress.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+// Default admin server configuration values.
+const (
+	DefaultAddr            = "127.0.0.1:8081"
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
+// Admin server errors.
+var (
+	ErrInvalidAllowedIP = errors.New("invalid allowed ip")
+	ErrMissingTLSFile   = errors.New("both the tls certificate and key files are required")
+)
+
+// HealthCheck represents a readiness check, i.e: pinging the database.
+type HealthCheck func(ctx context.Context) error
+
+// Config represents the admin server configuration.
+type Config struct {
+	// Addr is the admin server listening address. (default "127.0.0.1:8081")
+	// When listening on a non loopback address without authentication nor IP allow-list,
+	// the profiling and logging level endpoints are disabled, as anyone reaching the port could use them.
+	Addr string
+
+	// MetricsHandler is the handler serving /metrics. (default metrics.PrometheusHandler())
+	MetricsHandler http.Handler
+
+	// CatalogHandler is the handler serving /metrics/catalog. (default metrics.CatalogHandler())
+	CatalogHandler http.Handler
+
+	// HealthChecks are the readiness checks run by /health/ready, by name.
+	HealthChecks map[string]HealthCheck
+
+	// Handlers are the extra endpoints served by the admin server, by path, i.e: /health/tracing.
+	Handlers map[string]http.Handler
+
+	// BasicAuthUsername and BasicAuthPassword enable basic authentication, when both are set.
+	BasicAuthUsername string
+	BasicAuthPassword string
+
+	// BearerTokens enables bearer token authentication, accepting any of the tokens.
+	// If both basic and bearer authentication are enabled, either of them is accepted.
+	BearerTokens []string
+
+	// AllowedIPs restricts the admin server to the given IPs or CIDRs, i.e: 10.0.0.0/8.
+	// The client IP is the connection remote address, the X-Forwarded-For header is not trusted.
+	// If empty, all IPs are allowed.
+	AllowedIPs []string
+
+	// TLSCertFile and TLSKeyFile enable TLS, when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ShutdownTimeout is the maximum time Run waits for the in-flight requests on shutdown. (default 10s)
+	ShutdownTimeout time.Duration
+}
+
+// Server represents the admin HTTP server.
+type Server struct {
+	cfg     Config
+	srv     *http.Server
+	allowed []*net.IPNet
+
+	// unprotected disables the profiling and logging level endpoints.
+	unprotected bool
+}
+
+// NewServer creates a new admin server, which serves:
+//   - /metrics: the application metrics.
+//   - /metrics/catalog: the application metrics catalog (type, help, unit, labels and call site).
+//   - /debug/pprof/: the runtime profiles, unless unprotected, see Config.Addr.
+//   - /log/level: the logging level, which can be changed at runtime using PUT, unless unprotected.
+//   - /health/live: the liveness check, which always succeeds while the server is up.
+//   - /health/ready: the readiness check, which runs the health checks.
+//   - the extra Handlers.
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	if cfg.MetricsHandler == nil {
+		cfg.MetricsHandler = metrics.PrometheusHandler()
+	}
+	if cfg.CatalogHandler == nil {
+		cfg.CatalogHandler = metrics.CatalogHandler()
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, ErrMissingTLSFile
+	}
+
+	s := &Server{cfg: cfg}
+	for _, ip := range cfg.AllowedIPs {
+		if !strings.Contains(ip, "/") {
+			if strings.Contains(ip, ":") {
+				ip += "/128"
+			} else {
+				ip += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAllowedIP, ip)
+		}
+		s.allowed = append(s.allowed, ipNet)
+	}
+	authenticated := (cfg.BasicAuthUsername != "" && cfg.BasicAuthPassword != "") || len(cfg.BearerTokens) > 0
+	if !authenticated && len(s.allowed) == 0 && !isLoopback(cfg.Addr) {
+		s.unprotected = true
+		logging.GetLogger().Warn(
+			"admin server is listening without authentication nor allowed ips, the profiling and logging level endpoints are disabled",
+			zap.String("addr", cfg.Addr),
+		)
+	}
+
+	s.srv = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          logging.HTTPErrorLogger(),
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	return s, nil
+}
+
+// Handler returns the admin server http.Handler, with the IP allow-list and the authentication applied.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.cfg.MetricsHandler)
+	mux.Handle("/metrics/catalog", s.cfg.CatalogHandler)
+	if !s.unprotected {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		mux.Handle("/log/level", logging.LevelHandler())
+	}
+	mux.HandleFunc("/health/live", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]string{})
+	})
+	mux.HandleFunc("/health/ready", s.ready)
+	for path, h := range s.cfg.Handlers {
+		mux.Handle(path, h)
+	}
+	return s.allow(s.authenticate(mux))
+}
+
+// ListenAndServe starts serving the admin endpoints, over TLS if configured.
+// It returns nil once the server is shut down.
+func (s *Server) ListenAndServe() error {
+	var err error
+	if s.cfg.TLSCertFile != "" {
+		err = s.srv.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	} else {
+		err = s.srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the admin server, waiting for the in-flight requests until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// Run serves the admin endpoints until ctx is done, then gracefully shuts down the admin server,
+// i.e: go func() { _ = srv.Run(signalCtx) }()
+func (s *Server) Run(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() { errs <- s.ListenAndServe() }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not shutdown admin server: %w", err)
+	}
+	return <-errs
+}
+
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	checks := map[string]string{}
+	for name, check := range s.cfg.HealthChecks {
+		if err := check(r.Context()); err != nil {
+			status = http.StatusServiceUnavailable
+			checks[name] = err.Error()
+			logging.GetLogger().Warn("health check failed", zap.String("check", name), zap.Error(err))
+			continue
+		}
+		checks[name] = "ok"
+	}
+	writeHealth(w, status, checks)
+}
+
+func writeHealth(w http.ResponseWriter, status int, checks map[string]string) {
+	res := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}{Status: "ok", Checks: checks}
+	if status != http.StatusOK {
+		res.Status = "unavailable"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// allow responds with 403 Forbidden to the clients outside the IP allow-list.
+func (s *Server) allow(h http.Handler) http.Handler {
+	if len(s.allowed) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, ipNet := range s.allowed {
+				if ipNet.Contains(ip) {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+// authenticate responds with 401 Unauthorized to the requests without valid basic or bearer credentials.
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	basic := s.cfg.BasicAuthUsername != "" && s.cfg.BasicAuthPassword != ""
+	if !basic && len(s.cfg.BearerTokens) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			for _, t := range s.cfg.BearerTokens {
+				if equal(token, t) {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		if username, password, ok := r.BasicAuth(); ok && basic {
+			// Both are always compared, so the response time does not tell which one is wrong.
+			usernameOK := equal(username, s.cfg.BasicAuthUsername)
+			passwordOK := equal(password, s.cfg.BasicAuthPassword)
+			if usernameOK && passwordOK {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// isLoopback reports whether the listening address only accepts local connections, i.e: 127.0.0.1:8081.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerAuthentication(t *testing.T) {
+	s, err := NewServer(Config{
+		MetricsHandler:    http.NotFoundHandler(),
+		BasicAuthUsername: "admin",
+		BasicAuthPassword: "secret",
+		BearerTokens:      []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("could not create admin server: %v", err)
+	}
+	h := s.Handler()
+
+	for _, tc := range []struct {
+		name   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{name: "no credentials", auth: func(*http.Request) {}, status: http.StatusUnauthorized},
+		{name: "basic", auth: func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, status: http.StatusOK},
+		{name: "wrong basic", auth: func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, status: http.StatusUnauthorized},
+		{name: "bearer", auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, status: http.StatusOK},
+		{name: "wrong bearer", auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+			tc.auth(r)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestServerAllowedIPs(t *testing.T) {
+	if _, err := NewServer(Config{AllowedIPs: []string{"not-an-ip"}}); !errors.Is(err, ErrInvalidAllowedIP) {
+		t.Errorf("expected ErrInvalidAllowedIP, got %v", err)
+	}
+
+	s, err := NewServer(Config{MetricsHandler: http.NotFoundHandler(), AllowedIPs: []string{"10.0.0.0/8", "192.168.1.10"}})
+	if err != nil {
+		t.Fatalf("could not create admin server: %v", err)
+	}
+	h := s.Handler()
+
+	for remoteAddr, status := range map[string]int{
+		"10.1.2.3:1234":     http.StatusOK,
+		"192.168.1.10:1234": http.StatusOK,
+		"192.168.1.11:1234": http.StatusForbidden,
+		"[::1]:1234":        http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("expected status %d for %s, got %d", status, remoteAddr, w.Code)
+		}
+	}
+}
+
+func TestServerUnprotected(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    Config
+		status int
+	}{
+		{name: "loopback", cfg: Config{}, status: http.StatusOK},
+		{name: "all interfaces", cfg: Config{Addr: ":8081"}, status: http.StatusNotFound},
+		{name: "authenticated", cfg: Config{Addr: ":8081", BearerTokens: []string{"token"}}, status: http.StatusOK},
+		{name: "allowed ips", cfg: Config{Addr: ":8081", AllowedIPs: []string{"192.0.2.1"}}, status: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.MetricsHandler = http.NotFoundHandler()
+			s, err := NewServer(tc.cfg)
+			if err != nil {
+				t.Fatalf("could not create admin server: %v", err)
+			}
+			h := s.Handler()
+
+			for _, path := range []string{"/debug/pprof/", "/log/level"} {
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				r.RemoteAddr = "192.0.2.1:1234"
+				r.Header.Set("Authorization", "Bearer token")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tc.status {
+					t.Errorf("expected status %d for %s, got %d", tc.status, path, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestServerHealthReady(t *testing.T) {
+	s, err := NewServer(Config{
+		MetricsHandler: http.NotFoundHandler(),
+		HealthChecks: map[string]HealthCheck{
+			"db":    func(context.Context) error { return nil },
+			"cache": func(context.Context) error { return errors.New("connection refused") },
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create admin server: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	want := `{"status":"unavailable","checks":{"cache":"connection refused","db":"ok"}}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestServerHandlers(t *testing.T) {
+	s, err := NewServer(Config{
+		MetricsHandler: http.NotFoundHandler(),
+		BearerTokens:   []string{"token"},
+		Handlers: map[string]http.Handler{
+			"/health/tracing": http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) }),
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create admin server: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/tracing", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the extra handlers to be authenticated, got %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/health/tracing", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestServerRunShutsDownGracefully(t *testing.T) {
+	s, err := NewServer(Config{Addr: "127.0.0.1:0", MetricsHandler: http.NotFoundHandler()})
+	if err != nil {
+		t.Fatalf("could not create admin server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run(ctx) }()
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("expected a graceful shutdown, got %v", err)
+	}
+}
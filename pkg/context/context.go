@@ -42,10 +42,12 @@ func Logger(ctx context.Context) *zap.Logger {
 	return logging.GetLogger().With(fields...)
 }
 
-// WithSpanContext populates the context with a tracing span context constructed from the remote trace-id and span-id.
+// WithSpanContext populates the context with a tracing span context constructed from the remote trace-id, span-id
+// and trace flags, i.e: trace.FlagsSampled.
 // Only use this when you want to populate trace-id and span-id coming from an external source, otherwise
 // the trace-id and span-id should be autogenerated.
-func WithSpanContext(ctx context.Context, traceIDHexString, spanIDHexString string) context.Context {
+// Prefer the tracing custom propagator to extract them from the legacy headers, which handles the trace-flags header.
+func WithSpanContext(ctx context.Context, traceIDHexString, spanIDHexString string, flags trace.TraceFlags) context.Context {
 	logger := Logger(ctx)
 	if traceIDHexString == "" || spanIDHexString == "" {
 		return ctx
@@ -72,7 +74,7 @@ func WithSpanContext(ctx context.Context, traceIDHexString, spanIDHexString stri
 	spanCtxCfg := trace.SpanContextConfig{
 		TraceID:    traceID,
 		SpanID:     spanID,
-		TraceFlags: trace.FlagsSampled,
+		TraceFlags: flags,
 	}
 
 	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(spanCtxCfg))
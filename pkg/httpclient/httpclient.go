@@ -0,0 +1,173 @@
+// Package httpclient provides the instrumented HTTP client shared across all Platform services,
+// which propagates the trace context, records client spans and latency metrics, and logs the failed calls.
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	sharedContext "github.com/go-workshops/ppp/pkg/context"
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+// DefaultTimeout is the default HTTP client timeout.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultTarget is the target label of the metrics when no Target is set, so that calling arbitrary hosts
+// does not create a new time series per host.
+const DefaultTarget = "unknown"
+
+// statusError is the status_code label of the calls failing without a response, i.e: connection refused.
+const statusError = "error"
+
+// Opts represents the instrumented HTTP client configuration options.
+type Opts struct {
+	// Target is the name of the called service, used as the target label of the metrics and in the span names,
+	// i.e: notification_service. (default DefaultTarget, and the span names are the request method only)
+	Target string
+
+	// Transport is the underlying round tripper. (default http.DefaultTransport)
+	Transport http.RoundTripper
+
+	// Timeout is the HTTP client timeout, see http.Client.Timeout. (default 30s)
+	Timeout time.Duration
+
+	// Registry is the metrics registry used for the client metrics. (default metrics.Default())
+	Registry *metrics.Registry
+}
+
+// New creates a new instrumented HTTP client, see NewTransport.
+func New(opts Opts) *http.Client {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{
+		Transport: NewTransport(opts),
+		Timeout:   timeout,
+	}
+}
+
+// NewTransport creates a new instrumented http.RoundTripper, based on otelhttp.Transport, which for every call:
+//   - records a client span, i.e: GET notification_service, and propagates the trace context using the global propagator.
+//     The 4xx and 5xx responses and the failed calls are errors. On top of the otelhttp attributes, the span has the
+//     http.request.method, url.full, server.address, server.port and http.response.status_code attributes.
+//     The urls are recorded without the user credentials and the query string, i.e: ?user_id=.
+//   - records the call latency in the http_client_request_duration_seconds metric, by target, method and status code,
+//     with the trace id as exemplar.
+//   - logs the 5xx responses and the failed calls, along with the trace id.
+//
+// The span ends once the response body is closed, while the latency is measured once the response headers are received.
+func NewTransport(opts Opts) http.RoundTripper {
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	r := opts.Registry
+	if r == nil {
+		r = metrics.Default()
+	}
+	target := opts.Target
+	if target == "" {
+		target = DefaultTarget
+	}
+
+	return otelhttp.NewTransport(
+		&Transport{
+			target:    target,
+			transport: transport,
+			duration: r.HistogramVecWithOpts(
+				"http_client_request_duration_seconds",
+				metrics.HistogramOpts{Unit: metrics.UnitSeconds},
+				"Duration of the HTTP client requests",
+				"target", "method", "status_code",
+			),
+		},
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			if opts.Target == "" {
+				return req.Method
+			}
+			return req.Method + " " + opts.Target
+		}),
+	)
+}
+
+// Transport represents the http.RoundTripper wrapped by otelhttp.Transport, which runs inside the client span
+// and adds the attributes, metrics and logs otelhttp does not provide.
+type Transport struct {
+	target    string
+	transport http.RoundTripper
+	duration  metrics.ObserverVecMetric
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	url := redact(req)
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLFull(url),
+		// Overrides the otelhttp http.url attribute, which keeps the query string.
+		attribute.String("http.url", url),
+	)
+	span.SetAttributes(serverAttributes(req)...)
+
+	start := time.Now()
+	res, err := t.transport.RoundTrip(req)
+	d := time.Since(start)
+
+	logFailure := func(field zap.Field) {
+		sharedContext.Logger(ctx).Error(
+			"http client call failed",
+			zap.String("target", t.target),
+			zap.String("method", req.Method),
+			zap.String("url", url),
+			zap.Duration("duration", d),
+			field,
+		)
+	}
+	if err != nil {
+		metrics.ObserveDuration(ctx, t.duration.WithLabelValues(t.target, req.Method, statusError), d, time.Second)
+		logFailure(zap.Error(err))
+		return nil, err
+	}
+
+	metrics.ObserveDuration(ctx, t.duration.WithLabelValues(t.target, req.Method, strconv.Itoa(res.StatusCode)), d, time.Second)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	if res.StatusCode >= http.StatusInternalServerError {
+		logFailure(zap.Int("status_code", res.StatusCode))
+	}
+	return res, nil
+}
+
+// redact returns the request url without the user credentials, the query string and the fragment.
+func redact(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+func serverAttributes(req *http.Request) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		return []attribute.KeyValue{semconv.ServerAddress(req.URL.Hostname())}
+	}
+	attributes := []attribute.KeyValue{semconv.ServerAddress(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attributes = append(attributes, semconv.ServerPort(p))
+	}
+	return attributes
+}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -43,6 +44,9 @@ const (
 var (
 	defaultLogger = zap.NewExample()
 	mu            sync.RWMutex
+
+	// atomicLevel is shared by all the loggers created using Init, so it can be changed at runtime.
+	atomicLevel = zap.NewAtomicLevel()
 )
 
 // Config represents logging configuration
@@ -174,6 +178,12 @@ func Sync() {
 	_ = defaultLogger.Sync()
 }
 
+// LevelHandler returns the http.Handler that gets (GET) and changes (PUT) the logging level at runtime,
+// i.e: curl -X PUT -d '{"level":"debug"}' localhost:8081/log/level
+func LevelHandler() http.Handler {
+	return atomicLevel
+}
+
 func HTTPErrorLogger() *log.Logger {
 	return log.New(&httpErrorLogger{logger: GetLogger()}, "", 0)
 }
@@ -198,7 +208,8 @@ func newConfig(level, encoding string, output []string) (zap.Config, error) {
 	}
 
 	zapConfig := zap.NewProductionConfig()
-	zapConfig.Level = zap.NewAtomicLevelAt(logLevel)
+	atomicLevel.SetLevel(logLevel)
+	zapConfig.Level = atomicLevel
 	zapConfig.OutputPaths = output
 	zapConfig.Encoding = encoding
 	zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricsDir is the directory of the metrics package, used to skip the metrics package frames of a call site.
+var metricsDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// MetricInfo represents a registered metric in the metrics catalog.
+type MetricInfo struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Unit   Unit     `json:"unit,omitempty"`
+	Labels []string `json:"labels"`
+
+	// Native reports whether the histogram is a native histogram, which Prometheus stores instead of the classic
+	// _bucket series when scraping with native histograms enabled, even if the classic buckets are exposed as well.
+	Native bool `json:"native,omitempty"`
+
+	// Site is where the metric was declared, i.e: middleware.init (response_time.go:10).
+	Site string `json:"site"`
+}
+
+// Catalog returns all the metrics declared with or registered with the registry, sorted by name.
+// The metrics declared with the lazy constructors (i.e: Counter) are listed before they are first used.
+// The collector metrics (i.e: the Go runtime metrics) are not part of the catalog, except for the SLO ones.
+func (r *Registry) Catalog() []MetricInfo {
+	r.init()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]MetricInfo, 0, len(r.descs)+len(r.declared))
+	add := func(name string, d metricDesc) {
+		infos = append(infos, MetricInfo{
+			Name:   name,
+			Type:   string(d.typ),
+			Help:   d.help,
+			Unit:   d.unit,
+			Labels: append([]string{}, d.labels...),
+			Native: d.native != nativeOpts{},
+			Site:   d.site.String(),
+		})
+	}
+	for name, d := range r.descs {
+		add(name, d)
+	}
+	for name, d := range r.declared {
+		if _, ok := r.descs[r.fqdn(name)]; !ok {
+			add(r.fqdn(name), d)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// CatalogHandler returns the http.Handler that serves the registry metrics catalog as JSON,
+// i.e: to document the metrics used by the dashboards.
+func (r *Registry) CatalogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Catalog())
+	})
+}
+
+// CatalogHandler creates a new http.Handler that serves the default registry metrics catalog as JSON.
+// The default registry is resolved on every request, so the handler follows SetDefault.
+func CatalogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Default().CatalogHandler().ServeHTTP(w, req)
+	})
+}
+
+// callSite represents the call stack of a metric declaration.
+// The program counters are only resolved to a file and line when the catalog is requested.
+type callSite struct {
+	pcs [8]uintptr
+	n   int
+}
+
+func newCallSite() callSite {
+	var s callSite
+	s.n = runtime.Callers(3, s.pcs[:])
+	return s
+}
+
+// String returns the first caller outside the metrics package, i.e: middleware.init (response_time.go:10).
+func (s callSite) String() string {
+	if s.n == 0 {
+		return ""
+	}
+	frames := runtime.CallersFrames(s.pcs[:s.n])
+	for {
+		f, more := frames.Next()
+		if filepath.Dir(f.File) != metricsDir || strings.HasSuffix(f.File, "_test.go") {
+			return path.Base(f.Function) + " (" + filepath.Base(f.File) + ":" + strconv.Itoa(f.Line) + ")"
+		}
+		if !more {
+			return ""
+		}
+	}
+}
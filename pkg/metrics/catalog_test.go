@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryUnits(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "units"})
+	for _, tc := range []struct {
+		name string
+		try  func() error
+		err  error
+	}{
+		{name: "counter with unit", try: func() error {
+			_, err := r.TryCounterWithOpts("cpu_seconds_total", CounterOpts{Unit: UnitSeconds})
+			return err
+		}},
+		{name: "counter without unit suffix", try: func() error {
+			_, err := r.TryCounterWithOpts("cpu_total", CounterOpts{Unit: UnitSeconds})
+			return err
+		}, err: ErrInvalidUnit},
+		{name: "gauge with unit", try: func() error {
+			_, err := r.TryGaugeWithOpts("heap_bytes", GaugeOpts{Unit: UnitBytes})
+			return err
+		}},
+		{name: "histogram with another unit suffix", try: func() error {
+			_, err := r.TryHistogramWithOpts("latency_ms", HistogramOpts{Unit: UnitSeconds})
+			return err
+		}, err: ErrInvalidUnit},
+		{name: "histogram with a non base unit suffix", try: func() error {
+			_, err := r.TryHistogram("response_time_ms")
+			return err
+		}, err: ErrInvalidUnit},
+		{name: "counter with a non base unit suffix", try: func() error {
+			_, err := r.TryCounter("cpu_milliseconds_total")
+			return err
+		}, err: ErrInvalidUnit},
+		{name: "gauge with an inferred unit", try: func() error {
+			_, err := r.TryGauge("memory_bytes")
+			return err
+		}},
+		{name: "summary with unit", try: func() error {
+			_, err := r.TrySummaryWithOpts("cache_hit_ratio", SummaryOpts{Unit: UnitRatio})
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.try(); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRegistryCatalog(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "catalog"})
+	r.CounterVec("requests_total", "Number of requests", "path").WithLabelValues("/").Inc()
+	r.Histogram("response_time_seconds", "Response time").Observe(0.1)
+	if _, err := r.TryGaugeWithOpts("heap_bytes", GaugeOpts{Unit: UnitBytes}, "Heap size"); err != nil {
+		t.Fatalf("could not register gauge: %v", err)
+	}
+	// Declared, but never used, so it is not registered yet, but still listed.
+	r.Summary("unused_seconds", "Unused")
+	// Invalid, which is only reported on first use.
+	r.Gauge("invalid_ms", "Invalid")
+
+	w := httptest.NewRecorder()
+	r.CatalogHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var infos []MetricInfo
+	if err := json.NewDecoder(w.Body).Decode(&infos); err != nil {
+		t.Fatalf("could not decode catalog: %v", err)
+	}
+
+	if len(infos) != 4 {
+		t.Fatalf("expected 4 metrics, got %+v", infos)
+	}
+	for i, want := range []MetricInfo{
+		{Name: "catalog_heap_bytes", Type: "gauge", Help: "Heap size", Unit: UnitBytes, Labels: []string{}},
+		{Name: "catalog_requests_total", Type: "counter", Help: "Number of requests", Labels: []string{"path"}},
+		{Name: "catalog_response_time_seconds", Type: "histogram", Help: "Response time", Unit: UnitSeconds, Labels: []string{}},
+		{Name: "catalog_unused_seconds", Type: "summary", Help: "Unused", Unit: UnitSeconds, Labels: []string{}},
+	} {
+		got := infos[i]
+		if got.Name != want.Name || got.Type != want.Type || got.Help != want.Help || got.Unit != want.Unit ||
+			strings.Join(got.Labels, ",") != strings.Join(want.Labels, ",") {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+		if !strings.HasPrefix(got.Site, "metrics.TestRegistryCatalog (catalog_test.go:") {
+			t.Errorf("expected the test to be the declaring call site, got %q", got.Site)
+		}
+	}
+}
+
+func TestDefaultCatalogListsDeclaredMetrics(t *testing.T) {
+	SetDefault(New(RegistryOpts{Prefix: "declared"}))
+	defer SetDefault(nil)
+
+	// Declared at package level, i.e: a middleware, which does not serve any request yet.
+	HistogramVec("request_duration_seconds", "Request duration", "path")
+
+	infos := Default().Catalog()
+	if len(infos) != 1 || infos[0].Name != "declared_request_duration_seconds" || infos[0].Unit != UnitSeconds {
+		t.Errorf("expected the declared histogram in the catalog, got %+v", infos)
+	}
+}
+
+func TestPrometheusProviderUnits(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "units"})
+	r.Histogram("response_time_seconds", "Response time").Observe(0.1)
+	r.CounterWithOpts("cpu_seconds_total", CounterOpts{Unit: UnitSeconds}, "CPU time").Inc()
+	r.Gauge("temperature", "Temperature").Set(21)
+
+	scrape := func(accept string) string {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		r.Handler().ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	body := scrape("application/openmetrics-text;version=1.0.0")
+	for _, line := range []string{
+		"# UNIT units_response_time_seconds seconds",
+		"# UNIT units_cpu_seconds seconds",
+		"# EOF",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "# UNIT units_temperature") {
+		t.Errorf("expected no unit for the gauge without unit, got:\n%s", body)
+	}
+	if body = scrape("text/plain"); strings.Contains(body, "# UNIT") || !strings.Contains(body, "units_response_time_seconds_count 1") {
+		t.Errorf("expected the text format without units, got:\n%s", body)
+	}
+}
@@ -0,0 +1,332 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Default dashboard configuration values.
+const (
+	DefaultDashboardTitle      = "Metrics"
+	DefaultDashboardDatasource = "prometheus"
+)
+
+// dashboardQuantiles are the quantiles of the histogram panels.
+var dashboardQuantiles = []float64{0.5, 0.9, 0.99}
+
+var dashboardUIDRE = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// DashboardOpts represents the Grafana dashboard generation options.
+type DashboardOpts struct {
+	// Title is the dashboard title. (default "Metrics")
+	Title string
+
+	// UID is the dashboard unique identifier, which keeps the dashboard URL stable. (default derived from the title)
+	UID string
+
+	// Datasource is the Prometheus datasource uid. (default "prometheus")
+	Datasource string
+}
+
+type dashboard struct {
+	UID           string         `json:"uid"`
+	Title         string         `json:"title"`
+	Tags          []string       `json:"tags"`
+	Editable      bool           `json:"editable"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Refresh       string         `json:"refresh"`
+	Time          dashboardTime  `json:"time"`
+	Templating    templating     `json:"templating"`
+	Panels        []panel        `json:"panels"`
+	Annotations   map[string]any `json:"annotations"`
+}
+
+type dashboardTime struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name       string     `json:"name"`
+	Label      string     `json:"label"`
+	Type       string     `json:"type"`
+	Datasource datasource `json:"datasource"`
+	Query      string     `json:"query"`
+	Definition string     `json:"definition"`
+	Refresh    int        `json:"refresh"`
+	Sort       int        `json:"sort"`
+	IncludeAll bool       `json:"includeAll"`
+	Multi      bool       `json:"multi"`
+	AllValue   string     `json:"allValue"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type panel struct {
+	ID          int            `json:"id"`
+	Type        string         `json:"type"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Datasource  datasource     `json:"datasource"`
+	GridPos     gridPos        `json:"gridPos"`
+	Targets     []target       `json:"targets"`
+	FieldConfig fieldConfig    `json:"fieldConfig"`
+	Options     map[string]any `json:"options,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	RefID        string     `json:"refId"`
+	Datasource   datasource `json:"datasource"`
+	Expr         string     `json:"expr"`
+	LegendFormat string     `json:"legendFormat"`
+	Format       string     `json:"format,omitempty"`
+}
+
+type fieldConfig struct {
+	Defaults  fieldDefaults `json:"defaults"`
+	Overrides []any         `json:"overrides"`
+}
+
+type fieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+// Dashboard generates a Grafana dashboard for the metrics registered with the registry.
+func (r *Registry) Dashboard(opts DashboardOpts) ([]byte, error) {
+	return NewDashboard(r.Catalog(), opts)
+}
+
+// NewDashboard generates a Grafana dashboard (JSON model) for the cataloged metrics, i.e: fetched from CatalogHandler:
+//   - counters: a timeseries panel of their per second rate.
+//   - gauges: a timeseries panel of their value.
+//   - histograms: a timeseries panel of their p50, p90 and p99 quantiles and a heatmap panel of their buckets.
+//     The native histograms are queried as such, since Prometheus does not store their classic buckets by default.
+//   - summaries: a timeseries panel of their quantiles.
+//
+// The dashboard has an app_name variable, along with a variable for every metric label, which filter all the panels.
+func NewDashboard(infos []MetricInfo, opts DashboardOpts) ([]byte, error) {
+	if opts.Title == "" {
+		opts.Title = DefaultDashboardTitle
+	}
+	if opts.UID == "" {
+		opts.UID = strings.Trim(dashboardUIDRE.ReplaceAllString(strings.ToLower(opts.Title), "-"), "-")
+	}
+	if opts.Datasource == "" {
+		opts.Datasource = DefaultDashboardDatasource
+	}
+	ds := datasource{Type: "prometheus", UID: opts.Datasource}
+
+	d := dashboard{
+		UID:           opts.UID,
+		Title:         opts.Title,
+		Tags:          []string{"generated"},
+		Editable:      true,
+		SchemaVersion: 37,
+		Refresh:       "30s",
+		Time:          dashboardTime{From: "now-1h", To: "now"},
+		Templating:    templating{List: variables(infos, ds)},
+		Panels:        []panel{},
+		Annotations:   map[string]any{"list": []any{}},
+	}
+	add := func(p panel) {
+		n := len(d.Panels)
+		p.ID = n + 1
+		p.Datasource = ds
+		p.GridPos = gridPos{H: 8, W: 12, X: (n % 2) * 12, Y: (n / 2) * 8}
+		for i := range p.Targets {
+			p.Targets[i].RefID = string(rune('A' + i))
+			p.Targets[i].Datasource = ds
+		}
+		d.Panels = append(d.Panels, p)
+	}
+
+	for _, m := range infos {
+		sel := selector(m.Labels)
+		legend := legendFormat(m.Name, m.Labels)
+
+		switch metricType(m.Type) {
+		case counterType:
+			add(panel{
+				Type:        "timeseries",
+				Title:       m.Name + " rate",
+				Description: m.Help,
+				Targets: []target{{
+					Expr:         fmt.Sprintf("%s (rate(%s%s[$__rate_interval]))", aggregate("sum", m.Labels), m.Name, sel),
+					LegendFormat: legend,
+				}},
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: grafanaUnit(m.Unit, "ops")}, Overrides: []any{}},
+			})
+		case gaugeType:
+			add(panel{
+				Type:        "timeseries",
+				Title:       m.Name,
+				Description: m.Help,
+				Targets: []target{{
+					Expr:         fmt.Sprintf("%s (%s%s)", aggregate("sum", m.Labels), m.Name, sel),
+					LegendFormat: legend,
+				}},
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: grafanaUnit(m.Unit, "short")}, Overrides: []any{}},
+			})
+		case histogramType:
+			// The native histograms are queried directly, while the classic ones are queried through their _bucket series.
+			series, by, heatmapBy, heatmapLegend := m.Name+"_bucket", append([]string{"le"}, m.Labels...), []string{"le"}, "{{le}}"
+			if m.Native {
+				series, by, heatmapBy, heatmapLegend = m.Name, m.Labels, nil, ""
+			}
+			var targets []target
+			for _, q := range dashboardQuantiles {
+				targets = append(targets, target{
+					Expr: fmt.Sprintf(
+						"histogram_quantile(%s, %s (rate(%s%s[$__rate_interval])))",
+						formatFloat(q), aggregate("sum", by), series, sel,
+					),
+					LegendFormat: strings.TrimSpace(fmt.Sprintf("p%s %s", formatFloat(q*100), labelsLegend(m.Labels))),
+				})
+			}
+			add(panel{
+				Type:        "timeseries",
+				Title:       m.Name + " quantiles",
+				Description: m.Help,
+				Targets:     targets,
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: grafanaUnit(m.Unit, "short")}, Overrides: []any{}},
+			})
+			add(panel{
+				Type:        "heatmap",
+				Title:       m.Name + " heatmap",
+				Description: m.Help,
+				Targets: []target{{
+					Expr:         fmt.Sprintf("%s (rate(%s%s[$__rate_interval]))", aggregate("sum", heatmapBy), series, sel),
+					LegendFormat: heatmapLegend,
+					Format:       "heatmap",
+				}},
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: grafanaUnit(m.Unit, "short")}, Overrides: []any{}},
+				Options: map[string]any{
+					"calculate": false,
+					"yAxis":     map[string]any{"unit": grafanaUnit(m.Unit, "short")},
+				},
+			})
+		case summaryType:
+			add(panel{
+				Type:        "timeseries",
+				Title:       m.Name + " quantiles",
+				Description: m.Help,
+				Targets: []target{{
+					Expr:         fmt.Sprintf("%s (%s%s)", aggregate("max", append([]string{"quantile"}, m.Labels...)), m.Name, sel),
+					LegendFormat: strings.TrimSpace("{{quantile}} " + labelsLegend(m.Labels)),
+				}},
+				FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: grafanaUnit(m.Unit, "short")}, Overrides: []any{}},
+			})
+		}
+	}
+
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode dashboard: %w", err)
+	}
+	return append(b, '\n'), nil
+}
+
+// variables returns the app_name variable and a variable for every metric label,
+// querying the label values of the first metric that has the label.
+func variables(infos []MetricInfo, ds datasource) []variable {
+	v := func(name, query string) variable {
+		return variable{
+			Name:       name,
+			Label:      name,
+			Type:       "query",
+			Datasource: ds,
+			Query:      query,
+			Definition: query,
+			Refresh:    2,
+			Sort:       1,
+			IncludeAll: true,
+			Multi:      true,
+			AllValue:   ".*",
+		}
+	}
+
+	vars := []variable{v(appNameLabel, fmt.Sprintf("label_values(%s)", appNameLabel))}
+	seen := map[string]string{}
+	var labels []string
+	for _, m := range infos {
+		for _, l := range m.Labels {
+			if _, ok := seen[l]; ok || l == appNameLabel {
+				continue
+			}
+			series := m.Name
+			if metricType(m.Type) == histogramType && !m.Native || metricType(m.Type) == summaryType {
+				series += "_count"
+			}
+			seen[l] = series
+			labels = append(labels, l)
+		}
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		vars = append(vars, v(l, fmt.Sprintf("label_values(%s, %s)", seen[l], l)))
+	}
+	return vars
+}
+
+// selector returns the series selector filtering by the dashboard variables, i.e: {app_name=~"$app_name",path=~"$path"}.
+func selector(labels []string) string {
+	matchers := []string{fmt.Sprintf(`%s=~"$%s"`, appNameLabel, appNameLabel)}
+	for _, l := range labels {
+		matchers = append(matchers, fmt.Sprintf(`%s=~"$%s"`, l, l))
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// aggregate returns the aggregation operator, grouped by the labels if any, i.e: sum by (path).
+func aggregate(op string, labels []string) string {
+	if len(labels) == 0 {
+		return op
+	}
+	return fmt.Sprintf("%s by (%s)", op, strings.Join(labels, ", "))
+}
+
+func legendFormat(name string, labels []string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return labelsLegend(labels)
+}
+
+func labelsLegend(labels []string) string {
+	legend := make([]string, 0, len(labels))
+	for _, l := range labels {
+		legend = append(legend, "{{"+l+"}}")
+	}
+	return strings.Join(legend, " ")
+}
+
+// grafanaUnit returns the Grafana unit of a metric unit, or the fallback unit for metrics without a unit.
+func grafanaUnit(unit Unit, fallback string) string {
+	switch unit {
+	case UnitSeconds:
+		return "s"
+	case UnitBytes:
+		return "bytes"
+	case UnitRatio:
+		return "percentunit"
+	default:
+		return fallback
+	}
+}
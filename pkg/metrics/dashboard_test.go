@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistryDashboard(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "dash"})
+	r.CounterVec("requests_total", "Number of requests", "path").WithLabelValues("/").Inc()
+	r.HistogramVec("response_time_seconds", "Response time", "path", "method").WithLabelValues("/", "GET").Observe(0.1)
+	r.Gauge("in_flight", "Requests in flight").Inc()
+	r.NativeHistogramVec("request_size_bytes", HistogramOpts{Buckets: []float64{1024}}, "Request size", "path").WithLabelValues("/").Observe(512)
+
+	b, err := r.Dashboard(DashboardOpts{Title: "Simple Metrics"})
+	if err != nil {
+		t.Fatalf("could not generate dashboard: %v", err)
+	}
+	var d dashboard
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatalf("could not decode dashboard: %v", err)
+	}
+
+	if d.UID != "simple-metrics" {
+		t.Errorf("expected uid simple-metrics, got %q", d.UID)
+	}
+	var vars []string
+	for _, v := range d.Templating.List {
+		vars = append(vars, v.Name+"="+v.Query)
+	}
+	wantVars := []string{
+		"app_name=label_values(app_name)",
+		"method=label_values(dash_response_time_seconds_count, method)",
+		"path=label_values(dash_request_size_bytes, path)",
+	}
+	if len(vars) != len(wantVars) {
+		t.Fatalf("expected variables %v, got %v", wantVars, vars)
+	}
+	for i := range wantVars {
+		if vars[i] != wantVars[i] {
+			t.Errorf("expected variable %q, got %q", wantVars[i], vars[i])
+		}
+	}
+
+	wantPanels := []struct {
+		typ, title, expr, unit string
+	}{
+		{"timeseries", "dash_in_flight", `sum (dash_in_flight{app_name=~"$app_name"})`, "short"},
+		{"timeseries", "dash_request_size_bytes quantiles", `histogram_quantile(0.5, sum by (path) (rate(dash_request_size_bytes{app_name=~"$app_name",path=~"$path"}[$__rate_interval])))`, "bytes"},
+		{"heatmap", "dash_request_size_bytes heatmap", `sum (rate(dash_request_size_bytes{app_name=~"$app_name",path=~"$path"}[$__rate_interval]))`, "bytes"},
+		{"timeseries", "dash_requests_total rate", `sum by (path) (rate(dash_requests_total{app_name=~"$app_name",path=~"$path"}[$__rate_interval]))`, "ops"},
+		{"timeseries", "dash_response_time_seconds quantiles", `histogram_quantile(0.5, sum by (le, path, method) (rate(dash_response_time_seconds_bucket{app_name=~"$app_name",path=~"$path",method=~"$method"}[$__rate_interval])))`, "s"},
+		{"heatmap", "dash_response_time_seconds heatmap", `sum by (le) (rate(dash_response_time_seconds_bucket{app_name=~"$app_name",path=~"$path",method=~"$method"}[$__rate_interval]))`, "s"},
+	}
+	if len(d.Panels) != len(wantPanels) {
+		t.Fatalf("expected %d panels, got %d", len(wantPanels), len(d.Panels))
+	}
+	for i, want := range wantPanels {
+		p := d.Panels[i]
+		if p.Type != want.typ || p.Title != want.title || p.Targets[0].Expr != want.expr || p.FieldConfig.Defaults.Unit != want.unit {
+			t.Errorf("expected panel %+v, got %s %q %q %s", want, p.Type, p.Title, p.Targets[0].Expr, p.FieldConfig.Defaults.Unit)
+		}
+	}
+	if p := d.Panels[4]; len(p.Targets) != 3 || p.Targets[2].LegendFormat != "p99 {{path}} {{method}}" {
+		t.Errorf("expected the p50, p90 and p99 quantiles, got %+v", p.Targets)
+	}
+	if p := d.Panels[3]; p.GridPos != (gridPos{H: 8, W: 12, X: 12, Y: 8}) {
+		t.Errorf("expected the 4th panel on the right of the 2nd row, got %+v", p.GridPos)
+	}
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Metric registration errors.
+var (
+	ErrInvalidName     = errors.New("invalid metric name")
+	ErrInvalidLabel    = errors.New("invalid metric label")
+	ErrInvalidUnit     = errors.New("invalid metric unit")
+	ErrDuplicateLabel  = errors.New("duplicate metric label")
+	ErrMetricConflict  = errors.New("metric conflict")
+	ErrProviderFailure = errors.New("metrics provider failure")
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// metricType represents the type of registered metric.
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+	summaryType   metricType = "summary"
+)
+
+// metricDesc describes a registered metric, used to detect conflicting registrations.
+type metricDesc struct {
+	typ    metricType
+	help   string
+	unit   Unit
+	labels []string
+
+	// buckets and native are the histogram classic buckets and native histogram options.
+	buckets []float64
+	native  nativeOpts
+
+	// site is where the metric was first declared, which is not part of the conflict detection.
+	site callSite
+}
+
+// nativeOpts represents the native histogram options, the zero value meaning the native histogram is disabled.
+type nativeOpts struct {
+	bucketFactor     float64
+	maxBucketNumber  uint32
+	zeroThreshold    float64
+	minResetDuration time.Duration
+}
+
+// ConflictError is returned when a metric is requested with a different type, help, unit, labels or buckets
+// than the ones it was first registered with.
+type ConflictError struct {
+	Name      string
+	Field     string
+	Existing  string
+	Requested string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf(
+		"metric %q is already registered with %s %s, but was requested with %s %s",
+		e.Name, e.Field, e.Existing, e.Field, e.Requested,
+	)
+}
+
+// Is makes errors.Is(err, ErrMetricConflict) report true for any ConflictError.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrMetricConflict
+}
+
+// conflict reports whether the requested metric differs from the registered one.
+// An empty requested help, unit or histogram options match any registered ones, which allows referencing
+// an existing metric without repeating them.
+func (d metricDesc) conflict(name string, requested metricDesc) error {
+	if d.typ != requested.typ {
+		return &ConflictError{Name: name, Field: "type", Existing: string(d.typ), Requested: string(requested.typ)}
+	}
+	if requested.help != "" && d.help != requested.help {
+		return &ConflictError{Name: name, Field: "help", Existing: fmt.Sprintf("%q", d.help), Requested: fmt.Sprintf("%q", requested.help)}
+	}
+	if strings.Join(d.labels, ",") != strings.Join(requested.labels, ",") {
+		return &ConflictError{Name: name, Field: "labels", Existing: fmt.Sprintf("%v", d.labels), Requested: fmt.Sprintf("%v", requested.labels)}
+	}
+	if requested.unit != "" && d.unit != requested.unit {
+		return &ConflictError{Name: name, Field: "unit", Existing: fmt.Sprintf("%q", d.unit), Requested: fmt.Sprintf("%q", requested.unit)}
+	}
+	if !d.sameHistogramOpts(requested) {
+		return &ConflictError{
+			Name:      name,
+			Field:     "histogram options",
+			Existing:  fmt.Sprintf("buckets %v native %+v", d.buckets, d.native),
+			Requested: fmt.Sprintf("buckets %v native %+v", requested.buckets, requested.native),
+		}
+	}
+	return nil
+}
+
+// sameHistogramOpts reports whether the requested histogram buckets and native options are the registered ones,
+// the requested histograms without any matching any registered ones.
+func (d metricDesc) sameHistogramOpts(requested metricDesc) bool {
+	if len(requested.buckets) == 0 && requested.native == (nativeOpts{}) {
+		return true
+	}
+	return slices.Equal(d.buckets, requested.buckets) && d.native == requested.native
+}
+
+// validate validates the metric name and labels against the Prometheus naming rules:
+// https://prometheus.io/docs/concepts/data_model/#metric-names-and-labels
+func validate(name string, constLabels map[string]string, labels []string) error {
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	seen := make(map[string]struct{}, len(labels))
+	for _, l := range labels {
+		if !labelNameRE.MatchString(l) || strings.HasPrefix(l, "__") {
+			return fmt.Errorf("%w: %q for metric %q", ErrInvalidLabel, l, name)
+		}
+		if _, ok := constLabels[l]; ok {
+			return fmt.Errorf("%w: %q for metric %q is already a const label", ErrDuplicateLabel, l, name)
+		}
+		if _, ok := seen[l]; ok {
+			return fmt.Errorf("%w: %q for metric %q", ErrDuplicateLabel, l, name)
+		}
+		seen[l] = struct{}{}
+	}
+	return nil
+}
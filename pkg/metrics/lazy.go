@@ -0,0 +1,297 @@
+package metrics
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The lazy metric types defer creating the underlying metric until it is first used.
+// This allows declaring metrics as package level variables, while still being able to
+// configure the registry (prefix, const labels, provider) later on application setup.
+// The registry itself is also resolved on first use, which is what makes the package
+// level functions follow SetDefault. The metrics are still declared with the registry
+// they would resolve to right away, so the catalog lists them before they are first used.
+
+func lazyCounterVecOf(registry func() *Registry, name, help string, opts CounterOpts, labels ...string) CounterVecMetric {
+	site := newCallSite()
+	registry().declare(name, metricDesc{typ: counterType, help: help, unit: opts.Unit, labels: labels, site: site})
+	return &lazyCounterVec{resolve: func() CounterVecMetric {
+		r := registry()
+		vec, err := r.counter(name, help, opts, site, labels...)
+		if err != nil {
+			r.logError(err)
+			return noopCounterVec{}
+		}
+		return vec
+	}}
+}
+
+type lazyCounterVec struct {
+	once    sync.Once
+	resolve func() CounterVecMetric
+	vec     CounterVecMetric
+}
+
+func (c *lazyCounterVec) get() CounterVecMetric {
+	c.once.Do(func() { c.vec = c.resolve() })
+	return c.vec
+}
+
+func (c *lazyCounterVec) With(labels map[string]string) CounterMetric {
+	return c.get().With(labels)
+}
+
+func (c *lazyCounterVec) WithLabelValues(values ...string) CounterMetric {
+	return c.get().WithLabelValues(values...)
+}
+
+func (c *lazyCounterVec) Curry(values ...string) CounterVecMetric {
+	return &lazyCounterVec{resolve: func() CounterVecMetric {
+		return c.get().Curry(values...)
+	}}
+}
+
+type lazyCounter struct {
+	once sync.Once
+	vec  CounterVecMetric
+	m    CounterMetric
+}
+
+func (c *lazyCounter) get() CounterMetric {
+	c.once.Do(func() { c.m = c.vec.With(map[string]string{}) })
+	return c.m
+}
+
+func (c *lazyCounter) Inc() {
+	c.get().Inc()
+}
+
+func (c *lazyCounter) Add(v float64) {
+	c.get().Add(v)
+}
+
+func lazyGaugeVecOf(registry func() *Registry, name, help string, opts GaugeOpts, labels ...string) GaugeVecMetric {
+	site := newCallSite()
+	registry().declare(name, metricDesc{typ: gaugeType, help: help, unit: opts.Unit, labels: labels, site: site})
+	return &lazyGaugeVec{resolve: func() GaugeVecMetric {
+		r := registry()
+		vec, err := r.gauge(name, help, opts, site, labels...)
+		if err != nil {
+			r.logError(err)
+			return noopGaugeVec{}
+		}
+		return vec
+	}}
+}
+
+type lazyGaugeVec struct {
+	once    sync.Once
+	resolve func() GaugeVecMetric
+	vec     GaugeVecMetric
+}
+
+func (g *lazyGaugeVec) get() GaugeVecMetric {
+	g.once.Do(func() { g.vec = g.resolve() })
+	return g.vec
+}
+
+func (g *lazyGaugeVec) With(labels map[string]string) GaugeMetric {
+	return g.get().With(labels)
+}
+
+func (g *lazyGaugeVec) WithLabelValues(values ...string) GaugeMetric {
+	return g.get().WithLabelValues(values...)
+}
+
+func (g *lazyGaugeVec) Curry(values ...string) GaugeVecMetric {
+	return &lazyGaugeVec{resolve: func() GaugeVecMetric {
+		return g.get().Curry(values...)
+	}}
+}
+
+func (g *lazyGaugeVec) DeleteLabelValues(values ...string) bool {
+	return g.get().DeleteLabelValues(values...)
+}
+
+type lazyGauge struct {
+	once sync.Once
+	vec  GaugeVecMetric
+	m    GaugeMetric
+}
+
+func (g *lazyGauge) get() GaugeMetric {
+	g.once.Do(func() { g.m = g.vec.With(map[string]string{}) })
+	return g.m
+}
+
+func (g *lazyGauge) Set(v float64) {
+	g.get().Set(v)
+}
+
+func (g *lazyGauge) Inc() {
+	g.get().Inc()
+}
+
+func (g *lazyGauge) Dec() {
+	g.get().Dec()
+}
+
+func (g *lazyGauge) Add(v float64) {
+	g.get().Add(v)
+}
+
+func (g *lazyGauge) Sub(v float64) {
+	g.get().Sub(v)
+}
+
+func (g *lazyGauge) SetToCurrentTime() {
+	g.get().SetToCurrentTime()
+}
+
+func lazyHistogramVecOf(registry func() *Registry, name, help string, opts HistogramOpts, labels ...string) ObserverVecMetric {
+	site := newCallSite()
+	d := opts.desc(help, labels)
+	d.site = site
+	registry().declare(name, d)
+	return &lazyObserverVec{resolve: func() ObserverVecMetric {
+		r := registry()
+		vec, err := r.histogram(name, help, opts, site, labels...)
+		if err != nil {
+			r.logError(err)
+			return noopObserverVec{}
+		}
+		return vec
+	}}
+}
+
+func lazySummaryVecOf(registry func() *Registry, name, help string, opts SummaryOpts, labels ...string) ObserverVecMetric {
+	site := newCallSite()
+	registry().declare(name, metricDesc{typ: summaryType, help: help, unit: opts.Unit, labels: labels, site: site})
+	return &lazyObserverVec{resolve: func() ObserverVecMetric {
+		r := registry()
+		vec, err := r.summary(name, help, opts, site, labels...)
+		if err != nil {
+			r.logError(err)
+			return noopObserverVec{}
+		}
+		return vec
+	}}
+}
+
+type lazyObserverVec struct {
+	once    sync.Once
+	resolve func() ObserverVecMetric
+	vec     ObserverVecMetric
+}
+
+func (o *lazyObserverVec) get() ObserverVecMetric {
+	o.once.Do(func() { o.vec = o.resolve() })
+	return o.vec
+}
+
+func (o *lazyObserverVec) With(labels map[string]string) ObserverMetric {
+	return o.get().With(labels)
+}
+
+func (o *lazyObserverVec) WithLabelValues(values ...string) ObserverMetric {
+	return o.get().WithLabelValues(values...)
+}
+
+func (o *lazyObserverVec) Curry(values ...string) ObserverVecMetric {
+	return &lazyObserverVec{resolve: func() ObserverVecMetric {
+		return o.get().Curry(values...)
+	}}
+}
+
+type lazyObserver struct {
+	once sync.Once
+	vec  ObserverVecMetric
+	m    ObserverMetric
+}
+
+func (o *lazyObserver) get() ObserverMetric {
+	o.once.Do(func() { o.m = o.vec.With(map[string]string{}) })
+	return o.m
+}
+
+func (o *lazyObserver) Observe(v float64) {
+	o.get().Observe(v)
+}
+
+func (o *lazyObserver) ObserveWithExemplar(v float64, exemplar prometheus.Labels) {
+	if eo, ok := o.get().(prometheus.ExemplarObserver); ok {
+		eo.ObserveWithExemplar(v, exemplar)
+		return
+	}
+	o.get().Observe(v)
+}
+
+// The package level functions are often called right where the metric is used, i.e: on every request
+// of an HTTP middleware, so they return a cached handle per metric name instead of a new lazy metric each time.
+// A request with a different help, unit, labels or histogram options bypasses the cache,
+// so the conflict is still reported on first use.
+var (
+	handlesMu sync.RWMutex
+	handles   = map[handleKey]handle{}
+)
+
+type handleKey struct {
+	typ    metricType
+	name   string
+	scalar bool
+}
+
+type handle struct {
+	metric any
+	desc   metricDesc
+}
+
+// defaultHandle returns the cached handle of the package level metric, or creates and caches a new one.
+// Only the help, unit, labels and histogram options of d are used.
+func defaultHandle(key handleKey, d metricDesc, create func() any) any {
+	handlesMu.RLock()
+	h, ok := handles[key]
+	handlesMu.RUnlock()
+	if ok && h.matches(d) {
+		return h.metric
+	}
+
+	m := create()
+	if ok {
+		return m
+	}
+
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	if h, ok := handles[key]; ok {
+		return h.metric
+	}
+	// The cached desc is a copy, so that d does not escape and the cache hits do not allocate.
+	handles[key] = handle{metric: m, desc: metricDesc{
+		help:    strings.Clone(d.help),
+		unit:    Unit(strings.Clone(string(d.unit))),
+		labels:  append([]string{}, d.labels...),
+		buckets: append([]float64{}, d.buckets...),
+		native:  d.native,
+	}}
+	return m
+}
+
+// matches reports whether the requested metric is the cached one, an empty help, unit or histogram options
+// matching any ones. Unlike metricDesc.conflict, it does not allocate, since it runs on every package level call.
+func (h handle) matches(d metricDesc) bool {
+	if d.help != "" && d.help != h.desc.help || d.unit != "" && d.unit != h.desc.unit {
+		return false
+	}
+	return slices.Equal(d.labels, h.desc.labels) && h.desc.sameHistogramOpts(d)
+}
+
+// resetHandles drops the cached handles, so the package level functions follow SetDefault.
+func resetHandles() {
+	handlesMu.Lock()
+	handles = map[handleKey]handle{}
+	handlesMu.Unlock()
+}
@@ -0,0 +1,331 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default leak detector configuration values.
+const (
+	DefaultLeakInterval        = 10 * time.Second
+	DefaultLeakMinInterval     = time.Second
+	DefaultLeakWindows         = 6
+	DefaultLeakMinGoroutines   = 10
+	DefaultLeakMaxSampleStacks = 3
+)
+
+// unknownSite is the creation site of goroutines that were not created by a go statement, i.e: the main goroutine.
+const unknownSite = "unknown"
+
+// LeakDetectorOpts represents the goroutine leak detector configuration options.
+type LeakDetectorOpts struct {
+	// Interval is the interval between goroutine samples. (default 10s)
+	Interval time.Duration
+
+	// MinInterval is the minimum interval between the goroutine samples taken by Sample, which returns right away
+	// if the last sample is more recent. Every sample stops the world to dump the stacks of all the goroutines,
+	// which gets slower the more goroutines leak. (default 1s)
+	MinInterval time.Duration
+
+	// Windows is the number of consecutive sampling windows the goroutine count of a creation site
+	// must grow in, for the site to be suspected of leaking. (default 6)
+	Windows int
+
+	// MinGoroutines is the number of goroutines a creation site must have, for the site to be suspected of leaking.
+	// It keeps short bursts of a few goroutines from being reported. (default 10)
+	MinGoroutines int
+
+	// MaxSampleStacks is the maximum number of goroutine stacks reported for every suspected site. (default 3)
+	MaxSampleStacks int
+
+	// Registry is the metrics registry used for the leak detector metrics. (default Default())
+	Registry *Registry
+}
+
+// LeakReport represents the goroutine leak detector report.
+type LeakReport struct {
+	// Time is the time of the last goroutine sample.
+	Time time.Time `json:"time"`
+
+	// Goroutines is the total number of goroutines in the last sample.
+	Goroutines int `json:"goroutines"`
+
+	// Suspects are the creation sites suspected of leaking goroutines, the ones with the most goroutines first.
+	Suspects []LeakSuspect `json:"suspects"`
+}
+
+// LeakSuspect represents a goroutine creation site suspected of leaking goroutines.
+type LeakSuspect struct {
+	// Site is the goroutine creation site, i.e: workers.Process (process.go:23).
+	Site string `json:"site"`
+
+	// Goroutines is the number of goroutines created by the site in the last sample.
+	Goroutines int `json:"goroutines"`
+
+	// History is the number of goroutines created by the site in every sampling window, the oldest first.
+	History []int `json:"history"`
+
+	// Stacks are sample stacks of the goroutines created by the site.
+	Stacks []string `json:"stacks"`
+}
+
+// LeakDetector represents a goroutine leak detector, which periodically samples the goroutine stacks
+// and groups them by creation site (the go statement that started them). Sites whose goroutine count
+// grows in every one of the last sampling windows are suspected of leaking, i.e: goroutines that outlive their context.
+// The detector exports the following metrics:
+//   - goroutines_by_site: the number of goroutines by creation site.
+//   - goroutine_leak_suspects: the number of creation sites suspected of leaking goroutines.
+type LeakDetector struct {
+	opts LeakDetectorOpts
+
+	goroutines GaugeVecMetric
+	suspects   GaugeMetric
+
+	// sampleMu serializes the samples, guarding buf and last.
+	sampleMu sync.Mutex
+	buf      []byte
+	last     time.Time
+	now      func() time.Time
+
+	mu     sync.Mutex
+	sites  map[string]*leakSite
+	report LeakReport
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type leakSite struct {
+	history []int
+	stacks  []string
+}
+
+// NewLeakDetector creates a new goroutine leak detector, which starts sampling right away.
+// Call Stop on application shutdown.
+func NewLeakDetector(opts LeakDetectorOpts) *LeakDetector {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultLeakInterval
+	}
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = DefaultLeakMinInterval
+	}
+	if opts.Windows < 1 {
+		opts.Windows = DefaultLeakWindows
+	}
+	if opts.MinGoroutines < 1 {
+		opts.MinGoroutines = DefaultLeakMinGoroutines
+	}
+	if opts.MaxSampleStacks < 1 {
+		opts.MaxSampleStacks = DefaultLeakMaxSampleStacks
+	}
+	r := opts.Registry
+	if r == nil {
+		r = Default()
+	}
+
+	d := &LeakDetector{
+		opts:       opts,
+		goroutines: r.GaugeVec("goroutines_by_site", "Number of goroutines by creation site", "site"),
+		suspects:   r.Gauge("goroutine_leak_suspects", "Number of goroutine creation sites suspected of leaking"),
+		sites:      map[string]*leakSite{},
+		report:     LeakReport{Suspects: []LeakSuspect{}},
+		now:        time.Now,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Sample samples the goroutine stacks right away and updates the metrics and the report,
+// unless the last sample is more recent than MinInterval.
+func (d *LeakDetector) Sample() {
+	d.sampleMu.Lock()
+	defer d.sampleMu.Unlock()
+	if now := d.now(); now.Sub(d.last) >= d.opts.MinInterval {
+		d.sample(now)
+	}
+}
+
+// sample samples the goroutine stacks, reusing the stack buffer of the previous samples.
+func (d *LeakDetector) sample(now time.Time) {
+	d.last = now
+	var gs []goroutineStack
+	gs, d.buf = goroutineStacks(d.buf)
+
+	counts := map[string]int{}
+	stacks := map[string][]string{}
+	total := 0
+	for _, g := range gs {
+		total++
+		counts[g.site]++
+		if len(stacks[g.site]) < d.opts.MaxSampleStacks {
+			stacks[g.site] = append(stacks[g.site], g.stack)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name := range counts {
+		if _, ok := d.sites[name]; !ok {
+			d.sites[name] = &leakSite{}
+		}
+	}
+
+	report := LeakReport{Time: now, Goroutines: total, Suspects: []LeakSuspect{}}
+	for name, s := range d.sites {
+		count := counts[name]
+		s.history = append(s.history, count)
+		if len(s.history) > d.opts.Windows+1 {
+			s.history = s.history[len(s.history)-d.opts.Windows-1:]
+		}
+		s.stacks = stacks[name]
+
+		if count == 0 && allZero(s.history) {
+			delete(d.sites, name)
+			d.goroutines.DeleteLabelValues(name)
+			continue
+		}
+		d.goroutines.WithLabelValues(name).Set(float64(count))
+		if d.leaking(s) {
+			report.Suspects = append(report.Suspects, LeakSuspect{
+				Site:       name,
+				Goroutines: count,
+				History:    append([]int{}, s.history...),
+				Stacks:     s.stacks,
+			})
+		}
+	}
+	sort.Slice(report.Suspects, func(i, j int) bool {
+		if report.Suspects[i].Goroutines != report.Suspects[j].Goroutines {
+			return report.Suspects[i].Goroutines > report.Suspects[j].Goroutines
+		}
+		return report.Suspects[i].Site < report.Suspects[j].Site
+	})
+
+	d.report = report
+	d.suspects.Set(float64(len(report.Suspects)))
+}
+
+// Report returns the report of the last goroutine sample.
+func (d *LeakDetector) Report() LeakReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.report
+}
+
+// Handler returns the http.Handler that serves the leak report as JSON.
+func (d *LeakDetector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d.Report())
+	})
+}
+
+// Stop stops the periodic goroutine sampling.
+func (d *LeakDetector) Stop(ctx context.Context) error {
+	d.once.Do(func() { close(d.stop) })
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *LeakDetector) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.sampleMu.Lock()
+			d.sample(d.now())
+			d.sampleMu.Unlock()
+		}
+	}
+}
+
+// leaking reports whether the site goroutine count grew in every one of the last sampling windows.
+func (d *LeakDetector) leaking(s *leakSite) bool {
+	if len(s.history) < d.opts.Windows+1 || s.history[len(s.history)-1] < d.opts.MinGoroutines {
+		return false
+	}
+	for i := 1; i < len(s.history); i++ {
+		if s.history[i] <= s.history[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero(history []int) bool {
+	for _, c := range history {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type goroutineStack struct {
+	site  string
+	stack string
+}
+
+// goroutineStacks returns the stacks of all the goroutines, along with their creation site.
+// It dumps the stacks into buf, growing it if needed, and returns it to be reused by the next call.
+func goroutineStacks(buf []byte) ([]goroutineStack, []byte) {
+	if len(buf) == 0 {
+		buf = make([]byte, 64*1024)
+	}
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return parseGoroutineStacks(buf[:n]), buf
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// parseGoroutineStacks parses the runtime.Stack output, where every goroutine stack is separated by an empty line:
+//
+//	goroutine 7 [sleep]:
+//	time.Sleep(0x3b9aca00)
+//		/usr/local/go/src/runtime/time.go:195 +0x125
+//	created by main.main in goroutine 1
+//		/app/main.go:9 +0x25
+func parseGoroutineStacks(buf []byte) []goroutineStack {
+	var gs []goroutineStack
+	for _, stack := range bytes.Split(bytes.TrimSpace(buf), []byte("\n\n")) {
+		g := goroutineStack{site: unknownSite, stack: string(stack)}
+		sc := bufio.NewScanner(bytes.NewReader(stack))
+		for sc.Scan() {
+			fn, ok := strings.CutPrefix(sc.Text(), "created by ")
+			if !ok || !sc.Scan() {
+				continue
+			}
+			fn, _, _ = strings.Cut(fn, " in goroutine ")
+			file, _, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+			g.site = path.Base(fn) + " (" + filepath.Base(file) + ")"
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
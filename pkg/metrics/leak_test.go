@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoroutineStacks(t *testing.T) {
+	buf := []byte(`goroutine 1 [running]:
+main.main()
+	/app/main.go:12 +0x1d
+
+goroutine 7 [sleep]:
+time.Sleep(0x3b9aca00)
+	/usr/local/go/src/runtime/time.go:195 +0x125
+github.com/go-workshops/ppp/playground/pprof-mem-leak-subtle/workers.Leak({0x0?, 0x0?}, 0x0?)
+	/app/workers/leak.go:11 +0x26
+created by github.com/go-workshops/ppp/playground/pprof-mem-leak-subtle/workers.Process in goroutine 6
+	/app/workers/process.go:23 +0x8a
+`)
+
+	gs := parseGoroutineStacks(buf)
+	if len(gs) != 2 {
+		t.Fatalf("expected 2 goroutines, got %d", len(gs))
+	}
+	if gs[0].site != unknownSite {
+		t.Errorf("expected the main goroutine site to be %q, got %q", unknownSite, gs[0].site)
+	}
+	if want := "workers.Process (process.go:23)"; gs[1].site != want {
+		t.Errorf("expected site %q, got %q", want, gs[1].site)
+	}
+	if !strings.HasPrefix(gs[1].stack, "goroutine 7 [sleep]:") {
+		t.Errorf("expected the goroutine stack, got %q", gs[1].stack)
+	}
+}
+
+func TestLeakDetector(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "leak"})
+	d := NewLeakDetector(LeakDetectorOpts{Interval: time.Hour, Windows: 3, MinGoroutines: 4, MaxSampleStacks: 2, Registry: r})
+	defer func() { _ = d.Stop(context.Background()) }()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	stop := make(chan struct{})
+	defer close(stop)
+	leak := func(n int) {
+		for i := 0; i < n; i++ {
+			go func() { <-stop }()
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		leak(2)
+		time.Sleep(10 * time.Millisecond)
+		now = now.Add(time.Second)
+		d.Sample()
+	}
+
+	report := d.Report()
+	if len(report.Suspects) != 1 {
+		t.Fatalf("expected 1 suspected site, got %+v", report.Suspects)
+	}
+	s := report.Suspects[0]
+	if !strings.Contains(s.Site, "TestLeakDetector") {
+		t.Errorf("expected the test to be the suspected site, got %q", s.Site)
+	}
+	if s.Goroutines != 8 || len(s.History) != 4 || len(s.Stacks) != 2 {
+		t.Errorf("unexpected suspect: %+v", s)
+	}
+
+	w := httptest.NewRecorder()
+	d.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var got LeakReport
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode the report: %v", err)
+	}
+	if len(got.Suspects) != 1 || got.Suspects[0].Site != s.Site {
+		t.Errorf("unexpected report: %+v", got)
+	}
+
+	body := scrape(t, r)
+	for _, want := range []string{
+		`leak_goroutine_leak_suspects 1`,
+		`leak_goroutines_by_site{site="` + s.Site + `"} 8`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+
+	// The samples are rate limited.
+	d.Sample()
+	if got := d.Report(); !got.Time.Equal(report.Time) || len(got.Suspects[0].History) != 4 {
+		t.Errorf("expected the sample to be skipped, got %+v", got)
+	}
+
+	// A site that stops growing is no longer suspected.
+	now = now.Add(time.Second)
+	d.Sample()
+	if report := d.Report(); len(report.Suspects) != 0 {
+		t.Errorf("expected no suspected sites, got %+v", report.Suspects)
+	}
+}
+
+func TestLeakDetectorStaleSites(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "leak"})
+	d := NewLeakDetector(LeakDetectorOpts{Interval: time.Hour, Windows: 1, Registry: r})
+	defer func() { _ = d.Stop(context.Background()) }()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	sample := func() {
+		now = now.Add(time.Second)
+		d.Sample()
+	}
+
+	stop := make(chan struct{})
+	go func() { <-stop }()
+	time.Sleep(10 * time.Millisecond)
+	sample()
+	if body := scrape(t, r); !strings.Contains(body, "TestLeakDetectorStaleSites") {
+		t.Fatalf("expected the test site gauge, got:\n%s", body)
+	}
+
+	// The site gauge is deleted once the site has had no goroutines for every window.
+	close(stop)
+	time.Sleep(10 * time.Millisecond)
+	sample()
+	sample()
+	if body := scrape(t, r); strings.Contains(body, "TestLeakDetectorStaleSites") {
+		t.Errorf("expected the test site gauge to be deleted, got:\n%s", body)
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiterAcquire(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "limiter"})
+	l := NewLimiter(LimiterOpts{Name: "test", Capacity: 1, Timeout: 10 * time.Millisecond, Registry: r})
+
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("expected a free slot, got %v", err)
+	}
+	if err := l.Acquire(context.Background()); !errors.Is(err, ErrLimiterTimeout) {
+		t.Errorf("expected ErrLimiterTimeout, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	l.Release()
+	if l.InFlight() != 0 {
+		t.Errorf("expected no operations in flight, got %d", l.InFlight())
+	}
+
+	body := scrape(t, r)
+	for _, want := range []string{
+		`limiter_limiter_capacity{limiter="test"} 1`,
+		`limiter_limiter_in_flight{limiter="test"} 0`,
+		`limiter_limiter_rejected_total{limiter="test",reason="timeout"} 1`,
+		`limiter_limiter_rejected_total{limiter="test",reason="canceled"} 1`,
+		`limiter_limiter_wait_seconds_count{limiter="test"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "shedding"})
+	l := NewLimiter(LimiterOpts{Name: "http", Capacity: 1, Registry: r})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 OK, got %d", w.Code)
+	}
+
+	l.TryAcquire()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the request to be shed with 503, got %d", w.Code)
+	}
+}
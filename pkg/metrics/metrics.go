@@ -1,13 +1,19 @@
 // Package metrics provides a simple wrapper around Prometheus metrics
 // with easy to create and reuse metrics helper functions.
+// The package level functions are thin wrappers over the Default Registry,
+// use New to create a standalone Registry with its own prefix, const labels and provider.
 // Check out the examples file, for a more detailed list of various Prometheus metrics and how to use them:
 // https://github.com/prometheus/client_golang/blob/main/prometheus/examples_test.go
 package metrics
 
 import (
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
 )
 
 const appNameLabel = "app_name"
@@ -17,38 +23,49 @@ var (
 	// i.e: DefaultPrefix_your_metric_name
 	DefaultPrefix = "ppp"
 
-	// DefaultProvider represents the default metrics provider.
-	DefaultProvider Provider = NewPrometheusProvider(PrometheusProviderOpts{})
+	// DefaultProvider represents the provider used by the default registry.
+	// If nil, the default registry creates its own Prometheus provider.
+	DefaultProvider Provider
 
 	// ConstLabels are the default const labels applied all newly registered metrics.
-	// Make sure to set all the necessary labels (on application setup) before making use of or creating any metrics.
+	// Make sure to set all the necessary labels (on application setup) before making use of any metrics,
+	// the labels set after the default registry was initialized are ignored (and logged).
 	ConstLabels = ConstMetricLabels{labels: map[string]string{}}
 )
 
-var (
-	mu         sync.Mutex
-	counters   = map[string]CounterVecMetric{}
-	gauges     = map[string]GaugeVecMetric{}
-	histograms = map[string]ObserverVecMetric{}
-	summaries  = map[string]ObserverVecMetric{}
-)
-
-// SetAppName sets the application name for the default metrics provider.
+// SetAppName sets the application name for the default metrics registry.
 // This will create a const label with the key AppNameLabel for every registered metric.
 func SetAppName(appName string) {
 	ConstLabels.Set(appNameLabel, appName)
 }
 
-// GetAppName returns the application name for the default metrics provider.
+// GetAppName returns the application name for the default metrics registry.
 func GetAppName() string {
 	appName, _ := ConstLabels.Get(appNameLabel)
 	return appName
 }
 
+// The vector metrics can be used in two ways:
+//   - With(map[string]string{"label_name": "label_value"}) is the most readable way, but it allocates
+//     and hashes a map on every call, which adds up in hot paths, i.e: HTTP middlewares.
+//   - WithLabelValues("label_value") takes the label values in the same order the labels were declared,
+//     which avoids the map allocation. Use it for metrics observed on every request.
+//
+// Curry("label_value") binds the first label values up front and returns a vector metric for the remaining labels.
+// With, WithLabelValues and Curry panic if the label names or number of label values do not match the declared labels.
+//
+// The gauge vectors can also DeleteLabelValues, to stop exporting the gauges of the label values that went away,
+// i.e: the goroutine creation sites that no longer run any goroutine.
+//
+// Every constructor infers the metric unit from the name suffix (i.e: _seconds, _bytes, _ratio), and rejects the names
+// ending with a non base unit (i.e: _ms), use the *WithOpts constructors to set the unit explicitly.
+
 // CounterVecMetric represents a vector counter metric containing a variation
 // of the same metric under different labels.
 type CounterVecMetric interface {
 	With(labels map[string]string) CounterMetric
+	WithLabelValues(values ...string) CounterMetric
+	Curry(values ...string) CounterVecMetric
 }
 
 // CounterMetric represents a counter metric.
@@ -61,6 +78,9 @@ type CounterMetric interface {
 // of the same metric under different labels.
 type GaugeVecMetric interface {
 	With(labels map[string]string) GaugeMetric
+	WithLabelValues(values ...string) GaugeMetric
+	Curry(values ...string) GaugeVecMetric
+	DeleteLabelValues(values ...string) bool
 }
 
 // GaugeMetric represents a gauge metric.
@@ -77,6 +97,8 @@ type GaugeMetric interface {
 // of the same metric under different labels.
 type ObserverVecMetric interface {
 	With(labels map[string]string) ObserverMetric
+	WithLabelValues(values ...string) ObserverMetric
+	Curry(values ...string) ObserverVecMetric
 }
 
 // ObserverMetric represents a Histogram / Summary metric.
@@ -84,12 +106,102 @@ type ObserverMetric interface {
 	Observe(float64)
 }
 
+// CounterOpts represents the counter configuration options.
+type CounterOpts struct {
+	// Unit is the counter unit, i.e: UnitSeconds. If set, the name must end with the unit suffix, i.e: _seconds_total.
+	// If empty, the unit is inferred from the name suffix.
+	Unit Unit
+}
+
+// GaugeOpts represents the gauge configuration options.
+type GaugeOpts struct {
+	// Unit is the gauge unit, i.e: UnitBytes. If set, the name must end with the unit suffix, i.e: _bytes.
+	// If empty, the unit is inferred from the name suffix.
+	Unit Unit
+}
+
+// DefaultNativeBucketFactor is the default native histogram bucket factor,
+// which results in a maximum of ~10% relative error between the bucket boundaries.
+const DefaultNativeBucketFactor = 1.1
+
+// HistogramOpts represents the histogram configuration options.
+type HistogramOpts struct {
+	// Unit is the histogram unit, i.e: UnitSeconds. If set, the name must end with the unit suffix, i.e: _seconds.
+	// If empty, the unit is inferred from the name suffix.
+	Unit Unit
+
+	// Buckets are the classic histogram buckets.
+	// If empty and the native histogram is disabled, the default Prometheus buckets are used.
+	Buckets []float64
+
+	// NativeBucketFactor enables native (sparse) histograms, when greater than 1.
+	// Every native bucket boundary is at most NativeBucketFactor times bigger than the previous one.
+	NativeBucketFactor float64
+
+	// NativeMaxBucketNumber limits the number of native buckets. Zero means no limit.
+	NativeMaxBucketNumber uint32
+
+	// NativeZeroThreshold is the absolute value under which observations are accumulated into the zero bucket.
+	// If zero, the default Prometheus zero threshold is used.
+	NativeZeroThreshold float64
+
+	// NativeMinResetDuration is the minimum duration after which the native buckets are reset,
+	// when NativeMaxBucketNumber is exceeded.
+	NativeMinResetDuration time.Duration
+}
+
+// desc describes the histogram configured with the options, to detect the conflicting registrations.
+func (o HistogramOpts) desc(help string, labels []string) metricDesc {
+	d := metricDesc{typ: histogramType, help: help, unit: o.Unit, labels: labels, buckets: o.Buckets}
+	if o.NativeBucketFactor > 1 {
+		d.native = nativeOpts{
+			bucketFactor:     o.NativeBucketFactor,
+			maxBucketNumber:  o.NativeMaxBucketNumber,
+			zeroThreshold:    o.NativeZeroThreshold,
+			minResetDuration: o.NativeMinResetDuration,
+		}
+	}
+	return d
+}
+
+// native returns a copy of the histogram options with the native histogram enabled.
+func (o HistogramOpts) native() HistogramOpts {
+	if o.NativeBucketFactor <= 1 {
+		o.NativeBucketFactor = DefaultNativeBucketFactor
+	}
+	return o
+}
+
+// SummaryOpts represents the summary configuration options.
+type SummaryOpts struct {
+	// Unit is the summary unit, i.e: UnitSeconds. If set, the name must end with the unit suffix, i.e: _seconds.
+	// If empty, the unit is inferred from the name suffix.
+	Unit Unit
+
+	// Objectives are the quantiles to calculate → map[quantile:absolute error], i.e: {0.5: 0.05, 0.99: 0.001}.
+	// If empty, the summary only exposes the _sum and _count series.
+	Objectives map[float64]float64
+
+	// MaxAge is the duration of the sliding time window the quantiles are calculated over.
+	// If zero, the default Prometheus max age of 10 minutes is used.
+	MaxAge time.Duration
+
+	// AgeBuckets is the number of buckets the sliding time window is split into.
+	// Observations expire bucket by bucket, so more buckets make the window slide more smoothly.
+	// If zero, the default Prometheus age buckets (5) are used.
+	AgeBuckets uint32
+
+	// BufCap is the capacity of the buffer observations are collected in, before being inserted into the quantile streams.
+	// If zero, the default Prometheus buffer capacity (500) is used.
+	BufCap uint32
+}
+
 // Provider represents a metric provider, i.e: Prometheus.
 type Provider interface {
-	NewCounter(name, help string, constLabels map[string]string, labels ...string) CounterVecMetric
-	NewGauge(name, help string, constLabels map[string]string, labels ...string) GaugeVecMetric
-	NewHistogram(name, help string, constLabels map[string]string, buckets []float64, labels ...string) ObserverVecMetric
-	NewSummary(name, help string, constLabels map[string]string, objectives map[float64]float64, labels ...string) ObserverVecMetric
+	NewCounter(name, help string, constLabels map[string]string, opts CounterOpts, labels ...string) CounterVecMetric
+	NewGauge(name, help string, constLabels map[string]string, opts GaugeOpts, labels ...string) GaugeVecMetric
+	NewHistogram(name, help string, constLabels map[string]string, opts HistogramOpts, labels ...string) ObserverVecMetric
+	NewSummary(name, help string, constLabels map[string]string, opts SummaryOpts, labels ...string) ObserverVecMetric
 	WithCollector(collector prometheus.Collector) Provider
 }
 
@@ -97,7 +209,9 @@ type Provider interface {
 // Use this function, if the metric does not have any custom dynamic labels,
 // which also gives the caller direct access to a CounterMetric.
 func Counter(name string, args ...string) CounterMetric {
-	return counter(name, help(args), ConstLabels.labels).With(map[string]string{})
+	return defaultHandle(handleKey{typ: counterType, name: name, scalar: true}, metricDesc{help: help(args), labels: labels(args)}, func() any {
+		return &lazyCounter{vec: CounterVec(name, args...)}
+	}).(CounterMetric)
 }
 
 // CounterVec creates or references an existing counter vector metric.
@@ -106,29 +220,37 @@ func Counter(name string, args ...string) CounterMetric {
 // .With(map[string]string{"label_name": "label_value"}), which then
 // gives the caller access to a CounterMetric to work with.
 func CounterVec(name string, args ...string) CounterVecMetric {
-	return counter(name, help(args), ConstLabels.labels, labels(args)...)
+	return CounterVecWithOpts(name, CounterOpts{}, args...)
 }
 
-func counter(name string, help string, constLabels map[string]string, labels ...string) CounterVecMetric {
-	mu.Lock()
-	defer mu.Unlock()
-
-	name = fqdn(name)
-	c, ok := counters[name]
-	if ok {
-		return c
-	}
+// CounterWithOpts creates or references an existing counter metric configured with opts, i.e: its unit.
+// Use this function, if the metric does not have any custom dynamic labels,
+// which also gives the caller direct access to a CounterMetric.
+func CounterWithOpts(name string, opts CounterOpts, args ...string) CounterMetric {
+	return defaultHandle(handleKey{typ: counterType, name: name, scalar: true}, metricDesc{help: help(args), unit: opts.Unit, labels: labels(args)}, func() any {
+		return &lazyCounter{vec: CounterVecWithOpts(name, opts, args...)}
+	}).(CounterMetric)
+}
 
-	c = DefaultProvider.NewCounter(name, help, constLabels, labels...)
-	counters[name] = c
-	return c
+// CounterVecWithOpts creates or references an existing counter vector metric configured with opts.
+// Use this function instead, if you plan on dynamically adding custom labels
+// to the CounterMetric, which involves an extra step of calling
+// .With(map[string]string{"label_name": "label_value"}), which then
+// gives the caller access to a CounterMetric to work with.
+func CounterVecWithOpts(name string, opts CounterOpts, args ...string) CounterVecMetric {
+	h := help(args)
+	return defaultHandle(handleKey{typ: counterType, name: name}, metricDesc{help: h, unit: opts.Unit, labels: labels(args)}, func() any {
+		return lazyCounterVecOf(Default, name, h, opts, append([]string{}, labels(args)...)...)
+	}).(CounterVecMetric)
 }
 
 // Gauge creates or references an existing gauge metric.
 // Use this function, if the metric does not have any custom dynamic labels,
 // which also gives the caller direct access to a GaugeMetric.
 func Gauge(name string, args ...string) GaugeMetric {
-	return gauge(name, help(args), ConstLabels.labels).With(map[string]string{})
+	return defaultHandle(handleKey{typ: gaugeType, name: name, scalar: true}, metricDesc{help: help(args), labels: labels(args)}, func() any {
+		return &lazyGauge{vec: GaugeVec(name, args...)}
+	}).(GaugeMetric)
 }
 
 // GaugeVec creates or references an existing gauge vector metric.
@@ -137,36 +259,46 @@ func Gauge(name string, args ...string) GaugeMetric {
 // .With(map[string]string{"label_name": "label_value"}), which then
 // gives the caller access to a GaugeMetric to work with.
 func GaugeVec(name string, args ...string) GaugeVecMetric {
-	return gauge(name, help(args), ConstLabels.labels, labels(args)...)
+	return GaugeVecWithOpts(name, GaugeOpts{}, args...)
 }
 
-func gauge(name string, help string, constLabels map[string]string, labels ...string) GaugeVecMetric {
-	mu.Lock()
-	defer mu.Unlock()
-
-	name = fqdn(name)
-	g, ok := gauges[name]
-	if ok {
-		return g
-	}
+// GaugeWithOpts creates or references an existing gauge metric configured with opts, i.e: its unit.
+// Use this function, if the metric does not have any custom dynamic labels,
+// which also gives the caller direct access to a GaugeMetric.
+func GaugeWithOpts(name string, opts GaugeOpts, args ...string) GaugeMetric {
+	return defaultHandle(handleKey{typ: gaugeType, name: name, scalar: true}, metricDesc{help: help(args), unit: opts.Unit, labels: labels(args)}, func() any {
+		return &lazyGauge{vec: GaugeVecWithOpts(name, opts, args...)}
+	}).(GaugeMetric)
+}
 
-	g = DefaultProvider.NewGauge(name, help, constLabels, labels...)
-	gauges[name] = g
-	return g
+// GaugeVecWithOpts creates or references an existing gauge vector metric configured with opts.
+// Use this function instead, if you plan on dynamically adding custom labels
+// to the GaugeMetric, which involves an extra step of calling
+// .With(map[string]string{"label_name": "label_value"}), which then
+// gives the caller access to a GaugeMetric to work with.
+func GaugeVecWithOpts(name string, opts GaugeOpts, args ...string) GaugeVecMetric {
+	h := help(args)
+	return defaultHandle(handleKey{typ: gaugeType, name: name}, metricDesc{help: h, unit: opts.Unit, labels: labels(args)}, func() any {
+		return lazyGaugeVecOf(Default, name, h, opts, append([]string{}, labels(args)...)...)
+	}).(GaugeVecMetric)
 }
 
 // Histogram creates or references an existing histogram metric.
 // Use this function, if the metric does not have any custom dynamic labels,
 // which also gives the caller direct access to a ObserverMetric (histogram).
 func Histogram(name string, args ...string) ObserverMetric {
-	return histogram(name, help(args), ConstLabels.labels, []float64{}).With(map[string]string{})
+	return defaultHandle(handleKey{typ: histogramType, name: name, scalar: true}, metricDesc{help: help(args), labels: labels(args)}, func() any {
+		return &lazyObserver{vec: HistogramVec(name, args...)}
+	}).(ObserverMetric)
 }
 
 // HistogramWithBuckets creates or references an existing histogram metric with custom buckets.
 // Use this function, if the metric does not have any custom dynamic labels,
 // which also gives the caller direct access to a ObserverMetric (histogram), and is initialized with custom buckets.
 func HistogramWithBuckets(name string, buckets []float64, args ...string) ObserverMetric {
-	return histogram(name, help(args), ConstLabels.labels, buckets).With(map[string]string{})
+	return defaultHandle(handleKey{typ: histogramType, name: name, scalar: true}, HistogramOpts{Buckets: buckets}.desc(help(args), labels(args)), func() any {
+		return &lazyObserver{vec: HistogramVecWithBuckets(name, buckets, args...)}
+	}).(ObserverMetric)
 }
 
 // HistogramVec creates or references an existing histogram vector metric.
@@ -175,7 +307,7 @@ func HistogramWithBuckets(name string, buckets []float64, args ...string) Observ
 // .With(map[string]string{"label_name": "label_value"}), which then
 // gives the caller access to a ObserverMetric (histogram) to work with.
 func HistogramVec(name string, args ...string) ObserverVecMetric {
-	return histogram(name, help(args), ConstLabels.labels, []float64{}, labels(args)...)
+	return HistogramVecWithOpts(name, HistogramOpts{}, args...)
 }
 
 // HistogramVecWithBuckets creates or references an existing histogram vector metric with custom buckets.
@@ -184,29 +316,60 @@ func HistogramVec(name string, args ...string) ObserverVecMetric {
 // .With(map[string]string{"label_name": "label_value"}), which then
 // gives the caller access to a ObserverMetric (histogram) to work with and is initialized with custom buckets..
 func HistogramVecWithBuckets(name string, buckets []float64, args ...string) ObserverVecMetric {
-	return histogram(name, help(args), ConstLabels.labels, buckets, labels(args)...)
+	return HistogramVecWithOpts(name, HistogramOpts{Buckets: buckets}, args...)
 }
 
-func histogram(name string, help string, constLabels map[string]string, buckets []float64, labels ...string) ObserverVecMetric {
-	mu.Lock()
-	defer mu.Unlock()
+// HistogramWithOpts creates or references an existing histogram metric configured with opts,
+// i.e: classic buckets and/or native histogram settings.
+// Use this function, if the metric does not have any custom dynamic labels,
+// which also gives the caller direct access to a ObserverMetric (histogram).
+func HistogramWithOpts(name string, opts HistogramOpts, args ...string) ObserverMetric {
+	return defaultHandle(handleKey{typ: histogramType, name: name, scalar: true}, opts.desc(help(args), labels(args)), func() any {
+		return &lazyObserver{vec: HistogramVecWithOpts(name, opts, args...)}
+	}).(ObserverMetric)
+}
 
-	name = fqdn(name)
-	h, ok := histograms[name]
-	if ok {
-		return h
-	}
+// HistogramVecWithOpts creates or references an existing histogram vector metric configured with opts.
+// Unlike NativeHistogramVec, the native histogram is only enabled if opts.NativeBucketFactor is greater than 1.
+// Use this function instead, if you plan on dynamically adding custom labels
+// to the ObserverMetric (histogram), which involves an extra step of calling
+// .With(map[string]string{"label_name": "label_value"}), which then
+// gives the caller access to a ObserverMetric (histogram) to work with.
+func HistogramVecWithOpts(name string, opts HistogramOpts, args ...string) ObserverVecMetric {
+	h := help(args)
+	return defaultHandle(handleKey{typ: histogramType, name: name}, opts.desc(h, labels(args)), func() any {
+		return lazyHistogramVecOf(Default, name, h, opts, append([]string{}, labels(args)...)...)
+	}).(ObserverVecMetric)
+}
 
-	h = DefaultProvider.NewHistogram(name, help, constLabels, buckets, labels...)
-	histograms[name] = h
-	return h
+// NativeHistogram creates or references an existing native (sparse) histogram metric.
+// Native histograms do not need hand-picked buckets, instead the bucket boundaries grow exponentially
+// by opts.NativeBucketFactor (DefaultNativeBucketFactor if not set), so the resolution is the same on any scale.
+// Set opts.Buckets as well, to expose the classic buckets alongside the native ones, i.e: while migrating dashboards.
+// Use this function, if the metric does not have any custom dynamic labels,
+// which also gives the caller direct access to a ObserverMetric (histogram).
+func NativeHistogram(name string, opts HistogramOpts, args ...string) ObserverMetric {
+	return defaultHandle(handleKey{typ: histogramType, name: name, scalar: true}, opts.native().desc(help(args), labels(args)), func() any {
+		return &lazyObserver{vec: NativeHistogramVec(name, opts, args...)}
+	}).(ObserverMetric)
+}
+
+// NativeHistogramVec creates or references an existing native (sparse) histogram vector metric.
+// Use this function instead, if you plan on dynamically adding custom labels
+// to the ObserverMetric (histogram), which involves an extra step of calling
+// .With(map[string]string{"label_name": "label_value"}), which then
+// gives the caller access to a ObserverMetric (histogram) to work with.
+func NativeHistogramVec(name string, opts HistogramOpts, args ...string) ObserverVecMetric {
+	return HistogramVecWithOpts(name, opts.native(), args...)
 }
 
 // Summary creates or references an existing summary metric.
 // Use this function, if the metric does not have any custom dynamic labels,
 // which also gives the caller direct access to a ObserverMetric (summary).
 func Summary(name string, args ...string) ObserverMetric {
-	return summary(name, help(args), ConstLabels.labels, map[float64]float64{}).With(map[string]string{})
+	return defaultHandle(handleKey{typ: summaryType, name: name, scalar: true}, metricDesc{help: help(args), labels: labels(args)}, func() any {
+		return &lazyObserver{vec: SummaryVec(name, args...)}
+	}).(ObserverMetric)
 }
 
 // SummaryWithObjectives creates or references an existing summary metric with objectives → map[quantile:absolute error].
@@ -217,7 +380,9 @@ func Summary(name string, args ...string) ObserverMetric {
 // https://en.wikipedia.org/wiki/Quantile
 // https://en.wikipedia.org/wiki/Percentile
 func SummaryWithObjectives(name string, objectives map[float64]float64, args ...string) ObserverMetric {
-	return summary(name, help(args), ConstLabels.labels, objectives).With(map[string]string{})
+	return defaultHandle(handleKey{typ: summaryType, name: name, scalar: true}, metricDesc{help: help(args), labels: labels(args)}, func() any {
+		return &lazyObserver{vec: SummaryVecWithObjectives(name, objectives, args...)}
+	}).(ObserverMetric)
 }
 
 // SummaryVec creates or references an existing summary vector metric.
@@ -226,7 +391,7 @@ func SummaryWithObjectives(name string, objectives map[float64]float64, args ...
 // .With(map[string]string{"label_name": "label_value"}), which then
 // gives the caller access to a ObserverMetric (summary) to work with.
 func SummaryVec(name string, args ...string) ObserverVecMetric {
-	return summary(name, help(args), ConstLabels.labels, map[float64]float64{}, labels(args)...)
+	return SummaryVecWithOpts(name, SummaryOpts{}, args...)
 }
 
 // SummaryVecWithObjectives creates or references an existing summary vector metric
@@ -237,22 +402,162 @@ func SummaryVec(name string, args ...string) ObserverVecMetric {
 // https://en.wikipedia.org/wiki/Quantile
 // https://en.wikipedia.org/wiki/Percentile
 func SummaryVecWithObjectives(name string, objectives map[float64]float64, args ...string) ObserverVecMetric {
-	return summary(name, help(args), ConstLabels.labels, objectives, labels(args)...)
+	return SummaryVecWithOpts(name, SummaryOpts{Objectives: objectives}, args...)
 }
 
-func summary(name string, help string, constLabels map[string]string, objectives map[float64]float64, labels ...string) ObserverVecMetric {
-	mu.Lock()
-	defer mu.Unlock()
+// SummaryWithOpts creates or references an existing summary metric configured with opts,
+// i.e: objectives and the length of the sliding time window the quantiles are calculated over.
+// Use this function, if the metric does not have any custom dynamic labels,
+// which also gives the caller direct access to a ObserverMetric (summary).
+func SummaryWithOpts(name string, opts SummaryOpts, args ...string) ObserverMetric {
+	return defaultHandle(handleKey{typ: summaryType, name: name, scalar: true}, metricDesc{help: help(args), unit: opts.Unit, labels: labels(args)}, func() any {
+		return &lazyObserver{vec: SummaryVecWithOpts(name, opts, args...)}
+	}).(ObserverMetric)
+}
 
-	name = fqdn(name)
-	s, ok := summaries[name]
-	if ok {
-		return s
-	}
+// SummaryVecWithOpts creates or references an existing summary vector metric configured with opts.
+// Use this function instead, if you plan on dynamically adding custom labels
+// to the ObserverMetric (summary), which involves an extra step of calling
+// .With(map[string]string{"label_name": "label_value"}), which then
+// gives the caller access to a ObserverMetric (summary) to work with.
+func SummaryVecWithOpts(name string, opts SummaryOpts, args ...string) ObserverVecMetric {
+	h := help(args)
+	return defaultHandle(handleKey{typ: summaryType, name: name}, metricDesc{help: h, unit: opts.Unit, labels: labels(args)}, func() any {
+		return lazySummaryVecOf(Default, name, h, opts, append([]string{}, labels(args)...)...)
+	}).(ObserverVecMetric)
+}
+
+// TryCounter is like Counter, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric, instead of logging it and using a no-op metric.
+// Unlike Counter, it resolves the default registry right away.
+func TryCounter(name string, args ...string) (CounterMetric, error) {
+	return Default().TryCounter(name, args...)
+}
+
+// TryCounterVec is like CounterVec, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryCounterVec(name string, args ...string) (CounterVecMetric, error) {
+	return Default().TryCounterVec(name, args...)
+}
+
+// TryGauge is like Gauge, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryGauge(name string, args ...string) (GaugeMetric, error) {
+	return Default().TryGauge(name, args...)
+}
+
+// TryGaugeVec is like GaugeVec, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryGaugeVec(name string, args ...string) (GaugeVecMetric, error) {
+	return Default().TryGaugeVec(name, args...)
+}
+
+// TryCounterWithOpts is like CounterWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryCounterWithOpts(name string, opts CounterOpts, args ...string) (CounterMetric, error) {
+	return Default().TryCounterWithOpts(name, opts, args...)
+}
+
+// TryCounterVecWithOpts is like CounterVecWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryCounterVecWithOpts(name string, opts CounterOpts, args ...string) (CounterVecMetric, error) {
+	return Default().TryCounterVecWithOpts(name, opts, args...)
+}
+
+// TryGaugeWithOpts is like GaugeWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryGaugeWithOpts(name string, opts GaugeOpts, args ...string) (GaugeMetric, error) {
+	return Default().TryGaugeWithOpts(name, opts, args...)
+}
+
+// TryGaugeVecWithOpts is like GaugeVecWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryGaugeVecWithOpts(name string, opts GaugeOpts, args ...string) (GaugeVecMetric, error) {
+	return Default().TryGaugeVecWithOpts(name, opts, args...)
+}
+
+// TryHistogram is like Histogram, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryHistogram(name string, args ...string) (ObserverMetric, error) {
+	return Default().TryHistogram(name, args...)
+}
+
+// TryHistogramWithBuckets is like HistogramWithBuckets, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryHistogramWithBuckets(name string, buckets []float64, args ...string) (ObserverMetric, error) {
+	return Default().TryHistogramWithBuckets(name, buckets, args...)
+}
 
-	s = DefaultProvider.NewSummary(name, help, constLabels, objectives, labels...)
-	summaries[name] = s
-	return s
+// TryHistogramVec is like HistogramVec, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryHistogramVec(name string, args ...string) (ObserverVecMetric, error) {
+	return Default().TryHistogramVec(name, args...)
+}
+
+// TryHistogramVecWithBuckets is like HistogramVecWithBuckets, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryHistogramVecWithBuckets(name string, buckets []float64, args ...string) (ObserverVecMetric, error) {
+	return Default().TryHistogramVecWithBuckets(name, buckets, args...)
+}
+
+// TryNativeHistogram is like NativeHistogram, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryNativeHistogram(name string, opts HistogramOpts, args ...string) (ObserverMetric, error) {
+	return Default().TryNativeHistogram(name, opts, args...)
+}
+
+// TryNativeHistogramVec is like NativeHistogramVec, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryNativeHistogramVec(name string, opts HistogramOpts, args ...string) (ObserverVecMetric, error) {
+	return Default().TryNativeHistogramVec(name, opts, args...)
+}
+
+// TryHistogramWithOpts is like HistogramWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryHistogramWithOpts(name string, opts HistogramOpts, args ...string) (ObserverMetric, error) {
+	return Default().TryHistogramWithOpts(name, opts, args...)
+}
+
+// TryHistogramVecWithOpts is like HistogramVecWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TryHistogramVecWithOpts(name string, opts HistogramOpts, args ...string) (ObserverVecMetric, error) {
+	return Default().TryHistogramVecWithOpts(name, opts, args...)
+}
+
+// TrySummary is like Summary, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TrySummary(name string, args ...string) (ObserverMetric, error) {
+	return Default().TrySummary(name, args...)
+}
+
+// TrySummaryWithObjectives is like SummaryWithObjectives, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TrySummaryWithObjectives(name string, objectives map[float64]float64, args ...string) (ObserverMetric, error) {
+	return Default().TrySummaryWithObjectives(name, objectives, args...)
+}
+
+// TrySummaryVec is like SummaryVec, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TrySummaryVec(name string, args ...string) (ObserverVecMetric, error) {
+	return Default().TrySummaryVec(name, args...)
+}
+
+// TrySummaryVecWithObjectives is like SummaryVecWithObjectives, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TrySummaryVecWithObjectives(name string, objectives map[float64]float64, args ...string) (ObserverVecMetric, error) {
+	return Default().TrySummaryVecWithObjectives(name, objectives, args...)
+}
+
+// TrySummaryWithOpts is like SummaryWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TrySummaryWithOpts(name string, opts SummaryOpts, args ...string) (ObserverMetric, error) {
+	return Default().TrySummaryWithOpts(name, opts, args...)
+}
+
+// TrySummaryVecWithOpts is like SummaryVecWithOpts, but it returns an error if the metric is invalid
+// or conflicts with an already registered metric.
+func TrySummaryVecWithOpts(name string, opts SummaryOpts, args ...string) (ObserverVecMetric, error) {
+	return Default().TrySummaryVecWithOpts(name, opts, args...)
 }
 
 // help extracts the metric help message from a variadic list of fields
@@ -272,19 +577,18 @@ func labels(args []string) []string {
 	return args[1:]
 }
 
-func fqdn(name string) string {
-	return DefaultPrefix + "_" + name
-}
-
-// RegisterCollector registers a collector with the default provider.
+// RegisterCollector registers a collector with the default registry.
 func RegisterCollector(collector prometheus.Collector) {
-	DefaultProvider.WithCollector(collector)
+	Default().RegisterCollector(collector)
 }
 
 // ConstMetricLabels represents the constant metric labels wrapper.
 type ConstMetricLabels struct {
 	labels map[string]string
 	mu     sync.RWMutex
+
+	// sealed is set once the labels were bound to a registry, after which they are no longer applied.
+	sealed bool
 }
 
 // Set sets a constant metric label that will be available to all registered metrics.
@@ -293,8 +597,16 @@ func (c *ConstMetricLabels) Set(key, value string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if value != "" {
-		c.labels[key] = value
+	if value == "" {
+		return
+	}
+	c.labels[key] = value
+	if c.sealed {
+		logging.GetLogger().Warn(
+			"const label set after the default metrics registry was initialized, it is not applied to the metrics",
+			zap.String("label", key),
+			zap.String("value", value),
+		)
 	}
 }
 
@@ -306,3 +618,16 @@ func (c *ConstMetricLabels) Get(key string) (string, bool) {
 
 	return val, ok
 }
+
+// seal returns a copy of all the constant metric labels, after which setting a label is logged as ignored.
+func (c *ConstMetricLabels) seal() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sealed = true
+	labels := make(map[string]string, len(c.labels))
+	for k, v := range c.labels {
+		labels[k] = v
+	}
+	return labels
+}
@@ -0,0 +1,65 @@
+package metrics
+
+// The no-op metric types are returned instead of panicking, whenever a metric cannot be registered.
+
+type noopCounterVec struct{}
+
+func (noopCounterVec) With(map[string]string) CounterMetric {
+	return noopMetric{}
+}
+
+func (noopCounterVec) WithLabelValues(...string) CounterMetric {
+	return noopMetric{}
+}
+
+func (v noopCounterVec) Curry(...string) CounterVecMetric {
+	return v
+}
+
+type noopGaugeVec struct{}
+
+func (noopGaugeVec) With(map[string]string) GaugeMetric {
+	return noopMetric{}
+}
+
+func (noopGaugeVec) WithLabelValues(...string) GaugeMetric {
+	return noopMetric{}
+}
+
+func (v noopGaugeVec) Curry(...string) GaugeVecMetric {
+	return v
+}
+
+func (noopGaugeVec) DeleteLabelValues(...string) bool {
+	return false
+}
+
+type noopObserverVec struct{}
+
+func (noopObserverVec) With(map[string]string) ObserverMetric {
+	return noopMetric{}
+}
+
+func (noopObserverVec) WithLabelValues(...string) ObserverMetric {
+	return noopMetric{}
+}
+
+func (v noopObserverVec) Curry(...string) ObserverVecMetric {
+	return v
+}
+
+type noopMetric struct{}
+
+func (noopMetric) Inc() {}
+
+func (noopMetric) Dec() {}
+
+func (noopMetric) Add(float64) {}
+
+func (noopMetric) Sub(float64) {}
+
+func (noopMetric) Set(float64) {}
+
+func (noopMetric) SetToCurrentTime() {}
+
+func (noopMetric) Observe(float64) {}
@@ -1,59 +1,83 @@
 package metrics
 
 import (
+	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 )
 
-var (
-	registry                               = prometheus.NewRegistry()
-	registerer       prometheus.Registerer = registry
-	gatherer         prometheus.Gatherer   = registry
-	metricCollectors                       = newCollectors
-)
-
-func init() {
-	registerer = prometheus.NewRegistry()
-	registerer = prometheus.WrapRegistererWithPrefix(
-		fqdn(""),
-		prometheus.WrapRegistererWith(ConstLabels.labels, registry),
-	)
-	registerer.MustRegister(metricCollectors()...)
-}
-
 // PrometheusProviderOpts represents the Prometheus metrics configuration options.
 type PrometheusProviderOpts struct {
 	prometheus.Registerer
 	prometheus.Gatherer
+
+	// Prefix and ConstLabels are applied to the collectors registered using WithCollector.
+	// Metrics created using the New* methods receive their (already prefixed) name and const labels directly.
+	Prefix      string
+	ConstLabels map[string]string
+
+	// DefaultCollectors registers the build info, Go runtime and process collectors.
+	DefaultCollectors bool
+
+	// RuntimeMetrics selects the extra Go runtime metrics groups, collected when DefaultCollectors is enabled.
+	RuntimeMetrics RuntimeMetricsOpts
 }
 
 // NewPrometheusProvider creates a new Prometheus provider that implements Provider using Prometheus metrics.
+// If neither a Registerer nor a Gatherer are given, the provider uses its own new Prometheus registry.
 func NewPrometheusProvider(opts PrometheusProviderOpts) PrometheusProvider {
-	if opts.Registerer != nil {
-		registerer = opts.Registerer
+	registry := prometheus.NewRegistry()
+	registerer, gatherer := opts.Registerer, opts.Gatherer
+	if registerer == nil {
+		registerer = registry
+	}
+	if gatherer == nil {
+		gatherer = registry
+	}
+
+	collectorRegisterer := registerer
+	if len(opts.ConstLabels) > 0 {
+		collectorRegisterer = prometheus.WrapRegistererWith(opts.ConstLabels, collectorRegisterer)
 	}
-	if opts.Gatherer != nil {
-		gatherer = opts.Gatherer
+	if opts.Prefix != "" {
+		collectorRegisterer = prometheus.WrapRegistererWithPrefix(opts.Prefix+"_", collectorRegisterer)
 	}
+
 	p := PrometheusProvider{
-		registerer: registerer,
-		gatherer:   gatherer,
+		registerer:          registerer,
+		collectorRegisterer: collectorRegisterer,
+		gatherer:            gatherer,
+		units:               &sync.Map{},
+	}
+	if opts.DefaultCollectors {
+		for _, c := range newCollectors(opts.RuntimeMetrics) {
+			p.WithCollector(c)
+		}
 	}
 	return p
 }
 
 // PrometheusProvider represents the implementation for Prometheus provider.
+// The Prometheus client does not carry the unit metadata, so the provider keeps the metric units
+// and sets them on the gathered metric families, which the handler exposes in the OpenMetrics format.
 type PrometheusProvider struct {
-	registerer prometheus.Registerer
-	gatherer   prometheus.Gatherer
+	registerer          prometheus.Registerer
+	collectorRegisterer prometheus.Registerer
+	gatherer            prometheus.Gatherer
+
+	// units holds the metric units by metric name.
+	units *sync.Map
 }
 
 // NewCounter creates a new Prometheus counter vector metric.
-func (p PrometheusProvider) NewCounter(name, help string, constLabels map[string]string, labels ...string) CounterVecMetric {
+func (p PrometheusProvider) NewCounter(name, help string, constLabels map[string]string, opts CounterOpts, labels ...string) CounterVecMetric {
 	vec := promauto.With(p.registerer).NewCounterVec(
 		prometheus.CounterOpts{
 			Name:        name,
@@ -62,20 +86,31 @@ func (p PrometheusProvider) NewCounter(name, help string, constLabels map[string
 		},
 		labels,
 	)
-	return counterVec{vec}
+	p.setUnit(name, opts.Unit)
+	return counterVec{CounterVec: vec, labels: labels}
 }
 
 // counterVec represents an internal counter vec type that implements CounterVecMetric
 type counterVec struct {
 	*prometheus.CounterVec
+	labels []string
 }
 
 func (c counterVec) With(labels map[string]string) CounterMetric {
 	return c.CounterVec.With(labels)
 }
 
+func (c counterVec) WithLabelValues(values ...string) CounterMetric {
+	return c.CounterVec.WithLabelValues(values...)
+}
+
+func (c counterVec) Curry(values ...string) CounterVecMetric {
+	curried, labels := curry(c.labels, values)
+	return counterVec{CounterVec: c.CounterVec.MustCurryWith(curried), labels: labels}
+}
+
 // NewGauge creates a new Prometheus gauge vector metric.
-func (p PrometheusProvider) NewGauge(name, help string, constLabels map[string]string, labels ...string) GaugeVecMetric {
+func (p PrometheusProvider) NewGauge(name, help string, constLabels map[string]string, opts GaugeOpts, labels ...string) GaugeVecMetric {
 	vec := promauto.With(p.registerer).NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name:        name,
@@ -84,83 +119,187 @@ func (p PrometheusProvider) NewGauge(name, help string, constLabels map[string]s
 		},
 		labels,
 	)
-	return gaugeVec{vec}
+	p.setUnit(name, opts.Unit)
+	return gaugeVec{GaugeVec: vec, labels: labels}
 }
 
 // gaugeVec represents an internal gauge vec type that implements GaugeVecMetric
 type gaugeVec struct {
 	*prometheus.GaugeVec
+	labels []string
 }
 
 func (g gaugeVec) With(labels map[string]string) GaugeMetric {
 	return g.GaugeVec.With(labels)
 }
 
+func (g gaugeVec) WithLabelValues(values ...string) GaugeMetric {
+	return g.GaugeVec.WithLabelValues(values...)
+}
+
+func (g gaugeVec) Curry(values ...string) GaugeVecMetric {
+	curried, labels := curry(g.labels, values)
+	return gaugeVec{GaugeVec: g.GaugeVec.MustCurryWith(curried), labels: labels}
+}
+
 // NewHistogram creates a new Prometheus histogram vector metric.
-func (p PrometheusProvider) NewHistogram(name, help string, constLabels map[string]string, buckets []float64, labels ...string) ObserverVecMetric {
+// Native histogram buckets are exposed alongside the classic buckets, when both are configured.
+func (p PrometheusProvider) NewHistogram(name, help string, constLabels map[string]string, opts HistogramOpts, labels ...string) ObserverVecMetric {
 	vec := promauto.With(p.registerer).NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:        name,
 			Help:        help,
 			ConstLabels: constLabels,
-			Buckets:     buckets,
+			Buckets:     opts.Buckets,
+
+			NativeHistogramBucketFactor:     opts.NativeBucketFactor,
+			NativeHistogramMaxBucketNumber:  opts.NativeMaxBucketNumber,
+			NativeHistogramZeroThreshold:    opts.NativeZeroThreshold,
+			NativeHistogramMinResetDuration: opts.NativeMinResetDuration,
 		},
 		labels,
 	)
-	return histogramVec{vec}
-}
-
-// histogramVec represents an internal histogram vec type that implements ObserverVecMetric
-type histogramVec struct {
-	*prometheus.HistogramVec
-}
-
-func (h histogramVec) With(labels map[string]string) ObserverMetric {
-	return h.HistogramVec.With(labels)
+	p.setUnit(name, opts.Unit)
+	return observerVec{ObserverVec: vec, labels: labels}
 }
 
 // NewSummary creates a new Prometheus summary vector metric.
-func (p PrometheusProvider) NewSummary(name, help string, constLabels map[string]string, objectives map[float64]float64, labels ...string) ObserverVecMetric {
+func (p PrometheusProvider) NewSummary(name, help string, constLabels map[string]string, opts SummaryOpts, labels ...string) ObserverVecMetric {
 	vec := promauto.With(p.registerer).NewSummaryVec(
 		prometheus.SummaryOpts{
 			Name:        name,
 			Help:        help,
 			ConstLabels: constLabels,
-			Objectives:  objectives,
+			Objectives:  opts.Objectives,
+			MaxAge:      opts.MaxAge,
+			AgeBuckets:  opts.AgeBuckets,
+			BufCap:      opts.BufCap,
 		},
 		labels,
 	)
-	return summaryVec{vec}
+	p.setUnit(name, opts.Unit)
+	return observerVec{ObserverVec: vec, labels: labels}
+}
+
+// observerVec represents an internal histogram / summary vec type that implements ObserverVecMetric
+type observerVec struct {
+	prometheus.ObserverVec
+	labels []string
 }
 
-// summaryVec represents an internal summary vec type that implements ObserverVecMetric
-type summaryVec struct {
-	*prometheus.SummaryVec
+func (o observerVec) With(labels map[string]string) ObserverMetric {
+	return o.ObserverVec.With(labels)
 }
 
-func (s summaryVec) With(labels map[string]string) ObserverMetric {
-	return s.SummaryVec.With(labels)
+func (o observerVec) WithLabelValues(values ...string) ObserverMetric {
+	return o.ObserverVec.WithLabelValues(values...)
 }
 
-// PrometheusHandler creates a new http.Handler that exposes Prometheus metrics over HTTP.
+func (o observerVec) Curry(values ...string) ObserverVecMetric {
+	curried, labels := curry(o.labels, values)
+	return observerVec{ObserverVec: o.ObserverVec.MustCurryWith(curried), labels: labels}
+}
+
+// curry binds the given values to the first labels, returning the bound labels and the remaining label names.
+func curry(labels []string, values []string) (prometheus.Labels, []string) {
+	if len(values) > len(labels) {
+		panic(fmt.Sprintf("metrics: cannot curry %d label values, only %d labels are left", len(values), len(labels)))
+	}
+
+	curried := make(prometheus.Labels, len(values))
+	for i, v := range values {
+		curried[labels[i]] = v
+	}
+	return curried, labels[len(values):]
+}
+
+// PrometheusHandler creates a new http.Handler that exposes the default registry metrics over HTTP.
+// The default registry is resolved on every request, so the handler can be mounted before the registry is configured.
 func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Default().Handler().ServeHTTP(w, r)
+	})
+}
+
+// Handler creates a new http.Handler that exposes the Prometheus provider metrics over HTTP.
+// The metric units are exposed when the scraper accepts the OpenMetrics format, i.e: # UNIT ppp_response_time_seconds seconds.
+func (p PrometheusProvider) Handler() http.Handler {
+	gatherer := p.Gatherer()
+	text := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
 	return promhttp.InstrumentMetricHandler(
-		registerer,
-		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}),
+		p.collectorRegisterer,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+			if format.FormatType() != expfmt.TypeOpenMetrics {
+				text.ServeHTTP(w, r)
+				return
+			}
+
+			mfs, err := gatherer.Gather()
+			if err != nil {
+				http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", string(format))
+			enc := expfmt.NewEncoder(w, format, expfmt.WithUnit())
+			for _, mf := range mfs {
+				if err := enc.Encode(mf); err != nil {
+					return
+				}
+			}
+			if closer, ok := enc.(expfmt.Closer); ok {
+				_ = closer.Close()
+			}
+		}),
 	)
 }
 
+// Gatherer returns the Prometheus gatherer of the provider, which sets the metric units on the gathered metric families.
+func (p PrometheusProvider) Gatherer() prometheus.Gatherer {
+	if p.units == nil {
+		return p.gatherer
+	}
+	return unitGatherer{Gatherer: p.gatherer, units: p.units}
+}
+
+func (p PrometheusProvider) setUnit(name string, unit Unit) {
+	if p.units != nil && unit != UnitNone {
+		p.units.Store(name, unit)
+	}
+}
+
+// unitGatherer represents a Prometheus gatherer that sets the metric units on the gathered metric families.
+type unitGatherer struct {
+	prometheus.Gatherer
+	units *sync.Map
+}
+
+func (g unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		if unit, ok := g.units.Load(mf.GetName()); ok {
+			u := string(unit.(Unit))
+			mf.Unit = &u
+		}
+	}
+	return mfs, err
+}
+
 // WithCollector registers a new collector with the Prometheus provider.
 func (p PrometheusProvider) WithCollector(collector prometheus.Collector) Provider {
-	p.registerer.Unregister(collector)
-	p.registerer.MustRegister(collector)
+	p.collectorRegisterer.Unregister(collector)
+	p.collectorRegisterer.MustRegister(collector)
 	return p
 }
 
-func newCollectors() []prometheus.Collector {
-	return []prometheus.Collector{
+func newCollectors(opts RuntimeMetricsOpts) []prometheus.Collector {
+	cs := []prometheus.Collector{
 		collectors.NewBuildInfoCollector(),
-		collectors.NewGoCollector(),
+		newGoCollector(opts),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	}
+	if opts.Config {
+		cs = append(cs, newGoConfigCollector())
+	}
+	return cs
 }
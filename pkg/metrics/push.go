@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
+)
+
+// Supported push formats.
+const (
+	// PushgatewayFormat pushes the metrics to a Prometheus Pushgateway, replacing all the metrics of the grouping key.
+	PushgatewayFormat PushFormat = "pushgateway"
+
+	// RemoteWriteFormat pushes the metrics using the Prometheus remote write protocol (protobuf + snappy),
+	// i.e: to a Prometheus started with --web.enable-remote-write-receiver on /api/v1/write.
+	// The native histograms are only accepted if Prometheus also runs with --enable-feature=native-histograms.
+	RemoteWriteFormat PushFormat = "remote_write"
+)
+
+// Push errors.
+var (
+	ErrMissingPushURL = errors.New("push url is required")
+	ErrMissingPushJob = errors.New("push job is required")
+	ErrNotGatherable  = errors.New("metrics provider cannot be gathered")
+)
+
+// PushFormat represents the protocol used to push metrics.
+type PushFormat string
+
+// PushOpts represents the metrics push configuration options.
+type PushOpts struct {
+	// URL is the Pushgateway URL (i.e: http://localhost:9091) or the remote write URL
+	// (i.e: http://localhost:9090/api/v1/write), depending on the Format.
+	URL string
+
+	// Format is the protocol used to push metrics. (default PushgatewayFormat)
+	Format PushFormat
+
+	// Job is the job name the metrics are pushed under.
+	// For the remote write format it is added as the "job" label to every series.
+	Job string
+
+	// Grouping are the labels used as the Pushgateway grouping key, i.e: instance.
+	// For the remote write format they are added as labels to every series.
+	Grouping map[string]string
+
+	// Interval is the interval between periodic pushes.
+	// If zero, metrics are only pushed on Push and Shutdown.
+	Interval time.Duration
+
+	// Timeout is the timeout of every periodic push. (default 5s)
+	Timeout time.Duration
+
+	// Client is the HTTP client used to push metrics. (default http.DefaultClient)
+	Client *http.Client
+}
+
+// Pusher pushes metrics to a Pushgateway or a remote write endpoint, on a periodic interval and on shutdown.
+// Use it for short-lived jobs (batch programs, benchmarks) that exit before Prometheus gets to scrape them.
+type Pusher struct {
+	opts     PushOpts
+	gatherer prometheus.Gatherer
+	push     func(ctx context.Context) error
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewPusher creates a new metrics pusher for the default registry.
+func NewPusher(opts PushOpts) (*Pusher, error) {
+	return Default().NewPusher(opts)
+}
+
+// NewPusher creates a new metrics pusher for the registry.
+// The registry provider must be gatherable, i.e: a PrometheusProvider.
+func (r *Registry) NewPusher(opts PushOpts) (*Pusher, error) {
+	if opts.URL == "" {
+		return nil, ErrMissingPushURL
+	}
+	if opts.Job == "" {
+		return nil, ErrMissingPushJob
+	}
+	if opts.Format == "" {
+		opts.Format = PushgatewayFormat
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	g, ok := r.Provider().(interface{ Gatherer() prometheus.Gatherer })
+	if !ok {
+		return nil, ErrNotGatherable
+	}
+
+	p := &Pusher{
+		opts:     opts,
+		gatherer: g.Gatherer(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch opts.Format {
+	case PushgatewayFormat:
+		p.push = p.pushgateway
+	case RemoteWriteFormat:
+		p.push = p.remoteWrite
+	default:
+		return nil, fmt.Errorf("unsupported push format: %q", opts.Format)
+	}
+
+	if opts.Interval > 0 {
+		go p.run()
+	} else {
+		close(p.done)
+	}
+	return p, nil
+}
+
+// Push pushes the current metrics right away.
+func (p *Pusher) Push(ctx context.Context) error {
+	return p.push(ctx)
+}
+
+// Shutdown stops the periodic pushes and pushes the metrics one last time.
+// Call it before the application exits, so the last observations are not lost.
+func (p *Pusher) Shutdown(ctx context.Context) error {
+	p.once.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.push(ctx)
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+			if err := p.push(ctx); err != nil {
+				logging.GetLogger().Error(
+					"could not push metrics",
+					zap.String("url", p.opts.URL),
+					zap.String("format", string(p.opts.Format)),
+					zap.Error(err),
+				)
+			}
+			cancel()
+		}
+	}
+}
+
+func (p *Pusher) pushgateway(ctx context.Context) error {
+	pusher := push.New(p.opts.URL, p.opts.Job).Gatherer(p.gatherer).Client(p.opts.Client)
+	for k, v := range p.opts.Grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+	return pusher.PushContext(ctx)
+}
@@ -0,0 +1,248 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newPushServer(t *testing.T) (*httptest.Server, chan pushRequest) {
+	t.Helper()
+
+	requests := make(chan pushRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- pushRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func TestPusherPushgateway(t *testing.T) {
+	srv, requests := newPushServer(t)
+	r := New(RegistryOpts{Prefix: "push"})
+	r.Counter("jobs_total", "Total jobs").Inc()
+
+	pusher, err := r.NewPusher(PushOpts{
+		URL:      srv.URL,
+		Job:      "batch",
+		Grouping: map[string]string{"instance": "local"},
+	})
+	if err != nil {
+		t.Fatalf("could not create pusher: %v", err)
+	}
+	if err = pusher.Shutdown(context.Background()); err != nil {
+		t.Fatalf("could not push metrics: %v", err)
+	}
+
+	req := <-requests
+	if req.method != http.MethodPut {
+		t.Errorf("expected a PUT request, got %s", req.method)
+	}
+	if req.path != "/metrics/job/batch/instance/local" {
+		t.Errorf("expected the grouping key in the path, got %s", req.path)
+	}
+	if !strings.Contains(string(req.body), "push_jobs_total") {
+		t.Errorf("expected the pushed metrics to contain push_jobs_total")
+	}
+}
+
+func TestPusherPeriodic(t *testing.T) {
+	srv, requests := newPushServer(t)
+	r := New(RegistryOpts{Prefix: "periodic"})
+	r.Gauge("up").Set(1)
+
+	pusher, err := r.NewPusher(PushOpts{URL: srv.URL, Job: "batch", Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not create pusher: %v", err)
+	}
+	defer func() { _ = pusher.Shutdown(context.Background()) }()
+
+	select {
+	case <-requests:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the metrics to be pushed periodically")
+	}
+}
+
+func TestPusherRemoteWrite(t *testing.T) {
+	srv, requests := newPushServer(t)
+	r := New(RegistryOpts{Prefix: "rw"})
+	r.CounterVec("jobs_total", "Total jobs", "status").WithLabelValues("ok").Add(3)
+
+	pusher, err := r.NewPusher(PushOpts{
+		URL:      srv.URL + "/api/v1/write",
+		Format:   RemoteWriteFormat,
+		Job:      "batch",
+		Grouping: map[string]string{"instance": "local"},
+	})
+	if err != nil {
+		t.Fatalf("could not create pusher: %v", err)
+	}
+	if err = pusher.Push(context.Background()); err != nil {
+		t.Fatalf("could not push metrics: %v", err)
+	}
+
+	req := <-requests
+	if req.header.Get("Content-Encoding") != "snappy" || req.header.Get("X-Prometheus-Remote-Write-Version") == "" {
+		t.Errorf("expected remote write headers, got %v", req.header)
+	}
+	body, err := s2.Decode(nil, req.body)
+	if err != nil {
+		t.Fatalf("could not decode snappy body: %v", err)
+	}
+
+	for _, s := range decodeWriteRequest(t, body) {
+		if s.labels["__name__"] != "rw_jobs_total" {
+			continue
+		}
+		if s.labels["job"] != "batch" || s.labels["instance"] != "local" || s.labels["status"] != "ok" {
+			t.Errorf("unexpected series labels: %v", s.labels)
+		}
+		if s.value != 3 {
+			t.Errorf("expected series value 3, got %v", s.value)
+		}
+		return
+	}
+	t.Errorf("expected the rw_jobs_total series to be pushed")
+}
+
+func TestPusherRemoteWriteLabelCollision(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "rw"})
+	r.CounterVec("jobs_total", "Total jobs", "job").WithLabelValues("resize").Inc()
+
+	for _, s := range remoteWrite(t, r, PushOpts{Job: "batch", Grouping: map[string]string{"instance": "local"}}) {
+		if s.labels["__name__"] != "rw_jobs_total" {
+			continue
+		}
+		// The metric job label is kept as exported_job, instead of duplicating the job label.
+		if s.labels["job"] != "batch" || s.labels["exported_job"] != "resize" || s.labels["instance"] != "local" {
+			t.Errorf("unexpected series labels: %v", s.labels)
+		}
+		return
+	}
+	t.Errorf("expected the rw_jobs_total series to be pushed")
+}
+
+func TestPusherRemoteWriteNativeHistogram(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "rw"})
+	r.HistogramWithOpts("latency_seconds", HistogramOpts{NativeBucketFactor: DefaultNativeBucketFactor}, "Latency").Observe(0.25)
+
+	var histogram map[protowire.Number][][]byte
+	for _, s := range remoteWrite(t, r, PushOpts{Job: "batch"}) {
+		switch s.labels["__name__"] {
+		case "rw_latency_seconds":
+			histogram = s.histogram
+		case "rw_latency_seconds_bucket", "rw_latency_seconds_sum", "rw_latency_seconds_count":
+			t.Errorf("expected no classic series for a native only histogram, got %v", s.labels)
+		}
+	}
+	if histogram == nil {
+		t.Fatal("expected the rw_latency_seconds native histogram to be pushed")
+	}
+	if count, _ := protowire.ConsumeVarint(histogram[1][0]); count != 1 {
+		t.Errorf("expected the histogram count 1, got %d", count)
+	}
+	if len(histogram[11]) != 1 || len(histogram[12]) != 1 {
+		t.Errorf("expected the histogram positive spans and deltas, got %v", histogram)
+	}
+}
+
+// remoteWrite pushes the registry metrics in the remote write format and decodes the pushed series.
+func remoteWrite(t *testing.T, r *Registry, opts PushOpts) []decodedSeries {
+	t.Helper()
+
+	srv, requests := newPushServer(t)
+	opts.URL = srv.URL + "/api/v1/write"
+	opts.Format = RemoteWriteFormat
+	pusher, err := r.NewPusher(opts)
+	if err != nil {
+		t.Fatalf("could not create pusher: %v", err)
+	}
+	if err = pusher.Push(context.Background()); err != nil {
+		t.Fatalf("could not push metrics: %v", err)
+	}
+	body, err := s2.Decode(nil, (<-requests).body)
+	if err != nil {
+		t.Fatalf("could not decode snappy body: %v", err)
+	}
+	return decodeWriteRequest(t, body)
+}
+
+type decodedSeries struct {
+	labels    map[string]string
+	value     float64
+	histogram map[protowire.Number][][]byte
+}
+
+// decodeWriteRequest decodes just enough of the remote write protobuf message to assert on it.
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	t.Helper()
+
+	var ss []decodedSeries
+	for _, ts := range fields(t, b)[1] {
+		s := decodedSeries{labels: map[string]string{}}
+		tsFields := fields(t, ts)
+		for _, l := range tsFields[1] {
+			lFields := fields(t, l)
+			s.labels[string(lFields[1][0])] = string(lFields[2][0])
+		}
+		if len(tsFields[4]) > 0 {
+			s.histogram = fields(t, tsFields[4][0])
+		} else {
+			sample := fields(t, tsFields[2][0])
+			bits, _ := protowire.ConsumeFixed64(sample[1][0])
+			s.value = math.Float64frombits(bits)
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// fields returns the raw values of every protobuf field by field number.
+func fields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	t.Helper()
+
+	fs := map[protowire.Number][][]byte{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid protobuf tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			v, n = b[:8], 8
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(b)
+			v = b[:n]
+		default:
+			t.Fatalf("unexpected protobuf wire type: %v", typ)
+		}
+		if n < 0 {
+			t.Fatalf("invalid protobuf value: %v", protowire.ParseError(n))
+		}
+		fs[num] = append(fs[num], v)
+		b = b[n:]
+	}
+	return fs
+}
@@ -0,0 +1,565 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
+)
+
+var (
+	defaultMu       sync.Mutex
+	defaultRegistry *Registry
+)
+
+// RegistryOpts represents the metrics registry configuration options.
+type RegistryOpts struct {
+	// Prefix is the prefix used for all metric names, i.e: Prefix_your_metric_name.
+	// If empty, DefaultPrefix will be used.
+	Prefix string
+
+	// ConstLabels are the const labels applied to all metrics created through the registry.
+	ConstLabels map[string]string
+
+	// Provider is the metrics provider used to create the metrics.
+	// If nil, a new PrometheusProvider with its own Prometheus registry will be used.
+	Provider Provider
+
+	// RuntimeMetrics selects the extra Go runtime metrics groups of the Prometheus provider created when Provider is nil.
+	RuntimeMetrics RuntimeMetricsOpts
+}
+
+// Registry binds together the metric name prefix, the const labels, the metrics provider
+// and the metric caches, so that swapping any of them never mixes metrics between providers.
+// The provider is only initialized when the first metric is used.
+// Requesting an existing metric name with a different type, help or labels is logged and results in a no-op metric,
+// use the Try* variants to handle these errors instead.
+type Registry struct {
+	prefix         string
+	constLabels    map[string]string
+	runtimeMetrics RuntimeMetricsOpts
+
+	// defaults makes init read DefaultPrefix, ConstLabels and DefaultProvider,
+	// so the default registry follows the package configuration up until its first metric is used.
+	defaults bool
+
+	once     sync.Once
+	provider Provider
+	handler  http.Handler
+
+	mu         sync.Mutex
+	declared   map[string]metricDesc
+	descs      map[string]metricDesc
+	counters   map[string]CounterVecMetric
+	gauges     map[string]GaugeVecMetric
+	histograms map[string]ObserverVecMetric
+	summaries  map[string]ObserverVecMetric
+	slos       map[string]bool
+}
+
+// New creates a new metrics registry.
+func New(opts RegistryOpts) *Registry {
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	constLabels := make(map[string]string, len(opts.ConstLabels))
+	for k, v := range opts.ConstLabels {
+		constLabels[k] = v
+	}
+
+	return &Registry{
+		prefix:         prefix,
+		constLabels:    constLabels,
+		runtimeMetrics: opts.RuntimeMetrics,
+		provider:       opts.Provider,
+		declared:       map[string]metricDesc{},
+		descs:          map[string]metricDesc{},
+		counters:       map[string]CounterVecMetric{},
+		gauges:         map[string]GaugeVecMetric{},
+		histograms:     map[string]ObserverVecMetric{},
+		summaries:      map[string]ObserverVecMetric{},
+		slos:           map[string]bool{},
+	}
+}
+
+// Default returns the default registry used by the package level functions.
+// The default registry reads DefaultPrefix, ConstLabels and DefaultProvider when its first metric is used
+// (or its handler first serves a request), not when it is created, so getting a reference to it on application
+// setup is safe. The const labels set afterwards are ignored, which is logged.
+func Default() *Registry {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultRegistry == nil {
+		defaultRegistry = New(RegistryOpts{})
+		defaultRegistry.defaults = true
+	}
+	return defaultRegistry
+}
+
+// SetDefault replaces the default registry used by the package level functions.
+// Metrics that were already used keep reporting to the previous registry.
+func SetDefault(r *Registry) {
+	defaultMu.Lock()
+	defaultRegistry = r
+	defaultMu.Unlock()
+	resetHandles()
+}
+
+// Provider returns the metrics provider of the registry.
+func (r *Registry) Provider() Provider {
+	r.init()
+	return r.provider
+}
+
+// Handler returns an http.Handler that exposes the registry metrics over HTTP.
+// If the registry provider cannot be scraped, the handler responds with 404 Not Found.
+func (r *Registry) Handler() http.Handler {
+	r.init()
+	return r.handler
+}
+
+// RegisterCollector registers a collector with the registry provider.
+func (r *Registry) RegisterCollector(collector prometheus.Collector) {
+	r.init()
+	r.provider.WithCollector(collector)
+}
+
+// Counter creates or references an existing counter metric.
+func (r *Registry) Counter(name string, args ...string) CounterMetric {
+	return &lazyCounter{vec: r.CounterVec(name, args...)}
+}
+
+// CounterVec creates or references an existing counter vector metric.
+func (r *Registry) CounterVec(name string, args ...string) CounterVecMetric {
+	return r.CounterVecWithOpts(name, CounterOpts{}, args...)
+}
+
+// CounterWithOpts creates or references an existing counter metric configured with opts.
+func (r *Registry) CounterWithOpts(name string, opts CounterOpts, args ...string) CounterMetric {
+	return &lazyCounter{vec: r.CounterVecWithOpts(name, opts, args...)}
+}
+
+// CounterVecWithOpts creates or references an existing counter vector metric configured with opts.
+func (r *Registry) CounterVecWithOpts(name string, opts CounterOpts, args ...string) CounterVecMetric {
+	return lazyCounterVecOf(r.self, name, help(args), opts, labels(args)...)
+}
+
+// Gauge creates or references an existing gauge metric.
+func (r *Registry) Gauge(name string, args ...string) GaugeMetric {
+	return &lazyGauge{vec: r.GaugeVec(name, args...)}
+}
+
+// GaugeVec creates or references an existing gauge vector metric.
+func (r *Registry) GaugeVec(name string, args ...string) GaugeVecMetric {
+	return r.GaugeVecWithOpts(name, GaugeOpts{}, args...)
+}
+
+// GaugeWithOpts creates or references an existing gauge metric configured with opts.
+func (r *Registry) GaugeWithOpts(name string, opts GaugeOpts, args ...string) GaugeMetric {
+	return &lazyGauge{vec: r.GaugeVecWithOpts(name, opts, args...)}
+}
+
+// GaugeVecWithOpts creates or references an existing gauge vector metric configured with opts.
+func (r *Registry) GaugeVecWithOpts(name string, opts GaugeOpts, args ...string) GaugeVecMetric {
+	return lazyGaugeVecOf(r.self, name, help(args), opts, labels(args)...)
+}
+
+// Histogram creates or references an existing histogram metric.
+func (r *Registry) Histogram(name string, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.HistogramVec(name, args...)}
+}
+
+// HistogramWithBuckets creates or references an existing histogram metric with custom buckets.
+func (r *Registry) HistogramWithBuckets(name string, buckets []float64, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.HistogramVecWithBuckets(name, buckets, args...)}
+}
+
+// HistogramVec creates or references an existing histogram vector metric.
+func (r *Registry) HistogramVec(name string, args ...string) ObserverVecMetric {
+	return r.HistogramVecWithOpts(name, HistogramOpts{}, args...)
+}
+
+// HistogramVecWithBuckets creates or references an existing histogram vector metric with custom buckets.
+func (r *Registry) HistogramVecWithBuckets(name string, buckets []float64, args ...string) ObserverVecMetric {
+	return r.HistogramVecWithOpts(name, HistogramOpts{Buckets: buckets}, args...)
+}
+
+// HistogramWithOpts creates or references an existing histogram metric configured with opts.
+func (r *Registry) HistogramWithOpts(name string, opts HistogramOpts, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.HistogramVecWithOpts(name, opts, args...)}
+}
+
+// HistogramVecWithOpts creates or references an existing histogram vector metric configured with opts.
+func (r *Registry) HistogramVecWithOpts(name string, opts HistogramOpts, args ...string) ObserverVecMetric {
+	return lazyHistogramVecOf(r.self, name, help(args), opts, labels(args)...)
+}
+
+// NativeHistogram creates or references an existing native (sparse) histogram metric.
+func (r *Registry) NativeHistogram(name string, opts HistogramOpts, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.NativeHistogramVec(name, opts, args...)}
+}
+
+// NativeHistogramVec creates or references an existing native (sparse) histogram vector metric.
+func (r *Registry) NativeHistogramVec(name string, opts HistogramOpts, args ...string) ObserverVecMetric {
+	return r.HistogramVecWithOpts(name, opts.native(), args...)
+}
+
+// Summary creates or references an existing summary metric.
+func (r *Registry) Summary(name string, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.SummaryVec(name, args...)}
+}
+
+// SummaryWithObjectives creates or references an existing summary metric with objectives → map[quantile:absolute error].
+func (r *Registry) SummaryWithObjectives(name string, objectives map[float64]float64, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.SummaryVecWithObjectives(name, objectives, args...)}
+}
+
+// SummaryVec creates or references an existing summary vector metric.
+func (r *Registry) SummaryVec(name string, args ...string) ObserverVecMetric {
+	return r.SummaryVecWithOpts(name, SummaryOpts{}, args...)
+}
+
+// SummaryVecWithObjectives creates or references an existing summary vector metric
+// with objectives → map[quantile:absolute error].
+func (r *Registry) SummaryVecWithObjectives(name string, objectives map[float64]float64, args ...string) ObserverVecMetric {
+	return r.SummaryVecWithOpts(name, SummaryOpts{Objectives: objectives}, args...)
+}
+
+// SummaryWithOpts creates or references an existing summary metric configured with opts.
+func (r *Registry) SummaryWithOpts(name string, opts SummaryOpts, args ...string) ObserverMetric {
+	return &lazyObserver{vec: r.SummaryVecWithOpts(name, opts, args...)}
+}
+
+// SummaryVecWithOpts creates or references an existing summary vector metric configured with opts.
+func (r *Registry) SummaryVecWithOpts(name string, opts SummaryOpts, args ...string) ObserverVecMetric {
+	return lazySummaryVecOf(r.self, name, help(args), opts, labels(args)...)
+}
+
+// TryCounter creates or references an existing counter metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryCounter(name string, args ...string) (CounterMetric, error) {
+	vec, err := r.TryCounterVec(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryCounterVec creates or references an existing counter vector metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryCounterVec(name string, args ...string) (CounterVecMetric, error) {
+	return r.TryCounterVecWithOpts(name, CounterOpts{}, args...)
+}
+
+// TryCounterWithOpts creates or references an existing counter metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryCounterWithOpts(name string, opts CounterOpts, args ...string) (CounterMetric, error) {
+	vec, err := r.TryCounterVecWithOpts(name, opts, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryCounterVecWithOpts creates or references an existing counter vector metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryCounterVecWithOpts(name string, opts CounterOpts, args ...string) (CounterVecMetric, error) {
+	return r.counter(name, help(args), opts, newCallSite(), labels(args)...)
+}
+
+// TryGauge creates or references an existing gauge metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryGauge(name string, args ...string) (GaugeMetric, error) {
+	vec, err := r.TryGaugeVec(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryGaugeVec creates or references an existing gauge vector metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryGaugeVec(name string, args ...string) (GaugeVecMetric, error) {
+	return r.TryGaugeVecWithOpts(name, GaugeOpts{}, args...)
+}
+
+// TryGaugeWithOpts creates or references an existing gauge metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryGaugeWithOpts(name string, opts GaugeOpts, args ...string) (GaugeMetric, error) {
+	vec, err := r.TryGaugeVecWithOpts(name, opts, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryGaugeVecWithOpts creates or references an existing gauge vector metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryGaugeVecWithOpts(name string, opts GaugeOpts, args ...string) (GaugeVecMetric, error) {
+	return r.gauge(name, help(args), opts, newCallSite(), labels(args)...)
+}
+
+// TryHistogram creates or references an existing histogram metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryHistogram(name string, args ...string) (ObserverMetric, error) {
+	return r.TryHistogramWithBuckets(name, []float64{}, args...)
+}
+
+// TryHistogramWithBuckets creates or references an existing histogram metric with custom buckets,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryHistogramWithBuckets(name string, buckets []float64, args ...string) (ObserverMetric, error) {
+	vec, err := r.TryHistogramVecWithBuckets(name, buckets, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryHistogramVec creates or references an existing histogram vector metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryHistogramVec(name string, args ...string) (ObserverVecMetric, error) {
+	return r.TryHistogramVecWithOpts(name, HistogramOpts{}, args...)
+}
+
+// TryHistogramVecWithBuckets creates or references an existing histogram vector metric with custom buckets,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryHistogramVecWithBuckets(name string, buckets []float64, args ...string) (ObserverVecMetric, error) {
+	return r.TryHistogramVecWithOpts(name, HistogramOpts{Buckets: buckets}, args...)
+}
+
+// TryHistogramWithOpts creates or references an existing histogram metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryHistogramWithOpts(name string, opts HistogramOpts, args ...string) (ObserverMetric, error) {
+	vec, err := r.TryHistogramVecWithOpts(name, opts, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryHistogramVecWithOpts creates or references an existing histogram vector metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryHistogramVecWithOpts(name string, opts HistogramOpts, args ...string) (ObserverVecMetric, error) {
+	return r.histogram(name, help(args), opts, newCallSite(), labels(args)...)
+}
+
+// TryNativeHistogram creates or references an existing native (sparse) histogram metric,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryNativeHistogram(name string, opts HistogramOpts, args ...string) (ObserverMetric, error) {
+	vec, err := r.TryNativeHistogramVec(name, opts, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TryNativeHistogramVec creates or references an existing native (sparse) histogram vector metric,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TryNativeHistogramVec(name string, opts HistogramOpts, args ...string) (ObserverVecMetric, error) {
+	return r.TryHistogramVecWithOpts(name, opts.native(), args...)
+}
+
+// TrySummary creates or references an existing summary metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TrySummary(name string, args ...string) (ObserverMetric, error) {
+	return r.TrySummaryWithObjectives(name, map[float64]float64{}, args...)
+}
+
+// TrySummaryWithObjectives creates or references an existing summary metric with objectives,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TrySummaryWithObjectives(name string, objectives map[float64]float64, args ...string) (ObserverMetric, error) {
+	vec, err := r.TrySummaryVecWithObjectives(name, objectives, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TrySummaryVec creates or references an existing summary vector metric, returning an error
+// if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TrySummaryVec(name string, args ...string) (ObserverVecMetric, error) {
+	return r.TrySummaryVecWithOpts(name, SummaryOpts{}, args...)
+}
+
+// TrySummaryVecWithObjectives creates or references an existing summary vector metric with objectives,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TrySummaryVecWithObjectives(name string, objectives map[float64]float64, args ...string) (ObserverVecMetric, error) {
+	return r.TrySummaryVecWithOpts(name, SummaryOpts{Objectives: objectives}, args...)
+}
+
+// TrySummaryWithOpts creates or references an existing summary metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TrySummaryWithOpts(name string, opts SummaryOpts, args ...string) (ObserverMetric, error) {
+	vec, err := r.TrySummaryVecWithOpts(name, opts, args...)
+	if err != nil {
+		return nil, err
+	}
+	return vec.With(map[string]string{}), nil
+}
+
+// TrySummaryVecWithOpts creates or references an existing summary vector metric configured with opts,
+// returning an error if the metric is invalid or conflicts with an already registered metric.
+func (r *Registry) TrySummaryVecWithOpts(name string, opts SummaryOpts, args ...string) (ObserverVecMetric, error) {
+	return r.summary(name, help(args), opts, newCallSite(), labels(args)...)
+}
+
+func (r *Registry) self() *Registry {
+	return r
+}
+
+func (r *Registry) counter(name string, help string, opts CounterOpts, site callSite, labels ...string) (CounterVecMetric, error) {
+	var c CounterVecMetric
+	d := metricDesc{typ: counterType, help: help, unit: opts.Unit, labels: labels, site: site}
+	err := r.register(name, &d, func(name string) {
+		c = r.counters[name]
+	}, func(name string) {
+		opts.Unit = d.unit
+		c = r.provider.NewCounter(name, help, r.constLabels, opts, labels...)
+		r.counters[name] = c
+	})
+	return c, err
+}
+
+func (r *Registry) gauge(name string, help string, opts GaugeOpts, site callSite, labels ...string) (GaugeVecMetric, error) {
+	var g GaugeVecMetric
+	d := metricDesc{typ: gaugeType, help: help, unit: opts.Unit, labels: labels, site: site}
+	err := r.register(name, &d, func(name string) {
+		g = r.gauges[name]
+	}, func(name string) {
+		opts.Unit = d.unit
+		g = r.provider.NewGauge(name, help, r.constLabels, opts, labels...)
+		r.gauges[name] = g
+	})
+	return g, err
+}
+
+func (r *Registry) histogram(name string, help string, opts HistogramOpts, site callSite, labels ...string) (ObserverVecMetric, error) {
+	var h ObserverVecMetric
+	d := opts.desc(help, labels)
+	d.site = site
+	err := r.register(name, &d, func(name string) {
+		h = r.histograms[name]
+	}, func(name string) {
+		opts.Unit = d.unit
+		h = r.provider.NewHistogram(name, help, r.constLabels, opts, labels...)
+		r.histograms[name] = h
+	})
+	return h, err
+}
+
+func (r *Registry) summary(name string, help string, opts SummaryOpts, site callSite, labels ...string) (ObserverVecMetric, error) {
+	var s ObserverVecMetric
+	d := metricDesc{typ: summaryType, help: help, unit: opts.Unit, labels: labels, site: site}
+	err := r.register(name, &d, func(name string) {
+		s = r.summaries[name]
+	}, func(name string) {
+		opts.Unit = d.unit
+		s = r.provider.NewSummary(name, help, r.constLabels, opts, labels...)
+		r.summaries[name] = s
+	})
+	return s, err
+}
+
+// register validates the requested metric against the already registered metric with the same name,
+// calling lookup to reference an existing metric, or create to register a new one with the provider.
+// The unit of d is inferred from the name suffix if empty, before create is called.
+// A provider panic (i.e: a Prometheus registration conflict) is returned as an error.
+func (r *Registry) register(name string, d *metricDesc, lookup func(string), create func(string)) (err error) {
+	r.init()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name = r.fqdn(name)
+	if existing, ok := r.descs[name]; ok {
+		if err := existing.conflict(name, *d); err != nil {
+			return err
+		}
+		lookup(name)
+		return nil
+	}
+	if err := validate(name, r.constLabels, d.labels); err != nil {
+		return err
+	}
+	if d.unit, err = unitOf(name, d.typ, d.unit); err != nil {
+		return err
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%w: could not register metric %q: %v", ErrProviderFailure, name, rec)
+		}
+	}()
+	create(name)
+	r.descs[name] = *d
+	return nil
+}
+
+// declare records the metric declared with the lazy constructors, before it is registered on first use,
+// so the catalog lists it right away. Only the first declaration of a name is kept, and the invalid ones are
+// left out, since they are reported when the metric is first used.
+func (r *Registry) declare(name string, d metricDesc) {
+	unit, err := unitOf(name, d.typ, d.unit)
+	if err != nil || !metricNameRE.MatchString(name) {
+		return
+	}
+	d.unit = unit
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.declared[name]; !ok {
+		r.declared[name] = d
+	}
+}
+
+// registerSLO reserves the SLO name, the SLO collector being keyed by the slo label,
+// so that a second SLO with the same name does not replace the first one.
+func (r *Registry) registerSLO(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.slos[name] {
+		return fmt.Errorf("%w: slo %q is already registered", ErrMetricConflict, name)
+	}
+	r.slos[name] = true
+	return nil
+}
+
+// logError logs a metric registration error. It is used whenever a metric is requested
+// without the possibility of returning the error, in which case a no-op metric is used instead.
+func (r *Registry) logError(err error) {
+	logging.GetLogger().Error("could not register metric, using a no-op metric instead", zap.Error(err))
+}
+
+// init lazily creates the registry provider. When no provider was given, a new Prometheus provider is created,
+// which also exposes the default collectors (build info, go runtime, process) under the registry prefix and const labels.
+func (r *Registry) init() {
+	r.once.Do(func() {
+		if r.defaults {
+			r.prefix = DefaultPrefix
+			r.constLabels = ConstLabels.seal()
+			r.provider = DefaultProvider
+		}
+		if r.provider == nil {
+			r.provider = NewPrometheusProvider(PrometheusProviderOpts{
+				Prefix:            r.prefix,
+				ConstLabels:       r.constLabels,
+				DefaultCollectors: true,
+				RuntimeMetrics:    r.runtimeMetrics,
+			})
+		}
+
+		r.handler = http.NotFoundHandler()
+		if p, ok := r.provider.(interface{ Handler() http.Handler }); ok {
+			r.handler = p.Handler()
+		}
+	})
+}
+
+func (r *Registry) fqdn(name string) string {
+	return r.prefix + "_" + name
+}
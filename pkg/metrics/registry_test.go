@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatalf("could not read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestRegistryConstLabelsOnDefaultCollectors(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "test", ConstLabels: map[string]string{appNameLabel: "registry_test"}})
+	r.Counter("requests_total", "Total requests").Inc()
+
+	body := scrape(t, r)
+	for _, want := range []string{
+		`test_requests_total{app_name="registry_test"} 1`,
+		`test_go_goroutines{app_name="registry_test"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestDefaultRegistryIsOrderIndependent(t *testing.T) {
+	defer SetDefault(nil)
+
+	// declared before the default registry is configured, i.e: as a package level variable
+	counter := Counter("lazy_total", "Lazily resolved counter")
+
+	r := New(RegistryOpts{Prefix: "lazy"})
+	SetDefault(r)
+	counter.Inc()
+
+	if body := scrape(t, r); !strings.Contains(body, "lazy_lazy_total 1") {
+		t.Errorf("expected the counter to be registered with the registry set after declaration")
+	}
+}
+
+func TestDefaultRegistryReadsGlobalsOnFirstUse(t *testing.T) {
+	SetDefault(nil)
+	defer SetDefault(nil)
+	defer func() {
+		ConstLabels.mu.Lock()
+		ConstLabels.labels, ConstLabels.sealed = map[string]string{}, false
+		ConstLabels.mu.Unlock()
+	}()
+
+	// referenced on application setup, before the app name is set
+	r := Default()
+	SetAppName("default_test")
+	Counter("default_requests_total", "Total requests").Inc()
+
+	if body := scrape(t, r); !strings.Contains(body, `ppp_default_requests_total{app_name="default_test"} 1`) {
+		t.Errorf("expected the app name set after referencing the default registry, got:\n%s", body)
+	}
+}
+
+func TestPackageLevelHandlesAreCached(t *testing.T) {
+	SetDefault(New(RegistryOpts{Prefix: "cached"}))
+	defer SetDefault(nil)
+
+	if HistogramVec("latency_seconds", "Latency", "path") != HistogramVec("latency_seconds", "Latency", "path") {
+		t.Error("expected the same handle for the same metric")
+	}
+	if Counter("requests_total", "Total requests") != Counter("requests_total") {
+		t.Error("expected the same handle when the help is omitted")
+	}
+	if CounterVec("errors_total", "Total errors", "code") == CounterVec("errors_total", "Total errors", "path") {
+		t.Error("expected a new handle for conflicting labels")
+	}
+	if HistogramVecWithBuckets("size_bytes", []float64{1, 2}, "Size") == HistogramVecWithBuckets("size_bytes", []float64{1, 3}, "Size") {
+		t.Error("expected a new handle for conflicting buckets")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		HistogramVec("latency_seconds", "Latency", "path")
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations for a cached handle, got %v", allocs)
+	}
+}
+
+func TestRegistriesDoNotShareCaches(t *testing.T) {
+	r1 := New(RegistryOpts{Prefix: "r1"})
+	r2 := New(RegistryOpts{Prefix: "r1"})
+	r1.Counter("shared_total").Inc()
+	r2.Counter("shared_total").Add(2)
+
+	if body := scrape(t, r1); !strings.Contains(body, "r1_shared_total 1") {
+		t.Errorf("expected first registry to report its own counter")
+	}
+	if body := scrape(t, r2); !strings.Contains(body, "r1_shared_total 2") {
+		t.Errorf("expected second registry to report its own counter")
+	}
+}
+
+func TestRegistryConflicts(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "conflict"})
+	if _, err := r.TryCounterVec("requests_total", "Total requests", "path"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.TryHistogramVecWithBuckets("latency_seconds", []float64{0.1, 1}, "Latency"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		try     func() error
+		wantErr error
+	}{
+		{
+			name: "same metric",
+			try: func() error {
+				_, err := r.TryCounterVec("requests_total", "Total requests", "path")
+				return err
+			},
+		},
+		{
+			name: "different labels",
+			try: func() error {
+				_, err := r.TryCounterVec("requests_total", "Total requests", "method")
+				return err
+			},
+			wantErr: ErrMetricConflict,
+		},
+		{
+			name: "different help",
+			try: func() error {
+				_, err := r.TryCounterVec("requests_total", "Requests", "path")
+				return err
+			},
+			wantErr: ErrMetricConflict,
+		},
+		{
+			name: "different type",
+			try: func() error {
+				_, err := r.TryGaugeVec("requests_total", "Total requests", "path")
+				return err
+			},
+			wantErr: ErrMetricConflict,
+		},
+		{
+			name: "same histogram without buckets",
+			try: func() error {
+				_, err := r.TryHistogramVec("latency_seconds", "Latency")
+				return err
+			},
+		},
+		{
+			name: "different buckets",
+			try: func() error {
+				_, err := r.TryHistogramVecWithBuckets("latency_seconds", []float64{0.1, 2}, "Latency")
+				return err
+			},
+			wantErr: ErrMetricConflict,
+		},
+		{
+			name: "different native options",
+			try: func() error {
+				_, err := r.TryHistogramVecWithOpts("latency_seconds", HistogramOpts{Buckets: []float64{0.1, 1}, NativeBucketFactor: 1.1}, "Latency")
+				return err
+			},
+			wantErr: ErrMetricConflict,
+		},
+		{
+			name: "different unit",
+			try: func() error {
+				_, err := r.TryHistogramVecWithOpts("latency_seconds", HistogramOpts{Unit: UnitBytes}, "Latency")
+				return err
+			},
+			wantErr: ErrMetricConflict,
+		},
+		{
+			name: "invalid name",
+			try: func() error {
+				_, err := r.TryCounter("requests-total")
+				return err
+			},
+			wantErr: ErrInvalidName,
+		},
+		{
+			name: "invalid label",
+			try: func() error {
+				_, err := r.TryCounterVec("errors_total", "Total errors", "__path")
+				return err
+			},
+			wantErr: ErrInvalidLabel,
+		},
+		{
+			name: "duplicate label",
+			try: func() error {
+				_, err := r.TryCounterVec("errors_total", "Total errors", "path", "path")
+				return err
+			},
+			wantErr: ErrDuplicateLabel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.try()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRegistryConflictUsesNoopMetric(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "noop"})
+	r.CounterVec("requests_total", "Total requests", "path").With(map[string]string{"path": "/"}).Inc()
+
+	// this used to panic inside With, because the cached vector has a different set of labels
+	r.CounterVec("requests_total", "Total requests", "method").With(map[string]string{"method": "GET"}).Inc()
+	r.Gauge("requests_total", "Total requests").Set(10)
+
+	body := scrape(t, r)
+	if !strings.Contains(body, `noop_requests_total{path="/"} 1`) {
+		t.Errorf("expected the first registered counter to be left untouched")
+	}
+}
+
+func TestRegistryNativeHistogram(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "native"})
+	r.NativeHistogram("latency_seconds", HistogramOpts{Buckets: []float64{0.1, 1}}, "Latency").Observe(0.5)
+
+	mfs, err := r.Provider().(PrometheusProvider).gatherer.Gather()
+	if err != nil {
+		t.Fatalf("could not gather metrics: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "native_latency_seconds" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if h.GetSchema() == 0 && len(h.GetPositiveSpan()) == 0 {
+			t.Errorf("expected native buckets to be exposed")
+		}
+		if len(h.GetBucket()) != 2 {
+			t.Errorf("expected 2 classic buckets to be exposed alongside native buckets, got %d", len(h.GetBucket()))
+		}
+		return
+	}
+	t.Errorf("expected native histogram to be registered")
+}
+
+func TestRegistryRuntimeMetrics(t *testing.T) {
+	r := New(RegistryOpts{
+		Prefix: "runtime",
+		RuntimeMetrics: RuntimeMetricsOpts{
+			GC:        true,
+			Memory:    true,
+			Scheduler: true,
+			Mutex:     true,
+			CPU:       true,
+			Config:    true,
+		},
+	})
+	defer debug.SetGCPercent(debug.SetGCPercent(250))
+
+	body := scrape(t, r)
+	for _, want := range []string{
+		"runtime_go_gc_pauses_seconds_bucket",
+		"runtime_go_memory_classes_heap_free_bytes",
+		"runtime_go_sched_latencies_seconds_bucket",
+		"runtime_go_sync_mutex_wait_total_seconds_total",
+		"runtime_go_cpu_classes_gc_total_cpu_seconds_total",
+		"runtime_go_config_gomaxprocs_threads",
+		"runtime_go_config_gomemlimit_bytes",
+		"runtime_go_config_gogc_ratio 2.5",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestRegistrySummaryWithOpts(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "summary"})
+	s := r.SummaryWithOpts("latency_seconds", SummaryOpts{
+		Objectives: map[float64]float64{0.5: 0.05},
+		MaxAge:     100 * time.Millisecond,
+		AgeBuckets: 1,
+	}, "Latency")
+	s.Observe(2)
+
+	if body := scrape(t, r); !strings.Contains(body, `summary_latency_seconds{quantile="0.5"} 2`) {
+		t.Errorf("expected the observation in the quantile, got:\n%s", body)
+	}
+
+	// The observation expires from the quantiles after MaxAge, but stays in the _sum and _count series.
+	time.Sleep(200 * time.Millisecond)
+	body := scrape(t, r)
+	for _, want := range []string{
+		`summary_latency_seconds{quantile="0.5"} NaN`,
+		`summary_latency_seconds_count 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+
+	h := r.HistogramWithOpts("size_bytes", HistogramOpts{Buckets: []float64{10, 100}}, "Size")
+	h.Observe(50)
+	if body := scrape(t, r); !strings.Contains(body, `summary_size_bytes_bucket{le="100"} 1`) {
+		t.Errorf("expected the histogram buckets, got:\n%s", body)
+	}
+}
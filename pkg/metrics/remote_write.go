@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteVersion is the Prometheus remote write protocol version.
+// https://prometheus.io/docs/concepts/remote_write_spec/
+const remoteWriteVersion = "0.1.0"
+
+type label struct {
+	name  string
+	value string
+}
+
+type series struct {
+	labels []label
+	value  float64
+
+	// histogram is the native histogram, sent instead of the value if set.
+	histogram *dto.Histogram
+	gauge     bool
+}
+
+func (p *Pusher) remoteWrite(ctx context.Context) error {
+	mfs, err := p.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("could not gather metrics: %w", err)
+	}
+
+	extra := []label{{name: "job", value: p.opts.Job}}
+	for k, v := range p.opts.Grouping {
+		extra = append(extra, label{name: k, value: v})
+	}
+	body := s2.EncodeSnappy(nil, encodeWriteRequest(toSeries(mfs, extra), time.Now().UnixMilli()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create remote write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+
+	res, err := p.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send remote write request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected remote write status code %d: %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// toSeries flattens the gathered metric families into remote write series,
+// the same way Prometheus flattens them when scraping (i.e: histograms into _bucket, _sum and _count series).
+// The native histograms are sent as native histogram series, along with their classic buckets if they have any.
+// The metric labels named after the extra labels are renamed with the exported_ prefix, like honor_labels: false does,
+// since Prometheus rejects the series with duplicate label names.
+func toSeries(mfs []*dto.MetricFamily, extra []label) []series {
+	extraNames := make(map[string]bool, len(extra))
+	for _, l := range extra {
+		extraNames[l.name] = true
+	}
+
+	var ss []series
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			labels := append([]label{}, extra...)
+			for _, lp := range m.GetLabel() {
+				l := label{name: lp.GetName(), value: lp.GetValue()}
+				if extraNames[l.name] {
+					l.name = "exported_" + l.name
+				}
+				labels = append(labels, l)
+			}
+			labelsOf := func(name string, extra ...label) []label {
+				ls := append(append([]label{{name: "__name__", value: name}}, labels...), extra...)
+				sort.Slice(ls, func(i, j int) bool { return ls[i].name < ls[j].name })
+				return ls
+			}
+			add := func(name string, value float64, extra ...label) {
+				ss = append(ss, series{labels: labelsOf(name, extra...), value: value})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), label{name: "quantile", value: formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				// The native histograms always have a schema, while the classic ones never do.
+				if h.Schema != nil {
+					ss = append(ss, series{labels: labelsOf(name), histogram: h, gauge: mf.GetType() == dto.MetricType_GAUGE_HISTOGRAM})
+					if len(h.GetBucket()) == 0 {
+						continue
+					}
+				}
+				for _, b := range h.GetBucket() {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{name: "le", value: formatFloat(b.GetUpperBound())})
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), label{name: "le", value: "+Inf"})
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return ss
+}
+
+// encodeWriteRequest encodes the series as a remote write prometheus.WriteRequest protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; repeated Histogram histograms = 4; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+//
+// See encodeHistogram for the Histogram message.
+func encodeWriteRequest(ss []series, timestamp int64) []byte {
+	var req []byte
+	for _, s := range ss {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		if s.histogram != nil {
+			ts = protowire.AppendTag(ts, 4, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, encodeHistogram(s.histogram, s.gauge, timestamp))
+		} else {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(timestamp))
+
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// encodeHistogram encodes a native histogram as a remote write prometheus.Histogram protobuf message,
+// which mirrors the exposition format one:
+//
+//	message Histogram {
+//	  oneof count { uint64 count_int = 1; double count_float = 2; }
+//	  double sum = 3;
+//	  sint32 schema = 4;
+//	  double zero_threshold = 5;
+//	  oneof zero_count { uint64 zero_count_int = 6; double zero_count_float = 7; }
+//	  repeated BucketSpan negative_spans = 8;
+//	  repeated sint64 negative_deltas = 9;
+//	  repeated double negative_counts = 10;
+//	  repeated BucketSpan positive_spans = 11;
+//	  repeated sint64 positive_deltas = 12;
+//	  repeated double positive_counts = 13;
+//	  ResetHint reset_hint = 14;
+//	  int64 timestamp = 15;
+//	}
+//	message BucketSpan { sint32 offset = 1; uint32 length = 2; }
+//
+// The integer histograms have deltas, while the float histograms have counts.
+// The gauge histograms have the GAUGE (3) reset hint, so they are not treated as counters.
+func encodeHistogram(h *dto.Histogram, gauge bool, timestamp int64) []byte {
+	var b []byte
+	appendDouble := func(num protowire.Number, v float64) {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	}
+	appendVarint := func(num protowire.Number, v uint64) {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	}
+	appendSpans := func(num protowire.Number, spans []*dto.BucketSpan) {
+		for _, s := range spans {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, protowire.EncodeZigZag(int64(s.GetOffset())))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.GetLength()))
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, sb)
+		}
+	}
+	appendDeltas := func(num protowire.Number, deltas []int64) {
+		if len(deltas) == 0 {
+			return
+		}
+		var pb []byte
+		for _, d := range deltas {
+			pb = protowire.AppendVarint(pb, protowire.EncodeZigZag(d))
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, pb)
+	}
+	appendCounts := func(num protowire.Number, counts []float64) {
+		if len(counts) == 0 {
+			return
+		}
+		var pb []byte
+		for _, c := range counts {
+			pb = protowire.AppendFixed64(pb, math.Float64bits(c))
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, pb)
+	}
+
+	float := h.SampleCountFloat != nil
+	if float {
+		appendDouble(2, h.GetSampleCountFloat())
+	} else {
+		appendVarint(1, h.GetSampleCount())
+	}
+	appendDouble(3, h.GetSampleSum())
+	appendVarint(4, protowire.EncodeZigZag(int64(h.GetSchema())))
+	appendDouble(5, h.GetZeroThreshold())
+	if float {
+		appendDouble(7, h.GetZeroCountFloat())
+	} else {
+		appendVarint(6, h.GetZeroCount())
+	}
+	appendSpans(8, h.GetNegativeSpan())
+	appendDeltas(9, h.GetNegativeDelta())
+	appendCounts(10, h.GetNegativeCount())
+	appendSpans(11, h.GetPositiveSpan())
+	appendDeltas(12, h.GetPositiveDelta())
+	appendCounts(13, h.GetPositiveCount())
+	if gauge {
+		appendVarint(14, 3)
+	}
+	appendVarint(15, uint64(timestamp))
+	return b
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"regexp"
+	"runtime"
+	"runtime/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RuntimeMetricsOpts represents the groups of Go runtime metrics (runtime/metrics) to collect,
+// on top of the default Go collector metrics (goroutines, threads, memstats).
+// Every group adds a fair amount of series, so only enable the ones you actually look at.
+type RuntimeMetricsOpts struct {
+	// GC enables the garbage collector metrics, i.e: the GC pause distribution, cycles and heap goals.
+	GC bool
+
+	// Memory enables the memory class breakdowns, i.e: heap free, released, stacks and metadata bytes.
+	Memory bool
+
+	// Scheduler enables the scheduler metrics, i.e: the goroutine scheduling latency distribution.
+	Scheduler bool
+
+	// Mutex enables the sync metrics, i.e: the total time goroutines spent blocked on mutexes.
+	Mutex bool
+
+	// CPU enables the estimated CPU time breakdowns, i.e: the CPU time spent on GC, scavenging and user code.
+	CPU bool
+
+	// Config enables the GOMAXPROCS, GOMEMLIMIT and GOGC gauges.
+	Config bool
+}
+
+// Additional runtime/metrics rules, not provided by the collectors package.
+var (
+	runtimeMetricsMutex = collectors.GoRuntimeMetricsRule{Matcher: regexp.MustCompile(`^/sync/.*`)}
+	runtimeMetricsCPU   = collectors.GoRuntimeMetricsRule{Matcher: regexp.MustCompile(`^/cpu/classes/.*`)}
+)
+
+func (o RuntimeMetricsOpts) rules() []collectors.GoRuntimeMetricsRule {
+	var rules []collectors.GoRuntimeMetricsRule
+	if o.GC {
+		rules = append(rules, collectors.MetricsGC)
+	}
+	if o.Memory {
+		rules = append(rules, collectors.MetricsMemory)
+	}
+	if o.Scheduler {
+		rules = append(rules, collectors.MetricsScheduler)
+	}
+	if o.Mutex {
+		rules = append(rules, runtimeMetricsMutex)
+	}
+	if o.CPU {
+		rules = append(rules, runtimeMetricsCPU)
+	}
+	return rules
+}
+
+// newGoCollector creates the Go collector with the enabled runtime/metrics groups.
+func newGoCollector(opts RuntimeMetricsOpts) prometheus.Collector {
+	rules := opts.rules()
+	if len(rules) == 0 {
+		return collectors.NewGoCollector()
+	}
+	return collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(rules...))
+}
+
+// goConfigCollector exposes the Go runtime configuration knobs, which are usually set through
+// the GOMAXPROCS, GOMEMLIMIT and GOGC environment variables, as gauges.
+type goConfigCollector struct {
+	gomaxprocs *prometheus.Desc
+	gomemlimit *prometheus.Desc
+	gogc       *prometheus.Desc
+}
+
+func newGoConfigCollector() prometheus.Collector {
+	return goConfigCollector{
+		gomaxprocs: prometheus.NewDesc("go_config_gomaxprocs_threads", "The current GOMAXPROCS setting.", nil, nil),
+		gomemlimit: prometheus.NewDesc("go_config_gomemlimit_bytes", "The current GOMEMLIMIT setting.", nil, nil),
+		gogc:       prometheus.NewDesc("go_config_gogc_ratio", "The current GOGC setting as a ratio, i.e: 1 for GOGC=100, -1 when the GC is off.", nil, nil),
+	}
+}
+
+func (c goConfigCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.gomaxprocs
+	ch <- c.gomemlimit
+	ch <- c.gogc
+}
+
+func (c goConfigCollector) Collect(ch chan<- prometheus.Metric) {
+	samples := []metrics.Sample{
+		{Name: "/gc/gomemlimit:bytes"},
+		{Name: "/gc/gogc:percent"},
+	}
+	metrics.Read(samples)
+
+	ch <- prometheus.MustNewConstMetric(c.gomaxprocs, prometheus.GaugeValue, float64(runtime.GOMAXPROCS(0)))
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		ch <- prometheus.MustNewConstMetric(c.gomemlimit, prometheus.GaugeValue, float64(samples[0].Value.Uint64()))
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		// GOGC is a percentage, exposed in the ratio base unit
+		gogc := float64(samples[1].Value.Uint64()) / 100
+		// GOGC=off is reported as the max uint64 value
+		if samples[1].Value.Uint64() > 1<<62 {
+			gogc = -1
+		}
+		ch <- prometheus.MustNewConstMetric(c.gogc, prometheus.GaugeValue, gogc)
+	}
+}
@@ -0,0 +1,387 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSLOPeriod is the default SLO period, the time range the error budget is spent over.
+const DefaultSLOPeriod = 30 * 24 * time.Hour
+
+// DefaultSLOWindows are the default burn rate windows, the ones used by the multi-window,
+// multi-burn-rate alerts from the Google SRE workbook. https://sre.google/workbook/alerting-on-slos/
+var DefaultSLOWindows = []time.Duration{
+	5 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	72 * time.Hour,
+}
+
+// sloAlerts are the multi-window, multi-burn-rate alerts from the Google SRE workbook.
+// An alert fires when both the long and the short windows spend the error budget fraction within the long window.
+var sloAlerts = []struct {
+	long, short time.Duration
+	budget      float64
+	severity    string
+	pending     string
+}{
+	{long: time.Hour, short: 5 * time.Minute, budget: 0.02, severity: "page", pending: "2m"},
+	{long: 6 * time.Hour, short: 30 * time.Minute, budget: 0.05, severity: "page", pending: "2m"},
+	{long: 24 * time.Hour, short: 2 * time.Hour, budget: 0.1, severity: "ticket", pending: "15m"},
+	{long: 72 * time.Hour, short: 6 * time.Hour, budget: 0.1, severity: "ticket", pending: "15m"},
+}
+
+// SLO errors.
+var (
+	ErrMissingSLOName   = errors.New("slo name is required")
+	ErrInvalidObjective = errors.New("slo objective must be between 0 and 1")
+	ErrInvalidWindow    = errors.New("slo windows must be positive and at most the slo period")
+)
+
+// SLOOpts represents the service level objective configuration options,
+// i.e: 99% of the /register requests are served under 500ms over 30 days.
+type SLOOpts struct {
+	// Name is the SLO name, used as the slo label on all the SLO metrics, i.e: register_latency.
+	Name string
+
+	// Objective is the ratio of good events, i.e: 0.99.
+	Objective float64
+
+	// Period is the time range the error budget is spent over. (default 30d)
+	Period time.Duration
+
+	// Threshold is the latency objective. Observations above the threshold are bad events.
+	// If zero, the SLO is an availability SLO and all the observations are good events.
+	Threshold time.Duration
+
+	// Windows are the burn rate windows. (default DefaultSLOWindows)
+	Windows []time.Duration
+
+	// Registry is the metrics registry used for the SLO metrics. (default Default())
+	Registry *Registry
+}
+
+// SLO represents a service level objective, which keeps the error budget burn rates over multiple windows in memory.
+// The burn rate is the rate the error budget is spent at: a burn rate of 1 spends the whole budget
+// exactly over the SLO period, while a burn rate of 14.4 spends 2% of a 30 days budget in 1 hour.
+// The SLO is fed through the ObserverMetric and CounterMetric interfaces and exports the following metrics:
+//   - slo_events_total: the number of events.
+//   - slo_bad_events_total: the number of bad events.
+//   - slo_burn_rate: the error budget burn rate, by window.
+//   - slo_objective: the ratio of good events.
+type SLO struct {
+	name      string
+	objective float64
+	period    time.Duration
+	threshold float64
+	windows   []time.Duration
+	prefix    string
+
+	events    CounterMetric
+	badEvents CounterMetric
+	burnRate  *prometheus.Desc
+	target    *prometheus.Desc
+
+	mu         sync.Mutex
+	resolution time.Duration
+	slots      []sloSlot
+	now        func() time.Time
+}
+
+type sloSlot struct {
+	epoch  int64
+	events float64
+	bad    float64
+}
+
+// NewSLO creates a new service level objective and registers its metrics with the registry.
+// It returns an ErrMetricConflict error if an SLO with the same name is already registered with the registry.
+func NewSLO(opts SLOOpts) (*SLO, error) {
+	if opts.Name == "" {
+		return nil, ErrMissingSLOName
+	}
+	if opts.Objective <= 0 || opts.Objective >= 1 {
+		return nil, ErrInvalidObjective
+	}
+	if opts.Period <= 0 {
+		opts.Period = DefaultSLOPeriod
+	}
+	if len(opts.Windows) == 0 {
+		opts.Windows = DefaultSLOWindows
+	}
+	shortest, longest := opts.Windows[0], opts.Windows[0]
+	for _, w := range opts.Windows {
+		if w <= 0 || w > opts.Period {
+			return nil, ErrInvalidWindow
+		}
+		shortest, longest = min(shortest, w), max(longest, w)
+	}
+	r := opts.Registry
+	if r == nil {
+		r = Default()
+	}
+	// The prefix of the default registry is only known once it is initialized.
+	r.init()
+	if err := r.registerSLO(opts.Name); err != nil {
+		return nil, err
+	}
+
+	// The shortest window is split into 10 slots, so the burn rates move smoothly as the windows slide.
+	resolution := max(shortest/10, time.Second)
+	s := &SLO{
+		name:       opts.Name,
+		objective:  opts.Objective,
+		period:     opts.Period,
+		threshold:  opts.Threshold.Seconds(),
+		windows:    opts.Windows,
+		prefix:     r.prefix,
+		events:     r.CounterVec("slo_events_total", "Number of SLO events", "slo").WithLabelValues(opts.Name),
+		badEvents:  r.CounterVec("slo_bad_events_total", "Number of SLO bad events", "slo").WithLabelValues(opts.Name),
+		burnRate:   prometheus.NewDesc("slo_burn_rate", "SLO error budget burn rate", []string{"window"}, prometheus.Labels{"slo": opts.Name}),
+		target:     prometheus.NewDesc("slo_objective", "SLO ratio of good events", nil, prometheus.Labels{"slo": opts.Name}),
+		resolution: resolution,
+		slots:      make([]sloSlot, int(longest/resolution)+1),
+		now:        time.Now,
+	}
+	// The collected metrics are declared as well, so the catalog lists them.
+	site := newCallSite()
+	r.declare("slo_burn_rate", metricDesc{typ: gaugeType, help: "SLO error budget burn rate", labels: []string{"slo", "window"}, site: site})
+	r.declare("slo_objective", metricDesc{typ: gaugeType, help: "SLO ratio of good events", labels: []string{"slo"}, site: site})
+	r.RegisterCollector(s)
+	return s, nil
+}
+
+// Name returns the SLO name.
+func (s *SLO) Name() string {
+	return s.name
+}
+
+// Observe records an observation in seconds, which is a bad event if it is above the SLO threshold.
+// It implements ObserverMetric, so the SLO can be fed by the same code that feeds a histogram.
+func (s *SLO) Observe(v float64) {
+	s.Record(s.threshold == 0 || v <= s.threshold)
+}
+
+// Record records a good or a bad event.
+func (s *SLO) Record(good bool) {
+	if good {
+		s.add(1, 0)
+	} else {
+		s.add(1, 1)
+	}
+}
+
+// Observer returns an ObserverMetric that records every observation both in the given observer and in the SLO,
+// i.e: a response time histogram. Exemplars are passed through to the observer.
+func (s *SLO) Observer(observer ObserverMetric) ObserverMetric {
+	return &sloObserver{observer: observer, slo: s}
+}
+
+// Good returns a CounterMetric that records good events.
+func (s *SLO) Good() CounterMetric {
+	return &sloCounter{slo: s, bad: 0}
+}
+
+// Bad returns a CounterMetric that records bad events.
+func (s *SLO) Bad() CounterMetric {
+	return &sloCounter{slo: s, bad: 1}
+}
+
+// BurnRate returns the error budget burn rate over the given window, i.e: 1 means the budget is spent
+// exactly over the SLO period. The window is rounded up to the SLO resolution (a tenth of the shortest window).
+func (s *SLO) BurnRate(window time.Duration) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epoch := s.now().UnixNano() / int64(s.resolution)
+	n := min(int64(math.Ceil(float64(window)/float64(s.resolution))), int64(len(s.slots)))
+	var events, bad float64
+	for i := int64(0); i < n; i++ {
+		slot := s.slots[(epoch-i)%int64(len(s.slots))]
+		if slot.epoch == epoch-i {
+			events += slot.events
+			bad += slot.bad
+		}
+	}
+	if events == 0 {
+		return 0
+	}
+	return round(bad / events / (1 - s.objective))
+}
+
+// Middleware records every request as an SLO event. A request is a bad event if it responds with a 5xx status code
+// or, for latency SLOs, if it takes longer than the SLO threshold.
+func (s *SLO) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		s.Record(sw.status < 500 && (s.threshold == 0 || time.Since(start).Seconds() <= s.threshold))
+	})
+}
+
+// Describe implements prometheus.Collector.
+func (s *SLO) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.burnRate
+	ch <- s.target
+}
+
+// Collect implements prometheus.Collector, computing the burn rates on every scrape.
+func (s *SLO) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(s.target, prometheus.GaugeValue, s.objective)
+	for _, w := range s.windows {
+		ch <- prometheus.MustNewConstMetric(s.burnRate, prometheus.GaugeValue, s.BurnRate(w), promDuration(w))
+	}
+}
+
+func (s *SLO) add(events, bad float64) {
+	s.events.Add(events)
+	if bad > 0 {
+		s.badEvents.Add(bad)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epoch := s.now().UnixNano() / int64(s.resolution)
+	slot := &s.slots[epoch%int64(len(s.slots))]
+	if slot.epoch != epoch {
+		*slot = sloSlot{epoch: epoch}
+	}
+	slot.events += events
+	slot.bad += bad
+}
+
+// SLORules returns the Prometheus recording and alerting rules of the SLOs, as a rule file that can be loaded
+// using the rule_files configuration. The rules are computed from the slo_events_total and slo_bad_events_total
+// counters, so Prometheus keeps tracking the burn rates across application restarts.
+func SLORules(slos ...*SLO) []byte {
+	var b strings.Builder
+	b.WriteString("groups:\n")
+	for _, s := range slos {
+		s.writeRules(&b)
+	}
+	return []byte(b.String())
+}
+
+func (s *SLO) writeRules(b *strings.Builder) {
+	ratio := s.prefix + "_slo:error_ratio:rate"
+	selector := fmt.Sprintf(`{slo=%q}`, s.name)
+	budget := round(1 - s.objective)
+
+	fmt.Fprintf(b, "  - name: slo_%s\n", s.name)
+	b.WriteString("    rules:\n")
+	for _, w := range s.windows {
+		fmt.Fprintf(b, "      - record: %s%s\n", ratio, promDuration(w))
+		fmt.Fprintf(
+			b,
+			"        expr: sum by (slo) (rate(%s_slo_bad_events_total%s[%s])) / sum by (slo) (rate(%s_slo_events_total%s[%s]))\n",
+			s.prefix, selector, promDuration(w), s.prefix, selector, promDuration(w),
+		)
+	}
+
+	for _, a := range sloAlerts {
+		if !s.hasWindow(a.long) || !s.hasWindow(a.short) || a.long > s.period {
+			continue
+		}
+		threshold := fmt.Sprintf("(%s * %s)", formatFloat(round(a.budget*float64(s.period)/float64(a.long))), formatFloat(budget))
+		b.WriteString("      - alert: SLOErrorBudgetBurn\n")
+		fmt.Fprintf(
+			b,
+			"        expr: %s%s%s > %s and %s%s%s > %s\n",
+			ratio, promDuration(a.long), selector, threshold, ratio, promDuration(a.short), selector, threshold,
+		)
+		fmt.Fprintf(b, "        for: %s\n", a.pending)
+		b.WriteString("        labels:\n")
+		fmt.Fprintf(b, "          severity: %s\n", a.severity)
+		fmt.Fprintf(b, "          slo: %s\n", s.name)
+		fmt.Fprintf(b, "          long_window: %s\n", promDuration(a.long))
+		b.WriteString("        annotations:\n")
+		fmt.Fprintf(
+			b,
+			"          summary: SLO %s is burning %s%% of its %s error budget within %s\n",
+			s.name, formatFloat(a.budget*100), promDuration(s.period), promDuration(a.long),
+		)
+	}
+}
+
+func (s *SLO) hasWindow(window time.Duration) bool {
+	for _, w := range s.windows {
+		if w == window {
+			return true
+		}
+	}
+	return false
+}
+
+type sloObserver struct {
+	observer ObserverMetric
+	slo      *SLO
+}
+
+func (o *sloObserver) Observe(v float64) {
+	o.observer.Observe(v)
+	o.slo.Observe(v)
+}
+
+func (o *sloObserver) ObserveWithExemplar(v float64, exemplar prometheus.Labels) {
+	if eo, ok := o.observer.(prometheus.ExemplarObserver); ok {
+		eo.ObserveWithExemplar(v, exemplar)
+	} else {
+		o.observer.Observe(v)
+	}
+	o.slo.Observe(v)
+}
+
+type sloCounter struct {
+	slo *SLO
+	bad float64
+}
+
+func (c *sloCounter) Inc() {
+	c.Add(1)
+}
+
+func (c *sloCounter) Add(v float64) {
+	c.slo.add(v, v*c.bad)
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// promDuration formats a duration the way Prometheus does, i.e: 5m, 1h, 3d.
+func promDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// round rounds away the floating point noise, i.e: 1 - 0.99 = 0.010000000000000009.
+func round(f float64) float64 {
+	return math.Round(f*1e9) / 1e9
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSLOValidation(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "slo"})
+	for _, tc := range []struct {
+		opts SLOOpts
+		err  error
+	}{
+		{opts: SLOOpts{Objective: 0.99}, err: ErrMissingSLOName},
+		{opts: SLOOpts{Name: "a", Objective: 1}, err: ErrInvalidObjective},
+		{opts: SLOOpts{Name: "a", Objective: 0.99, Period: time.Hour, Windows: []time.Duration{2 * time.Hour}}, err: ErrInvalidWindow},
+		{opts: SLOOpts{Name: "b", Objective: 0.99}, err: nil},
+		{opts: SLOOpts{Name: "b", Objective: 0.9}, err: ErrMetricConflict},
+	} {
+		tc.opts.Registry = r
+		if _, err := NewSLO(tc.opts); !errors.Is(err, tc.err) {
+			t.Errorf("expected %v, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestSLOBurnRate(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "slo"})
+	s, err := NewSLO(SLOOpts{
+		Name:      "register_latency",
+		Objective: 0.99,
+		Threshold: 500 * time.Millisecond,
+		Windows:   []time.Duration{5 * time.Minute, time.Hour},
+		Registry:  r,
+	})
+	if err != nil {
+		t.Fatalf("could not create slo: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+
+	// An hour ago: 100 good events.
+	now = now.Add(-30 * time.Minute)
+	for i := 0; i < 100; i++ {
+		s.Observe(0.1)
+	}
+	// Now: 98 good events and 2 bad ones (slow, then failed).
+	now = now.Add(30 * time.Minute)
+	observer := s.Observer(noopMetric{})
+	for i := 0; i < 98; i++ {
+		observer.Observe(0.2)
+	}
+	observer.Observe(0.7)
+	s.Bad().Inc()
+
+	if got := s.BurnRate(5 * time.Minute); got != 2 {
+		t.Errorf("expected a 5m burn rate of 2, got %v", got)
+	}
+	if got := s.BurnRate(time.Hour); got != 1 {
+		t.Errorf("expected a 1h burn rate of 1, got %v", got)
+	}
+
+	body := scrape(t, r)
+	for _, want := range []string{
+		`slo_slo_burn_rate{slo="register_latency",window="5m"} 2`,
+		`slo_slo_burn_rate{slo="register_latency",window="1h"} 1`,
+		`slo_slo_objective{slo="register_latency"} 0.99`,
+		`slo_slo_events_total{slo="register_latency"} 200`,
+		`slo_slo_bad_events_total{slo="register_latency"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestSLOMiddleware(t *testing.T) {
+	s, err := NewSLO(SLOOpts{Name: "availability", Objective: 0.9, Registry: New(RegistryOpts{Prefix: "slo"})})
+	if err != nil {
+		t.Fatalf("could not create slo: %v", err)
+	}
+
+	status := http.StatusOK
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	for _, status = range []int{http.StatusOK, http.StatusNotFound, http.StatusInternalServerError, http.StatusOK} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	// 1 bad event out of 4, with a 10% error budget.
+	if got := s.BurnRate(5 * time.Minute); got != 2.5 {
+		t.Errorf("expected a burn rate of 2.5, got %v", got)
+	}
+}
+
+func TestSLORules(t *testing.T) {
+	s, err := NewSLO(SLOOpts{Name: "register_latency", Objective: 0.99, Registry: New(RegistryOpts{})})
+	if err != nil {
+		t.Fatalf("could not create slo: %v", err)
+	}
+
+	rules := string(SLORules(s))
+	for _, want := range []string{
+		"  - name: slo_register_latency\n",
+		`      - record: ppp_slo:error_ratio:rate5m` + "\n" +
+			`        expr: sum by (slo) (rate(ppp_slo_bad_events_total{slo="register_latency"}[5m])) / sum by (slo) (rate(ppp_slo_events_total{slo="register_latency"}[5m]))` + "\n",
+		`        expr: ppp_slo:error_ratio:rate1h{slo="register_latency"} > (14.4 * 0.01) and ppp_slo:error_ratio:rate5m{slo="register_latency"} > (14.4 * 0.01)` + "\n",
+		`        expr: ppp_slo:error_ratio:rate3d{slo="register_latency"} > (1 * 0.01) and ppp_slo:error_ratio:rate6h{slo="register_latency"} > (1 * 0.01)` + "\n",
+		"          severity: ticket\n",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("expected rules to contain %q, got:\n%s", want, rules)
+		}
+	}
+	if n := strings.Count(rules, "- alert: SLOErrorBudgetBurn"); n != 4 {
+		t.Errorf("expected 4 alerts, got %d", n)
+	}
+}
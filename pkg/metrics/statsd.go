@@ -0,0 +1,563 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
+)
+
+// Default StatsD configuration values.
+const (
+	DefaultStatsDAddr          = "localhost:8125"
+	DefaultStatsDFlushInterval = time.Second
+
+	// DefaultStatsDMaxPacketSize keeps every UDP packet under the usual 1500 bytes ethernet MTU.
+	DefaultStatsDMaxPacketSize = 1432
+
+	// DefaultStatsDMaxSamples bounds the histogram and summary samples kept per metric between flushes.
+	DefaultStatsDMaxSamples = 1000
+)
+
+// StatsD errors.
+var (
+	ErrInvalidSampleRate = errors.New("statsd sample rate must be between 0 and 1")
+)
+
+// StatsDProviderOpts represents the StatsD metrics configuration options.
+type StatsDProviderOpts struct {
+	// Addr is the StatsD server UDP address. (default "localhost:8125")
+	Addr string
+
+	// DogStatsD enables the DogStatsD protocol extensions: tags, distributions and histograms.
+	// When disabled, the label values are appended to the metric name instead, i.e: name.value1.value2.
+	DogStatsD bool
+
+	// FlushInterval is the interval of sending the client side aggregated metrics. (default 1s)
+	FlushInterval time.Duration
+
+	// SampleRate is the rate counters, histograms and summaries are sampled at.
+	// If zero, every observation is sent.
+	SampleRate float64
+
+	// MaxPacketSize is the maximum size of a single UDP packet. (default 1432)
+	MaxPacketSize int
+
+	// MaxSamples is the maximum number of histogram and summary samples kept per metric between flushes.
+	// Past that, the samples are reservoir sampled and sent with the matching sample rate,
+	// so the StatsD server still computes the right counts. (default 1000)
+	MaxSamples int
+}
+
+// NewStatsDProvider creates a new StatsD provider that implements Provider using StatsD metrics over UDP.
+// Counters and gauges are aggregated client side and sent every flush interval, histograms are sent as
+// distributions (DogStatsD) or timers (StatsD) and summaries as histograms (DogStatsD) or timers (StatsD).
+// StatsD timers are in milliseconds, so the observations of the metrics in seconds are converted,
+// i.e: an observation of 0.25 for http_response_time_seconds is sent as 250|ms.
+// The const labels are sent as tags. Call Close on application shutdown to flush the remaining metrics.
+func NewStatsDProvider(opts StatsDProviderOpts) (*StatsDProvider, error) {
+	if opts.Addr == "" {
+		opts.Addr = DefaultStatsDAddr
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultStatsDFlushInterval
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = DefaultStatsDMaxPacketSize
+	}
+	if opts.MaxSamples <= 0 {
+		opts.MaxSamples = DefaultStatsDMaxSamples
+	}
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		return nil, ErrInvalidSampleRate
+	}
+	if opts.SampleRate == 0 {
+		opts.SampleRate = 1
+	}
+
+	conn, err := net.Dial("udp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to statsd: %w", err)
+	}
+
+	p := &StatsDProvider{
+		opts:       opts,
+		conn:       conn,
+		collectors: prometheus.NewRegistry(),
+		counters:   map[statsDKey]float64{},
+		gauges:     map[statsDKey]*statsDGauge{},
+		timings:    map[statsDKey]*statsDTimings{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// StatsDProvider represents the implementation for StatsD provider.
+type StatsDProvider struct {
+	opts       StatsDProviderOpts
+	conn       net.Conn
+	collectors *prometheus.Registry
+
+	mu       sync.Mutex
+	counters map[statsDKey]float64
+	gauges   map[statsDKey]*statsDGauge
+	timings  map[statsDKey]*statsDTimings
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type statsDKey struct {
+	name string
+	typ  string
+	tags string
+}
+
+type statsDGauge struct {
+	value float64
+	dirty bool
+}
+
+// statsDTimings represents the samples of a histogram or summary between flushes.
+// seen counts all the sampled observations, including the ones that did not make it into the reservoir.
+type statsDTimings struct {
+	values []float64
+	seen   int
+}
+
+// NewCounter creates a new StatsD counter vector metric.
+func (p *StatsDProvider) NewCounter(name, help string, constLabels map[string]string, _ CounterOpts, labels ...string) CounterVecMetric {
+	return statsDCounterVec{statsDVec: p.newVec(name, "c", 1, constLabels, labels)}
+}
+
+// NewGauge creates a new StatsD gauge vector metric.
+func (p *StatsDProvider) NewGauge(name, help string, constLabels map[string]string, _ GaugeOpts, labels ...string) GaugeVecMetric {
+	return statsDGaugeVec{statsDVec: p.newVec(name, "g", 1, constLabels, labels)}
+}
+
+// NewHistogram creates a new StatsD distribution (DogStatsD) or timer (StatsD) vector metric.
+// The buckets are computed by the StatsD server, so the histogram options other than the unit are ignored.
+func (p *StatsDProvider) NewHistogram(name, help string, constLabels map[string]string, opts HistogramOpts, labels ...string) ObserverVecMetric {
+	if p.opts.DogStatsD {
+		return statsDObserverVec{statsDVec: p.newVec(name, "d", 1, constLabels, labels)}
+	}
+	return statsDObserverVec{statsDVec: p.newVec(name, "ms", timerScale(opts.Unit), constLabels, labels)}
+}
+
+// NewSummary creates a new StatsD histogram (DogStatsD) or timer (StatsD) vector metric.
+// The quantiles are computed by the StatsD server, so the summary options other than the unit are ignored.
+func (p *StatsDProvider) NewSummary(name, help string, constLabels map[string]string, opts SummaryOpts, labels ...string) ObserverVecMetric {
+	if p.opts.DogStatsD {
+		return statsDObserverVec{statsDVec: p.newVec(name, "h", 1, constLabels, labels)}
+	}
+	return statsDObserverVec{statsDVec: p.newVec(name, "ms", timerScale(opts.Unit), constLabels, labels)}
+}
+
+// timerScale returns the factor converting the observations of a metric into StatsD timer milliseconds.
+func timerScale(unit Unit) float64 {
+	if unit == UnitSeconds {
+		return 1000
+	}
+	return 1
+}
+
+// WithCollector registers a new collector with the StatsD provider.
+// The collector metrics are gathered and sent as gauges on every flush.
+func (p *StatsDProvider) WithCollector(collector prometheus.Collector) Provider {
+	p.collectors.Unregister(collector)
+	if err := p.collectors.Register(collector); err != nil {
+		logging.GetLogger().Error("could not register statsd collector", zap.Error(err))
+	}
+	return p
+}
+
+// Flush sends all the aggregated metrics right away.
+func (p *StatsDProvider) Flush() error {
+	lines := p.collectorLines()
+
+	p.mu.Lock()
+	for k, v := range p.counters {
+		lines = append(lines, p.line(k, formatFloat(v), p.opts.SampleRate))
+	}
+	for k, g := range p.gauges {
+		if !g.dirty {
+			continue
+		}
+		lines = append(lines, p.gaugeLines(k, g.value)...)
+		g.dirty = false
+	}
+	for k, t := range p.timings {
+		rate := p.opts.SampleRate * float64(len(t.values)) / float64(t.seen)
+		for _, v := range t.values {
+			lines = append(lines, p.line(k, formatFloat(v), rate))
+		}
+	}
+	p.counters = map[statsDKey]float64{}
+	p.timings = map[statsDKey]*statsDTimings{}
+	p.mu.Unlock()
+
+	return p.send(lines)
+}
+
+// Close stops the periodic flushes and flushes the remaining metrics.
+func (p *StatsDProvider) Close() error {
+	p.once.Do(func() { close(p.stop) })
+	<-p.done
+
+	err := p.Flush()
+	if cErr := p.conn.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+func (p *StatsDProvider) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Flush(); err != nil {
+				logging.GetLogger().Error("could not flush statsd metrics", zap.String("addr", p.opts.Addr), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (p *StatsDProvider) sampled() bool {
+	return p.opts.SampleRate >= 1 || rand.Float64() < p.opts.SampleRate
+}
+
+func (p *StatsDProvider) count(k statsDKey, v float64) {
+	if !p.sampled() {
+		return
+	}
+	p.mu.Lock()
+	p.counters[k] += v
+	p.mu.Unlock()
+}
+
+func (p *StatsDProvider) gauge(k statsDKey, fn func(float64) float64) {
+	p.mu.Lock()
+	g, ok := p.gauges[k]
+	if !ok {
+		g = &statsDGauge{}
+		p.gauges[k] = g
+	}
+	g.value = fn(g.value)
+	g.dirty = true
+	p.mu.Unlock()
+}
+
+// observe keeps the sample, until MaxSamples is reached for the key.
+// Past that, every sample replaces a random one with the probability MaxSamples/seen (reservoir sampling),
+// so the kept samples are a uniform sample of all the observations since the last flush.
+func (p *StatsDProvider) observe(k statsDKey, v float64) {
+	if !p.sampled() {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.timings[k]
+	if !ok {
+		t = &statsDTimings{}
+		p.timings[k] = t
+	}
+	t.seen++
+	if len(t.values) < p.opts.MaxSamples {
+		t.values = append(t.values, v)
+		return
+	}
+	if i := rand.Intn(t.seen); i < len(t.values) {
+		t.values[i] = v
+	}
+}
+
+// line formats a single StatsD line: name:value|type[|@sample_rate][|#tags]
+// The sample rate is omitted when it is 1 or more.
+func (p *StatsDProvider) line(k statsDKey, value string, rate float64) string {
+	var sb strings.Builder
+	sb.WriteString(k.name)
+	sb.WriteByte(':')
+	sb.WriteString(value)
+	sb.WriteByte('|')
+	sb.WriteString(k.typ)
+	if rate < 1 {
+		sb.WriteString("|@")
+		sb.WriteString(formatFloat(rate))
+	}
+	if k.tags != "" {
+		sb.WriteString("|#")
+		sb.WriteString(k.tags)
+	}
+	return sb.String()
+}
+
+// gaugeLines formats a gauge. Plain StatsD treats a leading sign as a relative change,
+// so negative gauges have to be reset to zero first.
+func (p *StatsDProvider) gaugeLines(k statsDKey, v float64) []string {
+	if v < 0 && !p.opts.DogStatsD {
+		return []string{p.line(k, "0", 1), p.line(k, formatFloat(v), 1)}
+	}
+	return []string{p.line(k, formatFloat(v), 1)}
+}
+
+func (p *StatsDProvider) collectorLines() []string {
+	mfs, err := p.collectors.Gather()
+	if err != nil {
+		logging.GetLogger().Error("could not gather statsd collectors", zap.Error(err))
+	}
+
+	var lines []string
+	for _, s := range toSeries(mfs, nil) {
+		var name string
+		tags := map[string]string{}
+		for _, l := range s.labels {
+			if l.name == "__name__" {
+				name = l.value
+				continue
+			}
+			tags[l.name] = l.value
+		}
+		if strings.HasSuffix(name, "_bucket") {
+			continue
+		}
+		lines = append(lines, p.gaugeLines(p.key(name, "g", tags), s.value)...)
+	}
+	return lines
+}
+
+// send packs the lines into as few UDP packets as possible.
+func (p *StatsDProvider) send(lines []string) error {
+	var packet []byte
+	for _, l := range lines {
+		if len(packet) > 0 && len(packet)+1+len(l) > p.opts.MaxPacketSize {
+			if _, err := p.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, l...)
+	}
+	if len(packet) > 0 {
+		if _, err := p.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key builds the aggregation key of a metric, which is also its line prefix and suffix.
+// The tags are sorted, so the same labels always aggregate into the same key.
+func (p *StatsDProvider) key(name, typ string, tags map[string]string) statsDKey {
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	if !p.opts.DogStatsD {
+		for _, k := range names {
+			name += "." + sanitizeStatsD(tags[k])
+		}
+		return statsDKey{name: name, typ: typ}
+	}
+
+	pairs := make([]string, 0, len(names))
+	for _, k := range names {
+		pairs = append(pairs, k+":"+sanitizeStatsD(tags[k]))
+	}
+	return statsDKey{name: name, typ: typ, tags: strings.Join(pairs, ",")}
+}
+
+func (p *StatsDProvider) newVec(name, typ string, scale float64, constLabels map[string]string, labels []string) statsDVec {
+	return statsDVec{
+		p:      p,
+		name:   name,
+		typ:    typ,
+		scale:  scale,
+		bound:  constLabels,
+		labels: labels,
+	}
+}
+
+var statsDReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_", ":", "_", "@", "_")
+
+func sanitizeStatsD(s string) string {
+	return statsDReplacer.Replace(s)
+}
+
+// statsDVec represents the common StatsD vector metric, with const and curried labels bound up front.
+// The observations are multiplied by scale, i.e: to convert seconds into timer milliseconds.
+type statsDVec struct {
+	p      *StatsDProvider
+	name   string
+	typ    string
+	scale  float64
+	bound  map[string]string
+	labels []string
+}
+
+func (v statsDVec) keyWith(labels map[string]string) statsDKey {
+	if len(labels) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %q expects labels %v, got %v", v.name, v.labels, labels))
+	}
+	tags := make(map[string]string, len(v.bound)+len(labels))
+	for k, val := range v.bound {
+		tags[k] = val
+	}
+	for _, l := range v.labels {
+		val, ok := labels[l]
+		if !ok {
+			panic(fmt.Sprintf("metrics: %q is missing label %q", v.name, l))
+		}
+		tags[l] = val
+	}
+	return v.p.key(v.name, v.typ, tags)
+}
+
+func (v statsDVec) keyWithValues(values []string) statsDKey {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %q expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	labels := make(map[string]string, len(values))
+	for i, val := range values {
+		labels[v.labels[i]] = val
+	}
+	return v.keyWith(labels)
+}
+
+func (v statsDVec) curry(values []string) statsDVec {
+	if len(values) > len(v.labels) {
+		panic(fmt.Sprintf("metrics: cannot curry %d label values, only %d labels are left", len(values), len(v.labels)))
+	}
+	bound := make(map[string]string, len(v.bound)+len(values))
+	for k, val := range v.bound {
+		bound[k] = val
+	}
+	for i, val := range values {
+		bound[v.labels[i]] = val
+	}
+	v.bound = bound
+	v.labels = v.labels[len(values):]
+	return v
+}
+
+type statsDCounterVec struct {
+	statsDVec
+}
+
+func (c statsDCounterVec) With(labels map[string]string) CounterMetric {
+	return statsDMetric{p: c.p, scale: c.scale, key: c.keyWith(labels)}
+}
+
+func (c statsDCounterVec) WithLabelValues(values ...string) CounterMetric {
+	return statsDMetric{p: c.p, scale: c.scale, key: c.keyWithValues(values)}
+}
+
+func (c statsDCounterVec) Curry(values ...string) CounterVecMetric {
+	return statsDCounterVec{statsDVec: c.curry(values)}
+}
+
+type statsDGaugeVec struct {
+	statsDVec
+}
+
+func (g statsDGaugeVec) With(labels map[string]string) GaugeMetric {
+	return statsDMetric{p: g.p, scale: g.scale, key: g.keyWith(labels)}
+}
+
+func (g statsDGaugeVec) WithLabelValues(values ...string) GaugeMetric {
+	return statsDMetric{p: g.p, scale: g.scale, key: g.keyWithValues(values)}
+}
+
+func (g statsDGaugeVec) Curry(values ...string) GaugeVecMetric {
+	return statsDGaugeVec{statsDVec: g.curry(values)}
+}
+
+// DeleteLabelValues stops sending the gauge, StatsD servers keep reporting its last value until they expire it.
+func (g statsDGaugeVec) DeleteLabelValues(values ...string) bool {
+	k := g.keyWithValues(values)
+	g.p.mu.Lock()
+	defer g.p.mu.Unlock()
+	_, ok := g.p.gauges[k]
+	delete(g.p.gauges, k)
+	return ok
+}
+
+type statsDObserverVec struct {
+	statsDVec
+}
+
+func (o statsDObserverVec) With(labels map[string]string) ObserverMetric {
+	return statsDMetric{p: o.p, scale: o.scale, key: o.keyWith(labels)}
+}
+
+func (o statsDObserverVec) WithLabelValues(values ...string) ObserverMetric {
+	return statsDMetric{p: o.p, scale: o.scale, key: o.keyWithValues(values)}
+}
+
+func (o statsDObserverVec) Curry(values ...string) ObserverVecMetric {
+	return statsDObserverVec{statsDVec: o.curry(values)}
+}
+
+// statsDMetric implements CounterMetric, GaugeMetric and ObserverMetric, depending on the key type.
+type statsDMetric struct {
+	p     *StatsDProvider
+	key   statsDKey
+	scale float64
+}
+
+func (m statsDMetric) Inc() {
+	m.Add(1)
+}
+
+func (m statsDMetric) Dec() {
+	m.Add(-1)
+}
+
+func (m statsDMetric) Add(v float64) {
+	if m.key.typ == "c" {
+		m.p.count(m.key, v)
+		return
+	}
+	m.p.gauge(m.key, func(old float64) float64 { return old + v })
+}
+
+func (m statsDMetric) Sub(v float64) {
+	m.Add(-v)
+}
+
+func (m statsDMetric) Set(v float64) {
+	m.p.gauge(m.key, func(float64) float64 { return v })
+}
+
+func (m statsDMetric) SetToCurrentTime() {
+	m.Set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+func (m statsDMetric) Observe(v float64) {
+	m.p.observe(m.key, v*m.scale)
+}
+
+var _ Provider = (*StatsDProvider)(nil)
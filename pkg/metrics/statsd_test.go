@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStatsDListener(t *testing.T) (*net.UDPConn, func() []string) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("could not listen on udp: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	read := func() []string {
+		t.Helper()
+
+		buf := make([]byte, 64*1024)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("could not read statsd packet: %v", err)
+		}
+		lines := strings.Split(string(buf[:n]), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+	return conn, read
+}
+
+func TestStatsDProviderDogStatsD(t *testing.T) {
+	conn, read := newStatsDListener(t)
+	p, err := NewStatsDProvider(StatsDProviderOpts{
+		Addr:          conn.LocalAddr().String(),
+		DogStatsD:     true,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("could not create statsd provider: %v", err)
+	}
+	defer func() { _ = p.Close() }()
+
+	r := New(RegistryOpts{Prefix: "statsd", ConstLabels: map[string]string{appNameLabel: "test"}, Provider: p})
+	requests := r.CounterVec("requests_total", "Total requests", "path")
+	requests.WithLabelValues("/m1").Inc()
+	requests.With(map[string]string{"path": "/m1"}).Add(2)
+	r.Gauge("in_flight", "In flight requests").Set(-3)
+	r.HistogramVec("latency_seconds", "Latency", "path").Curry("/m2").WithLabelValues().Observe(0.25)
+	r.Summary("size_bytes", "Size").Observe(512)
+
+	if err = p.Flush(); err != nil {
+		t.Fatalf("could not flush statsd metrics: %v", err)
+	}
+
+	want := []string{
+		"statsd_in_flight:-3|g|#app_name:test",
+		"statsd_latency_seconds:0.25|d|#app_name:test,path:/m2",
+		"statsd_requests_total:3|c|#app_name:test,path:/m1",
+		"statsd_size_bytes:512|h|#app_name:test",
+	}
+	if got := read(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected statsd lines:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsDProviderStatsD(t *testing.T) {
+	conn, read := newStatsDListener(t)
+	p, err := NewStatsDProvider(StatsDProviderOpts{
+		Addr:          conn.LocalAddr().String(),
+		FlushInterval: time.Hour,
+		SampleRate:    0.999999,
+	})
+	if err != nil {
+		t.Fatalf("could not create statsd provider: %v", err)
+	}
+	defer func() { _ = p.Close() }()
+
+	r := New(RegistryOpts{Prefix: "statsd", Provider: p})
+	r.GaugeVec("temperature", "Temperature", "room").WithLabelValues("kitchen").Set(-2)
+	r.HistogramVec("latency", "Latency in milliseconds", "path").WithLabelValues("/m1").Observe(120)
+
+	if err = p.Flush(); err != nil {
+		t.Fatalf("could not flush statsd metrics: %v", err)
+	}
+
+	got := strings.Join(read(), "\n")
+	for _, want := range []string{
+		"statsd_temperature.kitchen:0|g",
+		"statsd_temperature.kitchen:-2|g",
+		"statsd_latency./m1:120|ms|@0.999999",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected statsd lines to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestStatsDProviderTimerUnits(t *testing.T) {
+	conn, read := newStatsDListener(t)
+	p, err := NewStatsDProvider(StatsDProviderOpts{Addr: conn.LocalAddr().String(), FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("could not create statsd provider: %v", err)
+	}
+	defer func() { _ = p.Close() }()
+
+	r := New(RegistryOpts{Prefix: "statsd", Provider: p})
+	r.Histogram("latency_seconds", "Latency").Observe(0.25)
+	r.SummaryWithOpts("duration_seconds", SummaryOpts{Unit: UnitSeconds}, "Duration").Observe(1.5)
+	r.Histogram("size_bytes", "Size").Observe(512)
+
+	if err = p.Flush(); err != nil {
+		t.Fatalf("could not flush statsd metrics: %v", err)
+	}
+
+	want := []string{
+		"statsd_duration_seconds:1500|ms",
+		"statsd_latency_seconds:250|ms",
+		"statsd_size_bytes:512|ms",
+	}
+	if got := read(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected statsd lines:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsDProviderMaxSamples(t *testing.T) {
+	conn, read := newStatsDListener(t)
+	p, err := NewStatsDProvider(StatsDProviderOpts{
+		Addr:          conn.LocalAddr().String(),
+		DogStatsD:     true,
+		FlushInterval: time.Hour,
+		MaxSamples:    2,
+	})
+	if err != nil {
+		t.Fatalf("could not create statsd provider: %v", err)
+	}
+	defer func() { _ = p.Close() }()
+
+	latency := New(RegistryOpts{Prefix: "statsd", Provider: p}).Histogram("latency_seconds", "Latency")
+	for i := 0; i < 5; i++ {
+		latency.Observe(float64(i))
+	}
+	if err = p.Flush(); err != nil {
+		t.Fatalf("could not flush statsd metrics: %v", err)
+	}
+
+	got := read()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 samples, got %v", got)
+	}
+	for _, l := range got {
+		if !strings.HasSuffix(l, "|d|@0.4") {
+			t.Errorf("expected the sample rate of the kept samples, got %q", l)
+		}
+	}
+}
+
+func TestStatsDProviderInvalidSampleRate(t *testing.T) {
+	if _, err := NewStatsDProvider(StatsDProviderOpts{SampleRate: 2}); err != ErrInvalidSampleRate {
+		t.Errorf("expected ErrInvalidSampleRate, got %v", err)
+	}
+}
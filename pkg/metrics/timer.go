@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceIDExemplarKey is the exemplar label key used to link an observation to its trace.
+const TraceIDExemplarKey = "trace_id"
+
+// Timer measures the duration of an operation and records it in an ObserverMetric (histogram/summary).
+// Durations are observed in seconds by default, following the Prometheus naming conventions,
+// i.e: http_response_time_seconds. Use it with defer:
+//
+//	defer metrics.NewTimer(histogram).ObserveDuration()
+type Timer struct {
+	observer ObserverMetric
+	start    time.Time
+	unit     time.Duration
+	ctx      context.Context
+	spanName string
+}
+
+// TimerOption represents a Timer configuration option.
+type TimerOption func(*Timer)
+
+// WithUnit sets the unit the duration is observed in, i.e: time.Millisecond. (default time.Second)
+// Durations are observed as floats, so sub-unit durations are never truncated to zero.
+func WithUnit(unit time.Duration) TimerOption {
+	return func(t *Timer) {
+		if unit > 0 {
+			t.unit = unit
+		}
+	}
+}
+
+// WithExemplar attaches the trace id of the span in ctx to the observation as an exemplar,
+// if the observer supports exemplars (i.e: Prometheus histograms) and the span is sampled.
+func WithExemplar(ctx context.Context) TimerOption {
+	return func(t *Timer) {
+		t.ctx = ctx
+	}
+}
+
+// WithSpan makes Time record the timed operation as a tracing span with the given name as well.
+// It has no effect on NewTimer.
+func WithSpan(name string) TimerOption {
+	return func(t *Timer) {
+		t.spanName = name
+	}
+}
+
+// NewTimer creates a new Timer that starts measuring right away.
+func NewTimer(observer ObserverMetric, opts ...TimerOption) *Timer {
+	t := &Timer{
+		observer: observer,
+		unit:     time.Second,
+		ctx:      context.Background(),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.start = time.Now()
+	return t
+}
+
+// ObserveDuration records the duration passed since the Timer was created and returns it.
+func (t *Timer) ObserveDuration() time.Duration {
+	d := time.Since(t.start)
+	ObserveDuration(t.ctx, t.observer, d, t.unit)
+	return d
+}
+
+// ObserveDuration records a duration in the given unit, attaching the trace id of the span in ctx
+// as an exemplar if possible. Use context.Background() when there is no span to link to.
+func ObserveDuration(ctx context.Context, observer ObserverMetric, d time.Duration, unit time.Duration) {
+	v := float64(d) / float64(unit)
+	if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+			eo.ObserveWithExemplar(v, prometheus.Labels{TraceIDExemplarKey: sc.TraceID().String()})
+			return
+		}
+	}
+	observer.Observe(v)
+}
+
+// Time runs fn and records its duration in the observer, attaching the trace id of the span in ctx as an exemplar.
+// With the WithSpan option, fn also runs inside a new span, which records the returned error,
+// so a single call records both the metric and the span.
+func Time(ctx context.Context, observer ObserverMetric, fn func(context.Context) error, opts ...TimerOption) error {
+	t := NewTimer(observer, append([]TimerOption{WithExemplar(ctx)}, opts...)...)
+	if t.spanName == "" {
+		defer t.ObserveDuration()
+		return fn(ctx)
+	}
+
+	ctx, span := otel.Tracer(t.spanName).Start(ctx, t.spanName)
+	t.ctx = ctx
+	defer span.End()
+	defer t.ObserveDuration()
+
+	err := fn(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.RecordError(err)
+	}
+	return err
+}
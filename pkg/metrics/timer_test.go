@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/tracing/tracingtest"
+)
+
+type recordingObserver struct {
+	values []float64
+}
+
+func (o *recordingObserver) Observe(v float64) {
+	o.values = append(o.values, v)
+}
+
+func TestObserveDurationUnits(t *testing.T) {
+	o := &recordingObserver{}
+	ObserveDuration(context.Background(), o, 250*time.Microsecond, time.Second)
+	ObserveDuration(context.Background(), o, 250*time.Microsecond, time.Millisecond)
+
+	if o.values[0] != 0.00025 {
+		t.Errorf("expected 0.00025 seconds, got %v", o.values[0])
+	}
+	if o.values[1] != 0.25 {
+		t.Errorf("expected sub-millisecond durations not to be truncated, got %v", o.values[1])
+	}
+}
+
+func TestTimeWithExemplar(t *testing.T) {
+	r := New(RegistryOpts{Prefix: "timer"})
+	h := r.Histogram("operation_seconds", "Operation duration")
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	errFailed := errors.New("failed")
+	err := Time(ctx, h, func(context.Context) error { return errFailed })
+	if !errors.Is(err, errFailed) {
+		t.Errorf("expected the fn error to be returned, got %v", err)
+	}
+
+	histogram := gatherHistogram(t, r, "timer_operation_seconds")
+	if histogram.GetSampleCount() != 1 {
+		t.Fatalf("expected 1 observation, got %d", histogram.GetSampleCount())
+	}
+	for _, b := range histogram.GetBucket() {
+		if e := b.GetExemplar(); e != nil {
+			if e.GetLabel()[0].GetValue() != traceID.String() {
+				t.Errorf("expected exemplar trace id %s, got %s", traceID, e.GetLabel()[0].GetValue())
+			}
+			return
+		}
+	}
+	t.Errorf("expected the observation to have a trace id exemplar")
+}
+
+func TestTimeWithSpan(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+	r := New(RegistryOpts{Prefix: "timer"})
+	h := r.Histogram("operation_seconds", "Operation duration")
+
+	ctx, parent := recorder.Provider().Tracer("timer_test").Start(context.Background(), "register_user")
+	errFailed := errors.New("failed")
+	var spanCtx trace.SpanContext
+	err := Time(ctx, h, func(ctx context.Context) error {
+		spanCtx = trace.SpanContextFromContext(ctx)
+		return errFailed
+	}, WithSpan("register_user_txn"))
+	parent.End()
+	if !errors.Is(err, errFailed) {
+		t.Errorf("expected the fn error to be returned, got %v", err)
+	}
+
+	recorder.AssertTree(t, tracingtest.Span{
+		Name: "register_user",
+		Children: []tracingtest.Span{
+			{Name: "register_user_txn", Status: "Error", Events: []string{"exception"}},
+		},
+	})
+
+	histogram := gatherHistogram(t, r, "timer_operation_seconds")
+	if histogram.GetSampleCount() != 1 {
+		t.Fatalf("expected 1 observation, got %d", histogram.GetSampleCount())
+	}
+	for _, b := range histogram.GetBucket() {
+		if e := b.GetExemplar(); e != nil {
+			if got := e.GetLabel()[0].GetValue(); got != spanCtx.TraceID().String() {
+				t.Errorf("expected exemplar trace id %s, got %s", spanCtx.TraceID(), got)
+			}
+			return
+		}
+	}
+	t.Errorf("expected the observation to have the span trace id exemplar")
+}
+
+func gatherHistogram(t *testing.T, r *Registry, name string) *dto.Histogram {
+	t.Helper()
+
+	mfs, err := r.Provider().(PrometheusProvider).Gatherer().Gather()
+	if err != nil {
+		t.Fatalf("could not gather metrics: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetHistogram()
+		}
+	}
+	t.Fatalf("could not find the %s histogram", name)
+	return nil
+}
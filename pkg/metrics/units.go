@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Base units, following the Prometheus naming conventions: https://prometheus.io/docs/practices/naming/#base-units
+const (
+	UnitNone    Unit = ""
+	UnitSeconds Unit = "seconds"
+	UnitBytes   Unit = "bytes"
+	UnitRatio   Unit = "ratio"
+)
+
+// units are the units inferred from the metric name suffix, when no unit is set.
+var units = []Unit{UnitSeconds, UnitBytes, UnitRatio}
+
+// nonBaseUnits are the name suffixes of the units to be converted to the base units, i.e: _ms to _seconds.
+var nonBaseUnits = map[string]Unit{
+	"nanoseconds":  UnitSeconds,
+	"microseconds": UnitSeconds,
+	"milliseconds": UnitSeconds,
+	"ms":           UnitSeconds,
+	"minutes":      UnitSeconds,
+	"hours":        UnitSeconds,
+	"kilobytes":    UnitBytes,
+	"megabytes":    UnitBytes,
+	"gigabytes":    UnitBytes,
+	"percent":      UnitRatio,
+}
+
+// Unit represents the unit of a metric, which is also the metric name suffix, i.e: http_response_time_seconds.
+// Counters end with the _total suffix after the unit, i.e: cpu_seconds_total.
+type Unit string
+
+// unitOf validates that the metric name ends with the unit suffix, or infers the unit from the name suffix if empty.
+// Every metric constructor goes through it, so the names ending with a non base unit (i.e: _ms) are always rejected.
+func unitOf(name string, typ metricType, unit Unit) (Unit, error) {
+	base := name
+	if typ == counterType {
+		base = strings.TrimSuffix(name, "_total")
+	}
+	if i := strings.LastIndexByte(base, '_'); i >= 0 {
+		if u, ok := nonBaseUnits[base[i+1:]]; ok {
+			return UnitNone, fmt.Errorf("%w: metric %q must use the base unit %q instead of %q", ErrInvalidUnit, name, u, base[i+1:])
+		}
+	}
+	if unit == UnitNone {
+		for _, u := range units {
+			if strings.HasSuffix(base, "_"+string(u)) {
+				return u, nil
+			}
+		}
+		return UnitNone, nil
+	}
+
+	if !strings.HasSuffix(base, "_"+string(unit)) {
+		suffix := "_" + string(unit)
+		if typ == counterType {
+			suffix += "_total"
+		}
+		return UnitNone, fmt.Errorf("%w: metric %q must end with the %q suffix", ErrInvalidUnit, name, suffix)
+	}
+	return unit, nil
+}
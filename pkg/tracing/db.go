@@ -0,0 +1,274 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DBRowsAffectedKey is the number of rows returned or affected by a database operation.
+// Semantic conventions v1.26 do not define one yet.
+const DBRowsAffectedKey = attribute.Key("db.rows_affected")
+
+// DBOption configures a database span created by StartDB.
+type DBOption func(*dbSpanConfig)
+
+type dbSpanConfig struct {
+	name         string
+	statement    string
+	table        string
+	startOptions []trace.SpanStartOption
+}
+
+// WithDBName sets the database name, i.e: users.
+func WithDBName(name string) DBOption {
+	return func(cfg *dbSpanConfig) {
+		cfg.name = name
+	}
+}
+
+// WithStatement sets the database statement, i.e: SELECT * FROM users WHERE id = $1.
+// The statement is sanitized, the string and numeric literals are replaced with ?.
+// The operation and table are parsed from the statement, if not set otherwise.
+func WithStatement(statement string) DBOption {
+	return func(cfg *dbSpanConfig) {
+		cfg.statement = statement
+	}
+}
+
+// WithTable sets the main database table the operation acts upon, i.e: users.
+func WithTable(table string) DBOption {
+	return func(cfg *dbSpanConfig) {
+		cfg.table = table
+	}
+}
+
+// withSpanStartOption adds a span start option, i.e: the start time of the spans created after the operation completed.
+func withSpanStartOption(opt trace.SpanStartOption) DBOption {
+	return func(cfg *dbSpanConfig) {
+		cfg.startOptions = append(cfg.startOptions, opt)
+	}
+}
+
+// DBSpan represents a database operation Open Telemetry tracing Span.
+type DBSpan struct {
+	trace.Span
+	ctx context.Context
+}
+
+// StartDB creates a new database Open Telemetry tracing Span, following the database semantic conventions.
+// The system is the database management system, i.e: postgresql, and the operation is the SQL keyword, i.e: SELECT.
+// The span is named after the operation and table, i.e: SELECT users.
+// Use this every time you want to trace a database operation, ending the span with EndQuery.
+func StartDB(ctx context.Context, system, operation string, opts ...DBOption) (context.Context, *DBSpan) {
+	var cfg dbSpanConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	attributes := []attribute.KeyValue{
+		semconv.DBSystemKey.String(system),
+		attribute.String(DBAttributeKey, system),
+	}
+	if cfg.name != "" {
+		attributes = append(attributes, semconv.DBNamespace(cfg.name))
+	}
+	if cfg.statement != "" {
+		attributes = append(attributes, semconv.DBQueryText(SanitizeStatement(cfg.statement)))
+		if operation == "" {
+			operation = statementOperation(cfg.statement)
+		}
+		if cfg.table == "" {
+			cfg.table = statementTable(cfg.statement)
+		}
+	}
+	if operation != "" {
+		attributes = append(attributes, semconv.DBOperationName(operation))
+	}
+	if cfg.table != "" {
+		attributes = append(attributes, semconv.DBCollectionName(cfg.table))
+	}
+
+	spanName := strings.TrimSpace(operation + " " + cfg.table)
+	if spanName == "" {
+		spanName = system
+	}
+	attributes = append(attributes, operationNameAttribute(spanName))
+
+	startOptions := append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	}, cfg.startOptions...)
+	ctx, span := otel.Tracer(spanName).Start(ctx, spanName, startOptions...)
+
+	return ctx, &DBSpan{Span: span, ctx: ctx}
+}
+
+// EndQuery ends the database span, recording the number of rows returned or affected, unless negative,
+// and the error if any. sql.ErrNoRows is not recorded as an error.
+func (s *DBSpan) EndQuery(rowsAffected int64, err error, opts ...trace.SpanEndOption) {
+	if rowsAffected >= 0 {
+		s.SetAttributes(DBRowsAffectedKey.Int64(rowsAffected))
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		RecordError(s.ctx, err, "database operation failed")
+	}
+	s.End(opts...)
+}
+
+// SanitizeStatement replaces the string and numeric literals of the SQL statement with ?,
+// so the statement does not capture sensitive values, i.e:
+//
+//	SELECT * FROM users WHERE email = 'john@doe.com' AND age > 30 AND id = $1
+//
+// becomes:
+//
+//	SELECT * FROM users WHERE email = ? AND age > ? AND id = $1
+//
+// The placeholders and quoted identifiers are left untouched, while the comments are removed,
+// as they may capture sensitive values too, i.e: -- email=john@doe.com.
+func SanitizeStatement(statement string) string {
+	var b strings.Builder
+	b.Grow(len(statement))
+
+	s := []rune(statement)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			i = skipString(s, i, false)
+			b.WriteRune('?')
+		case c == '"':
+			// quoted identifier
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				j++
+			}
+			b.WriteString(string(s[i:min(j+1, len(s))]))
+			i = j
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			// line comment, the line break is kept
+			for i+1 < len(s) && s[i+1] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			// block comment, which may be nested
+			depth := 0
+			for ; i+1 < len(s); i++ {
+				if s[i] == '/' && s[i+1] == '*' {
+					depth++
+					i++
+				} else if s[i] == '*' && s[i+1] == '/' {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			i = min(i, len(s)-1)
+			b.WriteRune(' ')
+		case c == '$' && i+1 < len(s) && unicode.IsDigit(s[i+1]):
+			// positional placeholder, i.e: $1
+			j := i + 1
+			for j < len(s) && unicode.IsDigit(s[j]) {
+				j++
+			}
+			b.WriteString(string(s[i:j]))
+			i = j - 1
+		case c == '$':
+			// dollar quoted string literal, i.e: $tag$text$tag$
+			j := i + 1
+			for j < len(s) && isIdentifier(s[j]) {
+				j++
+			}
+			if j >= len(s) || s[j] != '$' {
+				b.WriteRune(c)
+				continue
+			}
+			tag := string(s[i : j+1])
+			n := j - i + 1
+			i = len(s)
+			for k := j + 1; k+n <= len(s); k++ {
+				if string(s[k:k+n]) == tag {
+					i = k + n - 1
+					break
+				}
+			}
+			b.WriteRune('?')
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (unicode.IsDigit(s[j]) || s[j] == '.' || s[j] == 'e' || s[j] == 'E') {
+				j++
+			}
+			b.WriteRune('?')
+			i = j - 1
+		case (c == 'E' || c == 'e') && i+1 < len(s) && s[i+1] == '\'' && (i == 0 || !isIdentifier(s[i-1])):
+			// escape string literal, i.e: E'it\'s', where a backslash escapes a quote
+			i = skipString(s, i+1, true)
+			b.WriteRune('?')
+		case isIdentifier(c):
+			// identifiers may contain digits, i.e: books1
+			j := i
+			for j < len(s) && isIdentifier(s[j]) {
+				j++
+			}
+			b.WriteString(string(s[i:j]))
+			i = j - 1
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// skipString returns the index of the closing quote of the string literal starting at the quote at i,
+// or the last index if the literal is not terminated. A doubled quote escapes a quote, as does a backslash in escape strings.
+func skipString(s []rune, i int, backslash bool) int {
+	for i++; i < len(s); i++ {
+		switch {
+		case backslash && s[i] == '\\':
+			i++
+		case s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == '\'':
+			return i
+		}
+	}
+	return len(s) - 1
+}
+
+func isIdentifier(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// statementOperation returns the statement SQL keyword, i.e: SELECT.
+func statementOperation(statement string) string {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimLeft(fields[0], "("))
+}
+
+// statementTable returns the first table the statement acts upon, i.e: users for SELECT * FROM users.
+func statementTable(statement string) string {
+	fields := strings.Fields(statement)
+	for i := 0; i < len(fields)-1; i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "FROM", "INTO", "UPDATE", "JOIN", "TABLE":
+			table := strings.Trim(fields[i+1], `"();,`)
+			if table != "" && !strings.EqualFold(table, "SELECT") {
+				return strings.ReplaceAll(table, `"`, "")
+			}
+		}
+	}
+	return ""
+}
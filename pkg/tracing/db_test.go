@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/tracing/tracingtest"
+)
+
+func TestSanitizeStatement(t *testing.T) {
+	for _, tc := range []struct {
+		statement string
+		want      string
+	}{
+		{
+			statement: `SELECT * FROM users WHERE email = 'john@doe.com' AND age > 30 AND id = $1`,
+			want:      `SELECT * FROM users WHERE email = ? AND age > ? AND id = $1`,
+		},
+		{
+			statement: `INSERT INTO "books1" ("id", "title") VALUES ('b-1', 'It''s 1984'), ($1, $2)`,
+			want:      `INSERT INTO "books1" ("id", "title") VALUES (?, ?), ($1, $2)`,
+		},
+		{
+			statement: `UPDATE accounts SET balance = balance - 10.5e2 WHERE id = 42 -- debit 42`,
+			want:      `UPDATE accounts SET balance = balance - ? WHERE id = ?`,
+		},
+		{
+			statement: "SELECT id /* email=john@doe.com /* nested */ */ FROM users -- email=john@doe.com\nWHERE email = $1",
+			want:      "SELECT id   FROM users \nWHERE email = $1",
+		},
+		{
+			statement: `SELECT * FROM users WHERE note = E'it\'s secret' OR note = e'\\' OR name = 'E'`,
+			want:      `SELECT * FROM users WHERE note = ? OR note = ? OR name = ?`,
+		},
+		{
+			statement: `SELECT $body$it's a 'secret'$body$, $$42$$ FROM "weird ""name"`,
+			want:      `SELECT ?, ? FROM "weird ""name"`,
+		},
+		{
+			statement: `SELECT 'unterminated`,
+			want:      `SELECT ?`,
+		},
+	} {
+		if got := SanitizeStatement(tc.statement); got != tc.want {
+			t.Errorf("SanitizeStatement(%q)\nexpected: %s\ngot:      %s", tc.statement, tc.want, got)
+		}
+	}
+}
+
+func TestStatementTable(t *testing.T) {
+	for statement, want := range map[string]string{
+		`SELECT * FROM users WHERE id = $1`:                 "users",
+		`INSERT INTO "authors1" ("id", "name") VALUES ($1)`: "authors1",
+		`UPDATE books SET title = $1`:                       "books",
+		`DELETE FROM public.book_copies`:                    "public.book_copies",
+		`SELECT 1`:                                          "",
+	} {
+		if got := statementTable(statement); got != want {
+			t.Errorf("statementTable(%q): expected %q, got %q", statement, want, got)
+		}
+	}
+}
+
+func TestStartDB(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+
+	ctx, parent := recorder.Provider().Tracer("db_test").Start(context.Background(), "register_user_txn")
+	_, span := StartDB(ctx, PostgresAttributeValue, "", WithDBName("users"), WithStatement(`SELECT * FROM users WHERE email = 'john@doe.com'`))
+	span.EndQuery(1, nil)
+	_, span = StartDB(ctx, PostgresAttributeValue, "INSERT", WithTable("profiles"))
+	span.EndQuery(-1, errors.New("duplicate key"))
+	_, span = StartDB(ctx, PostgresAttributeValue, "", WithStatement("SELECT * FROM profiles"))
+	span.EndQuery(0, sql.ErrNoRows)
+	parent.End()
+
+	recorder.AssertTree(t, tracingtest.Span{
+		Name: "register_user_txn",
+		Children: []tracingtest.Span{
+			{
+				Name: "SELECT users",
+				Kind: trace.SpanKindClient,
+				Attributes: map[string]any{
+					"db.system":          "postgresql",
+					"db.namespace":       "users",
+					"db.query.text":      "SELECT * FROM users WHERE email = ?",
+					"db.operation.name":  "SELECT",
+					"db.collection.name": "users",
+					"db.rows_affected":   1,
+					"db":                 "postgresql",
+				},
+				Status: "Unset",
+			},
+			{Name: "INSERT profiles", Status: "Error", Events: []string{"exception"}},
+			{Name: "SELECT profiles", Status: "Unset", Attributes: map[string]any{"db.rows_affected": 0}},
+		},
+	})
+	if s, _ := recorder.Span("INSERT profiles"); s != nil {
+		for _, a := range s.Attributes() {
+			if a.Key == DBRowsAffectedKey {
+				t.Errorf("expected no rows affected for a negative row count, got %v", a.Value.AsInterface())
+			}
+		}
+	}
+}
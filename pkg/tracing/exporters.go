@@ -0,0 +1,636 @@
+package tracing
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
+)
+
+// Default span exporters configuration values.
+const (
+	DefaultOTLPTimeout      = 5 * time.Second
+	DefaultOTLPEndpoint     = "localhost:4317"
+	DefaultOTLPHTTPEndpoint = "localhost:4318"
+	DefaultTraceFilePath    = "traces.jsonl"
+)
+
+// Span exporter environment variables, following the OTEL_TRACES_EXPORTER and OTEL_EXPORTER_OTLP_* semantics.
+// Every OTEL_EXPORTER_OTLP_* variable can also be set as OTEL_EXPORTER_OTLP_TRACES_*, which takes precedence.
+// https://opentelemetry.io/docs/specs/otel/protocol/exporter/
+const (
+	// ExporterEnv is the exporter name: otlp, console (stdout), file or none.
+	ExporterEnv = "OTEL_TRACES_EXPORTER"
+
+	// OTLPProtocolEnv is the OTLP exporter protocol: grpc or http/protobuf.
+	OTLPProtocolEnv = "OTEL_EXPORTER_OTLP_PROTOCOL"
+
+	// OTLPEndpointEnv is the OTLP collector endpoint, i.e: https://collector:4317.
+	// The otlphttp exporter appends /v1/traces to its path, i.e: https://gateway/otlp posts to https://gateway/otlp/v1/traces.
+	OTLPEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+	// OTLPTracesEndpointEnv is the OTLP collector traces endpoint, used as is by the otlphttp exporter,
+	// i.e: https://collector:4318/v1/traces. It takes precedence over OTEL_EXPORTER_OTLP_ENDPOINT.
+	OTLPTracesEndpointEnv = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+
+	// OTLPHeadersEnv are the OTLP exporter headers, as a comma separated list of url encoded key=value pairs.
+	OTLPHeadersEnv = "OTEL_EXPORTER_OTLP_HEADERS"
+
+	// OTLPCompressionEnv is the OTLP exporter compression: gzip or none.
+	OTLPCompressionEnv = "OTEL_EXPORTER_OTLP_COMPRESSION"
+
+	// OTLPInsecureEnv disables TLS for the OTLP exporter: true or false.
+	OTLPInsecureEnv = "OTEL_EXPORTER_OTLP_INSECURE"
+
+	// OTLPCertificateEnv is the certificate authority file used to verify the collector certificate.
+	OTLPCertificateEnv = "OTEL_EXPORTER_OTLP_CERTIFICATE"
+
+	// OTLPTimeoutEnv is the OTLP exporter timeout, in milliseconds.
+	OTLPTimeoutEnv = "OTEL_EXPORTER_OTLP_TIMEOUT"
+
+	// TraceFilePathEnv is the file exporter path.
+	TraceFilePathEnv = "OTEL_EXPORTER_FILE_PATH"
+)
+
+// Supported OTLP protocols.
+const (
+	GRPCProtocol         = "grpc"
+	HTTPProtobufProtocol = "http/protobuf"
+)
+
+// ExporterConfig represents the span exporter configuration, used to choose the span exporter.
+type ExporterConfig struct {
+	// Exporter is the span exporter name: otlp, otlphttp, stdout, file, none, or any registered span exporter.
+	// Use a comma separated list to export the spans to multiple exporters, i.e: otlp,file. (default otlp)
+	Exporter string
+
+	// Endpoint is the OTLP collector endpoint, i.e: collector:4317 or https://collector:4318/v1/traces.
+	// (default localhost:4317 for otlp and localhost:4318 for otlphttp)
+	Endpoint string
+
+	// EndpointEnv is the environment variable which supplied the endpoint, if any: OTEL_EXPORTER_OTLP_ENDPOINT
+	// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT. The otlphttp exporter uses it to build the traces URL.
+	EndpointEnv string
+
+	// OTLP is the OTLP exporters configuration.
+	OTLP OTLPExporterConfig
+
+	// FilePath is the file exporter path. (default traces.jsonl)
+	FilePath string
+}
+
+// ExporterFactory represents a span exporter factory, creating the span exporter from the configuration.
+type ExporterFactory func(cfg ExporterConfig) (SpanExporter, error)
+
+var (
+	exportersMu sync.RWMutex
+	exporters   = map[string]ExporterFactory{
+		OTLPTraceExporterName: func(cfg ExporterConfig) (SpanExporter, error) {
+			if cfg.Endpoint == "" {
+				cfg.Endpoint = DefaultOTLPEndpoint
+			}
+			return OTLPSpanExporter(cfg.Endpoint, cfg.OTLP), nil
+		},
+		OTLPHTTPTraceExporterName: func(cfg ExporterConfig) (SpanExporter, error) {
+			if cfg.Endpoint == "" {
+				cfg.Endpoint = DefaultOTLPHTTPEndpoint
+			}
+			return OTLPHTTPSpanExporter(otlpHTTPTracesURL(cfg.Endpoint, cfg.EndpointEnv), cfg.OTLP), nil
+		},
+		StdoutTraceExporterName: func(ExporterConfig) (SpanExporter, error) {
+			return StdoutSpanExporter(nil), nil
+		},
+		FileTraceExporterName: func(cfg ExporterConfig) (SpanExporter, error) {
+			if cfg.FilePath == "" {
+				cfg.FilePath = DefaultTraceFilePath
+			}
+			return FileSpanExporter(cfg.FilePath), nil
+		},
+	}
+)
+
+// RegisterExporter registers a span exporter factory by name, making it available to NewSpanExporter,
+// i.e: to choose a custom span exporter using OTEL_TRACES_EXPORTER. It replaces any factory with the same name.
+func RegisterExporter(name string, factory ExporterFactory) {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+	exporters[strings.ToLower(name)] = factory
+}
+
+// NewSpanExporter creates the named span exporter (i.e: otlp), using its registered factory.
+func NewSpanExporter(name string, cfg ExporterConfig) (SpanExporter, error) {
+	exportersMu.RLock()
+	factory, ok := exporters[strings.ToLower(strings.TrimSpace(name))]
+	exportersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidExporter, name)
+	}
+	return factory(cfg)
+}
+
+// NewSpanExporters creates the span exporters chosen by the configuration, which is a comma separated list
+// of exporter names, i.e: otlp,file. The none exporter returns no span exporters, which disables tracing in NewTracerProvider.
+func NewSpanExporters(cfg ExporterConfig) ([]SpanExporter, error) {
+	names := cfg.Exporter
+	if strings.TrimSpace(names) == "" {
+		names = OTLPTraceExporterName
+	}
+
+	var ses []SpanExporter
+	for _, name := range strings.Split(names, ",") {
+		if strings.ToLower(strings.TrimSpace(name)) == NoneTraceExporterName {
+			continue
+		}
+		se, err := NewSpanExporter(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		ses = append(ses, se)
+	}
+	return ses, nil
+}
+
+// ExporterConfigFromEnv returns the defaults span exporter configuration, overridden by the span exporter
+// environment variables which are set, i.e: OTEL_TRACES_EXPORTER=otlp and OTEL_EXPORTER_OTLP_PROTOCOL=http/protobuf
+// choose the otlphttp exporter.
+func ExporterConfigFromEnv(defaults ExporterConfig) (ExporterConfig, error) {
+	cfg := defaults
+	if len(defaults.OTLP.Headers) > 0 {
+		cfg.OTLP.Headers = make(map[string]string, len(defaults.OTLP.Headers))
+		for k, v := range defaults.OTLP.Headers {
+			cfg.OTLP.Headers[k] = v
+		}
+	}
+
+	if exporter, ok := os.LookupEnv(ExporterEnv); ok {
+		cfg.Exporter = strings.ToLower(strings.TrimSpace(exporter))
+	}
+	otlpExporter := ""
+	switch protocol, _ := otlpEnv(OTLPProtocolEnv); protocol {
+	case "":
+	case GRPCProtocol:
+		otlpExporter = OTLPTraceExporterName
+	case HTTPProtobufProtocol:
+		otlpExporter = OTLPHTTPTraceExporterName
+	default:
+		return ExporterConfig{}, fmt.Errorf("%w: unsupported otlp protocol %q", ErrInvalidExporter, protocol)
+	}
+	names := strings.Split(cfg.Exporter, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		switch names[i] {
+		case "console":
+			names[i] = StdoutTraceExporterName
+		case "", OTLPTraceExporterName, OTLPHTTPTraceExporterName:
+			if otlpExporter != "" {
+				names[i] = otlpExporter
+			}
+		}
+	}
+	cfg.Exporter = strings.Join(names, ",")
+	if endpoint, key, ok := otlpEnvKey(OTLPEndpointEnv); ok {
+		cfg.Endpoint = endpoint
+		cfg.EndpointEnv = key
+	}
+	if headers, ok := otlpEnv(OTLPHeadersEnv); ok {
+		if cfg.OTLP.Headers == nil {
+			cfg.OTLP.Headers = map[string]string{}
+		}
+		for _, header := range strings.Split(headers, ",") {
+			k, v, ok := strings.Cut(header, "=")
+			if !ok {
+				return ExporterConfig{}, fmt.Errorf("%w: invalid otlp header %q", ErrInvalidExporter, header)
+			}
+			v, err := url.QueryUnescape(strings.TrimSpace(v))
+			if err != nil {
+				return ExporterConfig{}, fmt.Errorf("%w: invalid otlp header %q: %w", ErrInvalidExporter, header, err)
+			}
+			cfg.OTLP.Headers[strings.TrimSpace(k)] = v
+		}
+	}
+	if compression, ok := otlpEnv(OTLPCompressionEnv); ok {
+		cfg.OTLP.Compression = compression
+	}
+	if insecure, ok := otlpEnv(OTLPInsecureEnv); ok {
+		v, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return ExporterConfig{}, fmt.Errorf("%w: invalid otlp insecure %q", ErrInvalidExporter, insecure)
+		}
+		cfg.OTLP.Insecure = v
+	}
+	if certificate, ok := otlpEnv(OTLPCertificateEnv); ok {
+		cfg.OTLP.CACertFile = certificate
+	}
+	if timeout, ok := otlpEnv(OTLPTimeoutEnv); ok {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil || ms < 0 {
+			return ExporterConfig{}, fmt.Errorf("%w: invalid otlp timeout %q", ErrInvalidExporter, timeout)
+		}
+		cfg.OTLP.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	if path, ok := os.LookupEnv(TraceFilePathEnv); ok && path != "" {
+		cfg.FilePath = path
+	}
+	return cfg, nil
+}
+
+// otlpEnv returns the OTEL_EXPORTER_OTLP_TRACES_* variable if set, or the OTEL_EXPORTER_OTLP_* one otherwise.
+func otlpEnv(name string) (string, bool) {
+	v, _, ok := otlpEnvKey(name)
+	return v, ok
+}
+
+// otlpEnvKey is like otlpEnv, also returning the variable which supplied the value.
+func otlpEnvKey(name string) (string, string, bool) {
+	traces := strings.Replace(name, "OTEL_EXPORTER_OTLP_", "OTEL_EXPORTER_OTLP_TRACES_", 1)
+	for _, key := range []string{traces, name} {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			return v, key, true
+		}
+	}
+	return "", "", false
+}
+
+// otlpHTTPTracesURL returns the otlphttp exporter traces URL for the endpoint supplied by the env variable:
+// /v1/traces is appended to the OTEL_EXPORTER_OTLP_ENDPOINT path, while OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is used as is,
+// with the root path when it has none. Endpoints without a scheme, or not set from the environment, are left untouched.
+// https://opentelemetry.io/docs/specs/otel/protocol/exporter/#endpoint-urls-for-otlphttp
+func otlpHTTPTracesURL(endpoint, env string) string {
+	if !strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	switch env {
+	case OTLPEndpointEnv:
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	case OTLPTracesEndpointEnv:
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	default:
+		return endpoint
+	}
+	return u.String()
+}
+
+// withDefaults validates the configuration and fills in the defaults.
+func (cfg OTLPExporterConfig) withDefaults(url string) (OTLPExporterConfig, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultOTLPTimeout
+	}
+	if strings.HasPrefix(url, "http://") {
+		cfg.Insecure = true
+	}
+
+	switch strings.ToLower(cfg.Compression) {
+	case "", NoCompression:
+		cfg.Compression = NoCompression
+	case GzipCompression:
+		cfg.Compression = GzipCompression
+	default:
+		return OTLPExporterConfig{}, fmt.Errorf("%w: %q", ErrInvalidCompression, cfg.Compression)
+	}
+
+	if cfg.Insecure {
+		return cfg, nil
+	}
+	if cfg.TLSConfig == nil {
+		cfg.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		cfg.TLSConfig = cfg.TLSConfig.Clone()
+	}
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return OTLPExporterConfig{}, fmt.Errorf("%w: %w", ErrInvalidCACert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return OTLPExporterConfig{}, fmt.Errorf("%w: no certificates found in %s", ErrInvalidCACert, cfg.CACertFile)
+		}
+		cfg.TLSConfig.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func trimScheme(url string) string {
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		return rest
+	}
+	return url
+}
+
+// NewOTLPHTTPExporter represents the OTLP over HTTP/protobuf distributed tracing span exporter.
+// The url is either an endpoint (i.e: localhost:4318) or a full URL (i.e: https://collector:4318/v1/traces).
+// It creates the exporter right away, use OTLPHTTPSpanExporter to create it lazily instead.
+func NewOTLPHTTPExporter(url string, cfg OTLPExporterConfig) (SpanExporterWithOptions, error) {
+	return withOptions(OTLPHTTPSpanExporter(url, cfg), cfg.Timeout)
+}
+
+// OTLPHTTPSpanExporter represents the OTLP over HTTP/protobuf distributed tracing span exporter.
+// The url is either an endpoint (i.e: localhost:4318) or a full URL (i.e: https://collector:4318/v1/traces),
+// used as is unless it has no path, in which case it defaults to /v1/traces.
+func OTLPHTTPSpanExporter(url string, cfg OTLPExporterConfig) SpanExporter {
+	return otlpHTTPTraceExporter{
+		url: url,
+		cfg: cfg,
+	}
+}
+
+type otlpHTTPTraceExporter struct {
+	url string
+	cfg OTLPExporterConfig
+}
+
+func (e otlpHTTPTraceExporter) Name() string {
+	return OTLPHTTPTraceExporterName
+}
+
+func (e otlpHTTPTraceExporter) Connect(ctx context.Context) (traceSDK.SpanExporter, error) {
+	endpoint := e.url
+	if endpoint == "" {
+		return nil, ErrMissingOTLPURL
+	}
+	cfg, err := e.cfg.withDefaults(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []otlptracehttp.Option
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otlp url: %w", err)
+		}
+		if u.Path == "" {
+			u.Path = "/v1/traces"
+		}
+		options = append(options, otlptracehttp.WithEndpointURL(u.String()))
+	} else {
+		options = append(options, otlptracehttp.WithEndpoint(endpoint))
+	}
+	if cfg.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	} else {
+		options = append(options, otlptracehttp.WithTLSClientConfig(cfg.TLSConfig))
+	}
+	compression := otlptracehttp.NoCompression
+	if cfg.Compression == GzipCompression {
+		compression = otlptracehttp.GzipCompression
+	}
+	options = append(options,
+		otlptracehttp.WithHeaders(cfg.Headers),
+		otlptracehttp.WithCompression(compression),
+		otlptracehttp.WithTimeout(cfg.Timeout),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	logging.GetLogger().Info("using the otlp http span exporter", zap.String("url", endpoint), zap.Bool("insecure", cfg.Insecure))
+	return exporter, nil
+}
+
+// NewStdoutExporter represents the stdout distributed tracing span exporter, which pretty prints the spans
+// in a human readable format, meant for local development. Use w to print the spans somewhere else than stdout.
+func NewStdoutExporter(w ...io.Writer) (SpanExporterWithOptions, error) {
+	var out io.Writer
+	if len(w) > 0 {
+		out = w[0]
+	}
+	return withOptions(StdoutSpanExporter(out), 0)
+}
+
+// StdoutSpanExporter represents the stdout distributed tracing span exporter, which pretty prints the spans
+// in a human readable format to w, meant for local development. (default os.Stdout)
+func StdoutSpanExporter(w io.Writer) SpanExporter {
+	if w == nil {
+		w = os.Stdout
+	}
+	return stdoutTraceExporter{
+		w: w,
+	}
+}
+
+type stdoutTraceExporter struct {
+	w io.Writer
+}
+
+func (e stdoutTraceExporter) Name() string {
+	return StdoutTraceExporterName
+}
+
+func (e stdoutTraceExporter) Connect(context.Context) (traceSDK.SpanExporter, error) {
+	logging.GetLogger().Info("using the stdout span exporter")
+	return &prettyExporter{w: e.w}, nil
+}
+
+// prettyExporter prints every span on a line, followed by its attributes and events, i.e:
+//
+//	15:04:05.000 users-service register_user_endpoint server 12.5ms error "could not register user"
+//	  trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7 parent_span_id=-
+//	  http.method=GET
+//	  event exception exception.message="boom"
+type prettyExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *prettyExporter) ExportSpans(_ context.Context, spans []traceSDK.ReadOnlySpan) error {
+	var b strings.Builder
+	for _, s := range spans {
+		status := strings.ToLower(s.Status().Code.String())
+		if s.Status().Description != "" {
+			status += " " + strconv.Quote(s.Status().Description)
+		}
+		parent := "-"
+		if s.Parent().IsValid() {
+			parent = s.Parent().SpanID().String()
+		}
+		fmt.Fprintf(&b, "%s %s %s %s %s %s\n",
+			s.StartTime().Format("15:04:05.000"), spanServiceName(s), s.Name(), s.SpanKind(),
+			s.EndTime().Sub(s.StartTime()).Round(time.Microsecond), status,
+		)
+		fmt.Fprintf(&b, "  trace_id=%s span_id=%s parent_span_id=%s\n", s.SpanContext().TraceID(), s.SpanContext().SpanID(), parent)
+		for _, a := range s.Attributes() {
+			fmt.Fprintf(&b, "  %s=%s\n", a.Key, prettyValue(a.Value))
+		}
+		for _, event := range s.Events() {
+			fmt.Fprintf(&b, "  event %s", event.Name)
+			for _, a := range event.Attributes {
+				fmt.Fprintf(&b, " %s=%s", a.Key, prettyValue(a.Value))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *prettyExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func prettyValue(v attribute.Value) string {
+	if v.Type() == attribute.STRING {
+		return strconv.Quote(v.AsString())
+	}
+	return v.Emit()
+}
+
+// NewFileExporter represents the file distributed tracing span exporter, which appends the spans to the file
+// at path as JSON lines (JSONL), meant for offline analysis, i.e: jq 'select(.status.code == "Error")' traces.jsonl.
+// It opens the file right away, use FileSpanExporter to open it lazily instead.
+func NewFileExporter(path string) (SpanExporterWithOptions, error) {
+	return withOptions(FileSpanExporter(path), 0)
+}
+
+// FileSpanExporter represents the file distributed tracing span exporter, which appends the spans to the file
+// at path as JSON lines (JSONL), meant for offline analysis, i.e: jq 'select(.status.code == "Error")' traces.jsonl.
+func FileSpanExporter(path string) SpanExporter {
+	return fileTraceExporter{
+		path: path,
+	}
+}
+
+type fileTraceExporter struct {
+	path string
+}
+
+func (e fileTraceExporter) Name() string {
+	return FileTraceExporterName
+}
+
+func (e fileTraceExporter) Connect(context.Context) (traceSDK.SpanExporter, error) {
+	f, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+
+	logging.GetLogger().Info("using the file span exporter", zap.String("path", e.path))
+	return &jsonlExporter{w: f, closer: f}, nil
+}
+
+// JSONSpan represents a span exported by the file exporter, as a JSON line.
+type JSONSpan struct {
+	TraceID      string          `json:"trace_id"`
+	SpanID       string          `json:"span_id"`
+	ParentSpanID string          `json:"parent_span_id,omitempty"`
+	Name         string          `json:"name"`
+	Kind         string          `json:"kind"`
+	Service      string          `json:"service"`
+	Scope        string          `json:"scope"`
+	StartTime    time.Time       `json:"start_time"`
+	EndTime      time.Time       `json:"end_time"`
+	DurationMS   float64         `json:"duration_ms"`
+	Status       JSONSpanStatus  `json:"status"`
+	Attributes   map[string]any  `json:"attributes,omitempty"`
+	Events       []JSONSpanEvent `json:"events,omitempty"`
+	Resource     map[string]any  `json:"resource,omitempty"`
+}
+
+// JSONSpanStatus represents the status of a span exported by the file exporter.
+type JSONSpanStatus struct {
+	Code        string `json:"code"`
+	Description string `json:"description,omitempty"`
+}
+
+// JSONSpanEvent represents an event of a span exported by the file exporter.
+type JSONSpanEvent struct {
+	Name       string         `json:"name"`
+	Time       time.Time      `json:"time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+type jsonlExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (e *jsonlExporter) ExportSpans(_ context.Context, spans []traceSDK.ReadOnlySpan) error {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, s := range spans {
+		js := JSONSpan{
+			TraceID:    s.SpanContext().TraceID().String(),
+			SpanID:     s.SpanContext().SpanID().String(),
+			Name:       s.Name(),
+			Kind:       s.SpanKind().String(),
+			Service:    spanServiceName(s),
+			Scope:      s.InstrumentationScope().Name,
+			StartTime:  s.StartTime(),
+			EndTime:    s.EndTime(),
+			DurationMS: float64(s.EndTime().Sub(s.StartTime())) / float64(time.Millisecond),
+			Status:     JSONSpanStatus{Code: s.Status().Code.String(), Description: s.Status().Description},
+			Attributes: attributesMap(s.Attributes()),
+			Resource:   attributesMap(s.Resource().Attributes()),
+		}
+		if s.Parent().IsValid() {
+			js.ParentSpanID = s.Parent().SpanID().String()
+		}
+		for _, event := range s.Events() {
+			js.Events = append(js.Events, JSONSpanEvent{Name: event.Name, Time: event.Time, Attributes: attributesMap(event.Attributes)})
+		}
+		if err := enc.Encode(js); err != nil {
+			return fmt.Errorf("could not encode span: %w", err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *jsonlExporter) Shutdown(context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+func attributesMap(attributes []attribute.KeyValue) map[string]any {
+	if len(attributes) == 0 {
+		return nil
+	}
+	m := make(map[string]any, len(attributes))
+	for _, a := range attributes {
+		m[string(a.Key)] = a.Value.AsInterface()
+	}
+	return m
+}
+
+func spanServiceName(s traceSDK.ReadOnlySpan) string {
+	if v, ok := s.Resource().Set().Value(semconv.ServiceNameKey); ok {
+		return v.AsString()
+	}
+	return ServiceName
+}
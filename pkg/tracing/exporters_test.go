@@ -0,0 +1,273 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// exportTrace exports a trace with a failed root span and a child span recording an error through the span exporter.
+func exportTrace(t *testing.T, se SpanExporterWithOptions) {
+	t.Helper()
+
+	provider := traceSDK.NewTracerProvider(traceSDK.WithSyncer(se.SpanExporter))
+	ctx, root := provider.Tracer("exporters_test").Start(context.Background(), "register_user_endpoint")
+	root.SetAttributes(attribute.String("http.method", "POST"))
+	root.SetStatus(codes.Error, "could not register user")
+	_, child := provider.Tracer("exporters_test").Start(ctx, "register_user_txn")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	root.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("could not shutdown provider: %v", err)
+	}
+}
+
+func TestExporterConfigFromEnv(t *testing.T) {
+	t.Setenv(ExporterEnv, "otlp")
+	t.Setenv(OTLPProtocolEnv, "http/protobuf")
+	t.Setenv(OTLPEndpointEnv, "https://collector:4318")
+	t.Setenv(OTLPTracesEndpointEnv, "https://traces-collector:4318/v1/traces")
+	t.Setenv(OTLPHeadersEnv, "authorization=Bearer%20token,x-tenant=a")
+	t.Setenv(OTLPCompressionEnv, "gzip")
+	t.Setenv(OTLPInsecureEnv, "false")
+	t.Setenv(OTLPTimeoutEnv, "2500")
+
+	defaults := ExporterConfig{Endpoint: "localhost:4317", OTLP: OTLPExporterConfig{Insecure: true, Headers: map[string]string{"x-tenant": "default"}}}
+	cfg, err := ExporterConfigFromEnv(defaults)
+	if err != nil {
+		t.Fatalf("could not read config: %v", err)
+	}
+	if cfg.Exporter != OTLPHTTPTraceExporterName {
+		t.Errorf("expected the %s exporter, got %s", OTLPHTTPTraceExporterName, cfg.Exporter)
+	}
+	if cfg.Endpoint != "https://traces-collector:4318/v1/traces" || cfg.EndpointEnv != OTLPTracesEndpointEnv {
+		t.Errorf("expected the traces endpoint to take precedence, got %s from %s", cfg.Endpoint, cfg.EndpointEnv)
+	}
+	if cfg.OTLP.Headers["authorization"] != "Bearer token" || cfg.OTLP.Headers["x-tenant"] != "a" {
+		t.Errorf("unexpected headers %v", cfg.OTLP.Headers)
+	}
+	if defaults.OTLP.Headers["x-tenant"] != "default" {
+		t.Errorf("expected the defaults headers to be left untouched, got %v", defaults.OTLP.Headers)
+	}
+	if cfg.OTLP.Compression != GzipCompression || cfg.OTLP.Insecure || cfg.OTLP.Timeout != 2500*time.Millisecond {
+		t.Errorf("unexpected otlp config %+v", cfg.OTLP)
+	}
+
+	t.Setenv(ExporterEnv, "console")
+	if cfg, _ := ExporterConfigFromEnv(ExporterConfig{}); cfg.Exporter != StdoutTraceExporterName {
+		t.Errorf("expected the console exporter to be the %s exporter, got %s", StdoutTraceExporterName, cfg.Exporter)
+	}
+	t.Setenv(ExporterEnv, "otlp")
+	t.Setenv(OTLPProtocolEnv, "http/json")
+	if _, err := ExporterConfigFromEnv(ExporterConfig{}); !errors.Is(err, ErrInvalidExporter) {
+		t.Errorf("expected %v, got %v", ErrInvalidExporter, err)
+	}
+}
+
+func TestNewSpanExporters(t *testing.T) {
+	RegisterExporter("custom", func(cfg ExporterConfig) (SpanExporter, error) {
+		return FileSpanExporter(cfg.FilePath), nil
+	})
+
+	ses, err := NewSpanExporters(ExporterConfig{Exporter: "otlp, custom,none"})
+	if err != nil {
+		t.Fatalf("could not create span exporters: %v", err)
+	}
+	var names []string
+	for _, se := range ses {
+		names = append(names, se.Name())
+	}
+	if got := strings.Join(names, ","); got != "otlp,file" {
+		t.Errorf("expected the otlp and file span exporters, got %s", got)
+	}
+
+	if ses, err := NewSpanExporters(ExporterConfig{Exporter: NoneTraceExporterName}); err != nil || len(ses) != 0 {
+		t.Errorf("expected no span exporters, got %v, %v", ses, err)
+	}
+	if _, err := NewSpanExporters(ExporterConfig{Exporter: "otlp,zipkin"}); !errors.Is(err, ErrInvalidExporter) {
+		t.Errorf("expected %v, got %v", ErrInvalidExporter, err)
+	}
+}
+
+func TestSpanExporterConnectErrors(t *testing.T) {
+	for _, tc := range []struct {
+		exporter SpanExporter
+		err      error
+	}{
+		{exporter: OTLPSpanExporter("", OTLPExporterConfig{}), err: ErrMissingOTLPURL},
+		{exporter: OTLPSpanExporter("localhost:4317", OTLPExporterConfig{Compression: "zstd"}), err: ErrInvalidCompression},
+		{exporter: OTLPHTTPSpanExporter("localhost:4318", OTLPExporterConfig{CACertFile: "missing.pem"}), err: ErrInvalidCACert},
+		{exporter: OTLPSpanExporter("localhost:1", OTLPExporterConfig{Insecure: true, Timeout: 100 * time.Millisecond}), err: context.DeadlineExceeded},
+	} {
+		if _, err := tc.exporter.Connect(context.Background()); !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.exporter.Name(), tc.err, err)
+		}
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer srv.Close()
+
+	se, err := NewOTLPHTTPExporter(srv.URL, OTLPExporterConfig{
+		Headers:     map[string]string{"authorization": "Bearer token"},
+		Compression: GzipCompression,
+	})
+	if err != nil {
+		t.Fatalf("could not create exporter: %v", err)
+	}
+	exportTrace(t, se)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) == 0 {
+		t.Fatal("expected the spans to be exported")
+	}
+	r := requests[0]
+	if r.URL.Path != "/v1/traces" {
+		t.Errorf("expected the /v1/traces path, got %s", r.URL.Path)
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("expected the authorization header, got %q", r.Header.Get("Authorization"))
+	}
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected gzip compression, got %q", r.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestOTLPHTTPExporterEndpointEnv(t *testing.T) {
+	paths := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		env      string
+		endpoint string
+		path     string
+	}{
+		{env: OTLPEndpointEnv, endpoint: srv.URL, path: "/v1/traces"},
+		{env: OTLPEndpointEnv, endpoint: srv.URL + "/otlp/", path: "/otlp/v1/traces"},
+		{env: OTLPTracesEndpointEnv, endpoint: srv.URL + "/otlp", path: "/otlp"},
+		{env: OTLPTracesEndpointEnv, endpoint: srv.URL, path: "/"},
+	} {
+		t.Run(tc.env+"="+tc.endpoint, func(t *testing.T) {
+			t.Setenv(ExporterEnv, "otlp")
+			t.Setenv(OTLPProtocolEnv, HTTPProtobufProtocol)
+			t.Setenv(tc.env, tc.endpoint)
+			cfg, err := ExporterConfigFromEnv(ExporterConfig{})
+			if err != nil {
+				t.Fatalf("could not read config: %v", err)
+			}
+			if cfg.EndpointEnv != tc.env {
+				t.Errorf("expected the endpoint to be set from %s, got %s", tc.env, cfg.EndpointEnv)
+			}
+			ses, err := NewSpanExporters(cfg)
+			if err != nil || len(ses) != 1 {
+				t.Fatalf("could not create span exporters: %v, %v", ses, err)
+			}
+			se, err := withOptions(ses[0], cfg.OTLP.Timeout)
+			if err != nil {
+				t.Fatalf("could not create exporter: %v", err)
+			}
+			exportTrace(t, se)
+			if len(paths) == 0 {
+				t.Fatal("expected the spans to be exported")
+			}
+			for len(paths) > 0 {
+				if got := <-paths; got != tc.path {
+					t.Errorf("expected the %s path, got %s", tc.path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	se, err := NewStdoutExporter(&buf)
+	if err != nil {
+		t.Fatalf("could not create exporter: %v", err)
+	}
+	exportTrace(t, se)
+
+	out := buf.String()
+	for _, s := range []string{
+		" register_user_txn internal ",
+		" register_user_endpoint internal ",
+		`  http.method="POST"`,
+		`  event exception exception.type="*errors.errorString" exception.message="boom"`,
+		"parent_span_id=-",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in:\n%s", s, out)
+		}
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	for i := 0; i < 2; i++ {
+		se, err := NewFileExporter(path)
+		if err != nil {
+			t.Fatalf("could not create exporter: %v", err)
+		}
+		exportTrace(t, se)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open traces file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var spans []JSONSpan
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s JSONSpan
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			t.Fatalf("could not decode span %s: %v", sc.Text(), err)
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 4 {
+		t.Fatalf("expected the spans to be appended to the file, got %d spans", len(spans))
+	}
+	child, root := spans[0], spans[1]
+	if child.Name != "register_user_txn" || root.Name != "register_user_endpoint" {
+		t.Fatalf("unexpected spans %s, %s", child.Name, root.Name)
+	}
+	if child.ParentSpanID != root.SpanID || child.TraceID != root.TraceID {
+		t.Errorf("expected %s to be the parent of %s", root.SpanID, child.ParentSpanID)
+	}
+	if root.Attributes["http.method"] != "POST" || root.Status.Code != "Error" {
+		t.Errorf("unexpected root span %+v", root)
+	}
+	if len(child.Events) != 1 || child.Events[0].Attributes["exception.message"] != "boom" {
+		t.Errorf("expected the exception event, got %+v", child.Events)
+	}
+}
@@ -22,11 +22,20 @@ func HTTPMiddleware(h http.Handler) http.Handler {
 	})
 }
 
+// HTTPTransport injects the trace context into the outgoing requests headers.
+// If Transport is nil, http.DefaultTransport is used.
+//
+// Deprecated: use httpclient.NewTransport, which records the client spans and metrics as well.
 type HTTPTransport struct {
 	Transport http.RoundTripper
 }
 
 func (t *HTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
 	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
-	return t.Transport.RoundTrip(req)
+	return transport.RoundTrip(req)
 }
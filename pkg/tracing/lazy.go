@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+
+	"github.com/go-workshops/ppp/pkg/logging"
+)
+
+// Default span exporter connection retry values.
+const (
+	DefaultExporterMinBackoff = time.Second
+	DefaultExporterMaxBackoff = time.Minute
+)
+
+// ErrExporterNotConnected is returned by the exports while the span exporter is not connected,
+// until the next connection attempt.
+var ErrExporterNotConnected = errors.New("span exporter is not connected")
+
+// ExporterHealth represents the health status of a span exporter.
+type ExporterHealth struct {
+	// Name is the span exporter name, i.e: otlp.
+	Name string `json:"name"`
+
+	// Connected reports whether the span exporter is connected.
+	Connected bool `json:"connected"`
+
+	// LastExport is the time of the last successful export, if any.
+	LastExport time.Time `json:"last_export,omitempty"`
+
+	// LastError is the last connection or export error, if the last attempt failed.
+	LastError string `json:"last_error,omitempty"`
+
+	// Failures is the number of consecutive failed connection or export attempts.
+	Failures int `json:"failures"`
+}
+
+// lazyExporter connects the span exporter on the first export, retrying with an exponential backoff,
+// while keeping track of its health status. The spans exported while it is not connected are dropped.
+type lazyExporter struct {
+	exporter   SpanExporter
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+
+	connMu      sync.Mutex
+	mu          sync.Mutex
+	conn        traceSDK.SpanExporter
+	backoff     time.Duration
+	nextAttempt time.Time
+	health      ExporterHealth
+}
+
+func newLazyExporter(exporter SpanExporter) *lazyExporter {
+	return &lazyExporter{
+		exporter:   exporter,
+		minBackoff: DefaultExporterMinBackoff,
+		maxBackoff: DefaultExporterMaxBackoff,
+		now:        time.Now,
+		health:     ExporterHealth{Name: exporter.Name()},
+	}
+}
+
+func (e *lazyExporter) ExportSpans(ctx context.Context, spans []traceSDK.ReadOnlySpan) error {
+	conn, err := e.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = conn.ExportSpans(ctx, spans)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.health.LastError = err.Error()
+		e.health.Failures++
+		return err
+	}
+	e.health.LastExport = e.now()
+	e.health.LastError = ""
+	e.health.Failures = 0
+	return nil
+}
+
+// connect returns the connected span exporter, connecting it if the backoff since the last attempt expired.
+// The connection attempts are serialized by connMu, so the health status is available while connecting.
+func (e *lazyExporter) connect(ctx context.Context) (traceSDK.SpanExporter, error) {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+
+	e.mu.Lock()
+	conn, now, health := e.conn, e.now(), e.health
+	nextAttempt := e.nextAttempt
+	e.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	if now.Before(nextAttempt) {
+		return nil, fmt.Errorf("%w: %s: retrying in %s: %s", ErrExporterNotConnected, health.Name, nextAttempt.Sub(now).Round(time.Millisecond), health.LastError)
+	}
+
+	conn, err := e.exporter.Connect(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.backoff = min(max(2*e.backoff, e.minBackoff), e.maxBackoff)
+		e.nextAttempt = now.Add(e.backoff)
+		e.health.LastError = err.Error()
+		e.health.Failures++
+		logging.GetLogger().Warn(
+			"could not connect span exporter",
+			zap.String("exporter", e.health.Name),
+			zap.Duration("retry_in", e.backoff),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("%w: %s: %w", ErrExporterNotConnected, e.health.Name, err)
+	}
+
+	e.conn = conn
+	e.backoff = 0
+	e.health.Connected = true
+	e.health.LastError = ""
+	e.health.Failures = 0
+	return conn, nil
+}
+
+// Shutdown shuts down the connected span exporter. The health lock is released while shutting down,
+// which may take until ctx is done, so the health status is still available meanwhile.
+func (e *lazyExporter) Shutdown(ctx context.Context) error {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+
+	e.mu.Lock()
+	conn := e.conn
+	e.conn = nil
+	e.health.Connected = false
+	e.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Shutdown(ctx)
+}
+
+func (e *lazyExporter) Health() ExporterHealth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.health
+}
+
+// spanProcessors fans out the spans to every span processor, i.e: a batch span processor per span exporter,
+// so a slow or unavailable span exporter does not hold back the others.
+type spanProcessors []traceSDK.SpanProcessor
+
+func (ps spanProcessors) OnStart(ctx context.Context, s traceSDK.ReadWriteSpan) {
+	for _, p := range ps {
+		p.OnStart(ctx, s)
+	}
+}
+
+func (ps spanProcessors) OnEnd(s traceSDK.ReadOnlySpan) {
+	for _, p := range ps {
+		p.OnEnd(s)
+	}
+}
+
+func (ps spanProcessors) ForceFlush(ctx context.Context) error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p.ForceFlush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (ps spanProcessors) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Health returns the health status of every span exporter configured with TracerProviderConfig.Exporters.
+func (p *Provider) Health() []ExporterHealth {
+	health := make([]ExporterHealth, 0, len(p.exporters))
+	for _, e := range p.exporters {
+		health = append(health, e.Health())
+	}
+	return health
+}
+
+// HealthCheck returns an error if any span exporter failed its last connection or export attempt,
+// i.e: to be used as an admin server readiness check.
+func (p *Provider) HealthCheck(context.Context) error {
+	var errs []error
+	for _, h := range p.Health() {
+		if h.Failures > 0 {
+			errs = append(errs, fmt.Errorf("%w: %s: %s", ErrExporterNotConnected, h.Name, h.LastError))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HealthHandler returns the http.Handler that serves the span exporters health status as JSON.
+// It responds with 503 Service Unavailable if any span exporter failed its last connection or export attempt.
+func (p *Provider) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		health := p.Health()
+		status := http.StatusOK
+		for _, h := range health {
+			if h.Failures > 0 {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(health)
+	})
+}
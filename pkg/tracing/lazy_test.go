@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// flakyExporter fails to connect until up is set.
+type flakyExporter struct {
+	name     string
+	up       bool
+	attempts int
+	exporter *tracetest.InMemoryExporter
+}
+
+func (e *flakyExporter) Name() string {
+	return e.name
+}
+
+func (e *flakyExporter) Connect(context.Context) (traceSDK.SpanExporter, error) {
+	e.attempts++
+	if !e.up {
+		return nil, errors.New("connection refused")
+	}
+	return e.exporter, nil
+}
+
+func TestLazyExporterBackoff(t *testing.T) {
+	flaky := &flakyExporter{name: "flaky", exporter: tracetest.NewInMemoryExporter()}
+	e := newLazyExporter(flaky)
+	now := time.Unix(1_700_000_000, 0)
+	e.now = func() time.Time { return now }
+	spans := tracetest.SpanStubs{{Name: "span"}}.Snapshots()
+
+	export := func() error {
+		return e.ExportSpans(context.Background(), spans)
+	}
+	if err := export(); !errors.Is(err, ErrExporterNotConnected) {
+		t.Fatalf("expected %v, got %v", ErrExporterNotConnected, err)
+	}
+	// No connection attempt until the backoff expires, which doubles on every failed attempt.
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		attempts := flaky.attempts
+		now = now.Add(backoff / 2)
+		if err := export(); !errors.Is(err, ErrExporterNotConnected) || flaky.attempts != attempts {
+			t.Fatalf("expected no connection attempt within the %s backoff, got %d attempts, %v", backoff, flaky.attempts-attempts, err)
+		}
+		now = now.Add(backoff / 2)
+		if err := export(); !errors.Is(err, ErrExporterNotConnected) || flaky.attempts != attempts+1 {
+			t.Fatalf("expected a connection attempt after the %s backoff, got %d attempts, %v", backoff, flaky.attempts-attempts, err)
+		}
+	}
+	if h := e.Health(); h.Connected || h.Failures != 4 || h.LastError != "connection refused" {
+		t.Errorf("unexpected health %+v", h)
+	}
+
+	flaky.up = true
+	now = now.Add(8 * time.Second)
+	if err := export(); err != nil {
+		t.Fatalf("expected the export to succeed, got %v", err)
+	}
+	if got := len(flaky.exporter.GetSpans()); got != 1 {
+		t.Errorf("expected 1 exported span, got %d", got)
+	}
+	if h := e.Health(); !h.Connected || h.Failures != 0 || h.LastError != "" || !h.LastExport.Equal(now) {
+		t.Errorf("unexpected health %+v", h)
+	}
+}
+
+// blockingExporter blocks on shutdown until released.
+type blockingExporter struct {
+	*tracetest.InMemoryExporter
+	shutdown chan struct{}
+	release  chan struct{}
+}
+
+func (e *blockingExporter) Shutdown(context.Context) error {
+	close(e.shutdown)
+	<-e.release
+	return nil
+}
+
+func TestLazyExporterHealthWhileShuttingDown(t *testing.T) {
+	conn := &blockingExporter{InMemoryExporter: tracetest.NewInMemoryExporter(), shutdown: make(chan struct{}), release: make(chan struct{})}
+	e := newLazyExporter(&flakyExporter{name: "blocking", up: true})
+	e.conn = conn
+	e.health.Connected = true
+
+	errs := make(chan error, 1)
+	go func() { errs <- e.Shutdown(context.Background()) }()
+	<-conn.shutdown
+	if h := e.Health(); h.Connected {
+		t.Errorf("expected the exporter to be disconnected while shutting down, got %+v", h)
+	}
+	close(conn.release)
+	if err := <-errs; err != nil {
+		t.Errorf("expected the shutdown to succeed, got %v", err)
+	}
+}
+
+func TestTracerProviderExporters(t *testing.T) {
+	up := &flakyExporter{name: "up", up: true, exporter: tracetest.NewInMemoryExporter()}
+	down := &flakyExporter{name: "down", exporter: tracetest.NewInMemoryExporter()}
+	provider, err := NewTracerProvider(TracerProviderConfig{
+		TracingEnabled: true,
+		ServiceName:    "test",
+		Exporters:      []SpanExporter{up, down},
+		Sampler:        traceSDK.AlwaysSample(),
+		BatchTimeout:   time.Hour,
+		ExportTimeout:  time.Second,
+		MaxBatchSize:   512,
+		MaxQueueSize:   2048,
+	})
+	if err != nil {
+		t.Fatalf("could not create provider: %v", err)
+	}
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	_, span := provider.Tracer("lazy_test").Start(context.Background(), "register_user_endpoint")
+	span.End()
+	_ = provider.ForceFlush(context.Background())
+
+	// The spans are fanned out to every exporter, even if one of them is down.
+	if got := len(up.exporter.GetSpans()); got != 1 {
+		t.Errorf("expected 1 exported span, got %d", got)
+	}
+	health := provider.Health()
+	if len(health) != 2 || !health[0].Connected || health[1].Connected || health[1].Failures != 1 {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	w := httptest.NewRecorder()
+	provider.HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/tracing", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	var got []ExporterHealth
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || len(got) != 2 || got[1].Name != "down" {
+		t.Errorf("unexpected health response %v, %v", got, err)
+	}
+	if err := provider.HealthCheck(context.Background()); !errors.Is(err, ErrExporterNotConnected) || !strings.Contains(err.Error(), "down") {
+		t.Errorf("expected the down exporter health check error, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageHeader represents a message header with a binary value, i.e: a Kafka record header.
+type MessageHeader struct {
+	Key   string
+	Value []byte
+}
+
+// HeadersCarrier adapts a list of message headers to propagation.TextMapCarrier,
+// so the trace context is propagated through the message headers, i.e: Kafka record headers.
+// Use propagation.MapCarrier for the map[string]string message metadata.
+type HeadersCarrier []MessageHeader
+
+// Get returns the value of the header, the key being case insensitive.
+func (c *HeadersCarrier) Get(key string) string {
+	for _, h := range *c {
+		if strings.EqualFold(h.Key, key) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set sets the header, replacing the existing one with the same key, if any.
+func (c *HeadersCarrier) Set(key, value string) {
+	for i, h := range *c {
+		if strings.EqualFold(h.Key, key) {
+			(*c)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c = append(*c, MessageHeader{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the header keys.
+func (c *HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c))
+	for _, h := range *c {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// Envelope represents a JSON message envelope, carrying the trace context headers along with the payload, i.e:
+//
+//	{"headers":{"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},"payload":{"user_id":"1"}}
+//
+// Use it for the messaging systems without message metadata.
+type Envelope struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload"`
+}
+
+// NewEnvelope creates a new JSON message envelope, marshalling the payload.
+// Pass it as the StartProducer carrier to inject the trace context, then marshal it as the message.
+func NewEnvelope(payload any) (*Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Payload: data}, nil
+}
+
+// Get returns the value of the envelope header.
+func (e *Envelope) Get(key string) string {
+	return e.Headers[key]
+}
+
+// Set sets the envelope header.
+func (e *Envelope) Set(key, value string) {
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	e.Headers[key] = value
+}
+
+// Keys returns the envelope header keys.
+func (e *Envelope) Keys() []string {
+	return propagation.MapCarrier(e.Headers).Keys()
+}
+
+// Unmarshal unmarshals the envelope payload into v.
+func (e *Envelope) Unmarshal(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// StartProducer creates a new producer Open Telemetry tracing Span, following the messaging semantic conventions,
+// and injects its trace context into the message carrier, using the global propagator.
+// The system is the messaging system, i.e: kafka, and the destination is the topic or queue name, i.e: notifications.
+// Use this every time you want to trace a message publishing operation.
+func StartProducer(ctx context.Context, system, destination string, carrier propagation.TextMapCarrier, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	spanName := messagingSpanName(destination, semconv.MessagingOperationTypePublish)
+	opts = append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(system, destination, semconv.MessagingOperationTypePublish)...),
+	}, opts...)
+
+	ctx, span := otel.Tracer(spanName).Start(ctx, spanName, opts...)
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	return ctx, span
+}
+
+// StartConsumer extracts the trace context from the message carrier, using the global propagator,
+// and creates a new consumer Open Telemetry tracing Span as a child of the producer span.
+// Use this every time you want to trace the processing of a single message.
+func StartConsumer(ctx context.Context, system, destination string, carrier propagation.TextMapCarrier, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	spanName := messagingSpanName(destination, semconv.MessagingOperationTypeDeliver)
+	opts = append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(system, destination, semconv.MessagingOperationTypeDeliver)...),
+	}, opts...)
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	return otel.Tracer(spanName).Start(ctx, spanName, opts...)
+}
+
+// StartBatchConsumer creates a new consumer Open Telemetry tracing Span for the processing of a batch of messages.
+// Since the messages belong to different traces, the span is not a child of their producer spans,
+// but links to them instead, using the trace context extracted from every message carrier.
+// Use this every time you want to trace the processing of a batch of messages.
+func StartBatchConsumer(ctx context.Context, system, destination string, carriers []propagation.TextMapCarrier, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	spanName := messagingSpanName(destination, semconv.MessagingOperationTypeDeliver)
+	links := make([]trace.Link, 0, len(carriers))
+	for _, carrier := range carriers {
+		sc := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	attributes := append(
+		messagingAttributes(system, destination, semconv.MessagingOperationTypeDeliver),
+		semconv.MessagingBatchMessageCount(len(carriers)),
+	)
+	opts = append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...),
+		trace.WithLinks(links...),
+	}, opts...)
+
+	return otel.Tracer(spanName).Start(ctx, spanName, opts...)
+}
+
+func messagingAttributes(system, destination string, operation attribute.KeyValue) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(system),
+		semconv.MessagingDestinationName(destination),
+		operation,
+		semconv.MessagingOperationName(operation.Value.AsString()),
+		operationNameAttribute(messagingSpanName(destination, operation)),
+	}
+}
+
+// messagingSpanName returns the {messaging.operation.name} {destination} span name, i.e: publish notifications.
+func messagingSpanName(destination string, operation attribute.KeyValue) string {
+	return operation.Value.AsString() + " " + destination
+}
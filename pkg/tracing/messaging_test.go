@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/tracing/tracingtest"
+)
+
+type notification struct {
+	UserID string `json:"user_id"`
+}
+
+func TestMessagingCarriers(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+	otel.SetTextMapPropagator(NewTextMapPropagator(context.Background()))
+
+	headers := HeadersCarrier{{Key: "content-type", Value: []byte("application/json")}}
+	envelope, err := NewEnvelope(notification{UserID: "1"})
+	if err != nil {
+		t.Fatalf("could not create envelope: %v", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		carrier func() (propagation.TextMapCarrier, propagation.TextMapCarrier)
+	}{
+		{
+			name: "map",
+			carrier: func() (propagation.TextMapCarrier, propagation.TextMapCarrier) {
+				m := propagation.MapCarrier{}
+				return m, m
+			},
+		},
+		{
+			name: "headers",
+			carrier: func() (propagation.TextMapCarrier, propagation.TextMapCarrier) {
+				return &headers, &headers
+			},
+		},
+		{
+			name: "envelope",
+			carrier: func() (propagation.TextMapCarrier, propagation.TextMapCarrier) {
+				return envelope, envelope
+			},
+		},
+	} {
+		producerCarrier, consumerCarrier := tc.carrier()
+		ctx, producer := StartProducer(context.Background(), "kafka", "notifications", producerCarrier)
+		producer.End()
+
+		// The legacy custom headers are injected too.
+		if producerCarrier.Get(TraceIDHeader) != producer.SpanContext().TraceID().String() {
+			t.Errorf("%s: expected the %s header, got %v", tc.name, TraceIDHeader, producerCarrier.Keys())
+		}
+		_, consumer := StartConsumer(context.Background(), "kafka", "notifications", consumerCarrier)
+		consumer.End()
+		if consumer.SpanContext().TraceID() != trace.SpanContextFromContext(ctx).TraceID() {
+			t.Errorf("%s: expected the consumer span to be part of the producer trace", tc.name)
+		}
+	}
+
+	if got := headers.Get("Content-Type"); got != "application/json" || len(headers) != 5 {
+		t.Errorf("expected the trace context headers to be added, got %v", headers.Keys())
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("could not marshal envelope: %v", err)
+	}
+	var got Envelope
+	var n notification
+	if err := json.Unmarshal(data, &got); err != nil || got.Unmarshal(&n) != nil || n.UserID != "1" || got.Get("traceparent") == "" {
+		t.Errorf("unexpected envelope %s", data)
+	}
+
+	recorder.AssertTree(t, tracingtest.Span{
+		Name: "publish notifications",
+		Kind: trace.SpanKindProducer,
+		Attributes: map[string]any{
+			"messaging.system":           "kafka",
+			"messaging.destination.name": "notifications",
+			"messaging.operation.type":   "publish",
+			"messaging.operation.name":   "publish",
+		},
+		Children: []tracingtest.Span{
+			{
+				Name:       "process notifications",
+				Kind:       trace.SpanKindConsumer,
+				Attributes: map[string]any{"messaging.operation.type": "process", "messaging.operation.name": "process"},
+			},
+		},
+	})
+}
+
+func TestStartBatchConsumer(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+
+	var carriers []propagation.TextMapCarrier
+	var producers []trace.Span
+	for i := 0; i < 2; i++ {
+		carrier := propagation.MapCarrier{}
+		_, producer := StartProducer(context.Background(), "kafka", "notifications", carrier)
+		producer.End()
+		carriers = append(carriers, carrier)
+		producers = append(producers, producer)
+	}
+	carriers = append(carriers, propagation.MapCarrier{})
+
+	_, consumer := StartBatchConsumer(context.Background(), "kafka", "notifications", carriers)
+	consumer.End()
+
+	s := recorder.WaitForSpan(t, "process notifications")
+	if s.Parent().IsValid() {
+		t.Errorf("expected the batch consumer span to be a root span, got parent %v", s.Parent())
+	}
+	links := s.Links()
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %d", len(links))
+	}
+	for i, l := range links {
+		if !l.SpanContext.Equal(producers[i].SpanContext().WithRemote(true)) {
+			t.Errorf("expected a link to %v, got %v", producers[i].SpanContext(), l.SpanContext)
+		}
+	}
+	recorder.AssertTree(t, tracingtest.Span{
+		Name:       "process notifications",
+		Attributes: map[string]any{"messaging.batch.message_count": 3},
+	})
+}
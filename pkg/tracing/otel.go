@@ -2,13 +2,15 @@ package tracing
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	traceSDK "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
@@ -16,9 +18,11 @@ import (
 	"go.opentelemetry.io/otel/trace/embedded"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
 
-	sharedContext "github.com/go-workshops/ppp/pkg/context"
 	"github.com/go-workshops/ppp/pkg/logging"
 )
 
@@ -26,7 +30,17 @@ import (
 const (
 	TraceExporterAttribute = "exporter"
 
-	OTLPTraceExporterName = "otlp"
+	OTLPTraceExporterName     = "otlp"
+	OTLPHTTPTraceExporterName = "otlphttp"
+	StdoutTraceExporterName   = "stdout"
+	FileTraceExporterName     = "file"
+	NoneTraceExporterName     = "none"
+)
+
+// Supported OTLP exporters compressions.
+const (
+	GzipCompression = "gzip"
+	NoCompression   = "none"
 )
 
 const (
@@ -41,6 +55,9 @@ var ServiceName string
 var (
 	ErrMissingOTLPURL     = fmt.Errorf("otlp url is required")
 	ErrMissingServiceName = fmt.Errorf("service name is required")
+	ErrInvalidExporter    = fmt.Errorf("invalid span exporter")
+	ErrInvalidCompression = fmt.Errorf("invalid compression")
+	ErrInvalidCACert      = fmt.Errorf("invalid ca certificate")
 )
 
 // TracerProviderConfig represents the distributed tracer provider configuration
@@ -52,6 +69,23 @@ type TracerProviderConfig struct {
 	ExportTimeout  time.Duration
 	MaxBatchSize   int
 	MaxQueueSize   int
+
+	// Exporters are the span exporters the spans are fanned out to, each one with its own batch span processor,
+	// i.e: []SpanExporter{OTLPSpanExporter(url, cfg), FileSpanExporter(path)}. They connect on their first export,
+	// retrying with an exponential backoff, see Provider.Health. They are used along with SpanExporter, if both are set.
+	Exporters []SpanExporter
+
+	// Sampler decides which traces are sampled, i.e: NewSampler(ParentBasedTraceIDRatioSampler, "0.25").
+	// (default SamplerFromEnv())
+	Sampler traceSDK.Sampler
+
+	// TailSampling enables tail sampling, keeping the traces with errors or slow roots and a ratio of the others.
+	// Since tail sampling only sees the traces sampled by Sampler, use it with the AlwaysOnSampler.
+	TailSampling *TailSamplingOpts
+
+	// SpanMetrics enables the span metrics, derived from every span before sampling, see SpanMetricsProcessor.
+	// The Sampler is wrapped with NewRecordingSampler, so the spans it drops are recorded too.
+	SpanMetrics *SpanMetricsOpts
 }
 
 // SpanExporterWithOptions represents a wrapper around a span exporter with additional resource options per exporter.
@@ -60,70 +94,158 @@ type SpanExporterWithOptions struct {
 	ResourceOptions []resource.Option
 }
 
-// SpanExporter represents a span exporter for Open Telemetry.
+// SpanExporter represents a named span exporter for Open Telemetry, which connects lazily,
+// i.e: on the first export. Use RegisterExporter to make a custom span exporter available by name.
 type SpanExporter interface {
-	name() string
-	spanExporter() (SpanExporterWithOptions, error)
+	// Name returns the span exporter name, i.e: otlp.
+	Name() string
+
+	// Connect creates the underlying span exporter, connecting to its backend if any.
+	// It is retried with an exponential backoff until it succeeds.
+	Connect(ctx context.Context) (traceSDK.SpanExporter, error)
+}
+
+// withOptions connects the span exporter right away, for the constructors returning a SpanExporterWithOptions.
+func withOptions(e SpanExporter, timeout time.Duration) (SpanExporterWithOptions, error) {
+	if timeout <= 0 {
+		timeout = DefaultOTLPTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	exporter, err := e.Connect(ctx)
+	if err != nil {
+		return SpanExporterWithOptions{}, err
+	}
+	se := SpanExporterWithOptions{
+		SpanExporter: exporter,
+		ResourceOptions: []resource.Option{
+			resource.WithAttributes(attribute.String(TraceExporterAttribute, e.Name())),
+		},
+	}
+	return se, nil
+}
+
+// OTLPExporterConfig represents the OTLP span exporters configuration options.
+type OTLPExporterConfig struct {
+	// Timeout is the connection and export timeout. (default 5s)
+	Timeout time.Duration
+
+	// Insecure disables TLS. It is implied by an http:// endpoint, i.e: http://localhost:4318.
+	Insecure bool
+
+	// TLSConfig is the TLS configuration. (default the system root certificates)
+	TLSConfig *tls.Config
+
+	// CACertFile is the PEM encoded certificate authority file used to verify the collector certificate,
+	// instead of the system root certificates.
+	CACertFile string
+
+	// Headers are sent along with every export, i.e: the collector authentication token.
+	Headers map[string]string
+
+	// Compression is the export compression: gzip or none. (default none)
+	Compression string
 }
 
 // NewOTLPExporter represents the OTLP distributed tracing span exporter.
 func NewOTLPExporter(url string, timeout ...time.Duration) (SpanExporterWithOptions, error) {
-	t := 5 * time.Second
+	cfg := OTLPExporterConfig{Insecure: true}
 	if len(timeout) > 0 {
-		t = timeout[0]
+		cfg.Timeout = timeout[0]
 	}
+	return NewOTLPExporterWithConfig(url, cfg)
+}
 
-	exporter := otlpTraceExporter{
-		url:     url,
-		timeout: t,
-	}
+// NewOTLPExporterWithConfig represents the OTLP over gRPC distributed tracing span exporter, configured with cfg.
+// It connects to the collector right away, use OTLPSpanExporter to connect lazily instead.
+func NewOTLPExporterWithConfig(url string, cfg OTLPExporterConfig) (SpanExporterWithOptions, error) {
+	return withOptions(OTLPSpanExporter(url, cfg), cfg.Timeout)
+}
 
-	return exporter.spanExporter()
+// OTLPSpanExporter represents the OTLP over gRPC distributed tracing span exporter, configured with cfg.
+func OTLPSpanExporter(url string, cfg OTLPExporterConfig) SpanExporter {
+	return otlpTraceExporter{
+		url: url,
+		cfg: cfg,
+	}
 }
 
 type otlpTraceExporter struct {
-	url     string
-	timeout time.Duration
+	url string
+	cfg OTLPExporterConfig
 }
 
-func (e otlpTraceExporter) name() string {
+func (e otlpTraceExporter) Name() string {
 	return OTLPTraceExporterName
 }
 
-func (e otlpTraceExporter) spanExporter() (SpanExporterWithOptions, error) {
+func (e otlpTraceExporter) Connect(ctx context.Context) (traceSDK.SpanExporter, error) {
 	url := e.url
 	if url == "" {
-		return SpanExporterWithOptions{}, ErrMissingOTLPURL
+		return nil, ErrMissingOTLPURL
+	}
+	cfg, err := e.cfg.withDefaults(url)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
 	defer cancel()
 
-	conn, err := grpc.NewClient(e.url, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds := insecure.NewCredentials()
+	if !cfg.Insecure {
+		creds = credentials.NewTLS(cfg.TLSConfig)
+	}
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if cfg.Compression == GzipCompression {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	conn, err := grpc.NewClient(trimScheme(url), dialOptions...)
 	if err != nil {
-		return SpanExporterWithOptions{}, fmt.Errorf("failed to create gRPC connection to collector: %w", err)
+		return nil, fmt.Errorf("failed to create gRPC connection to collector: %w", err)
 	}
 
-	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
-	if err != nil {
-		return SpanExporterWithOptions{}, fmt.Errorf("failed to create trace exporter: %w", err)
+	// grpc.NewClient does not connect, so wait for the connection to be ready, otherwise
+	// a collector which is down would only be noticed when exporting.
+	conn.Connect()
+	for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to connect to collector %s: %w", url, ctx.Err())
+		}
 	}
 
-	se := SpanExporterWithOptions{
-		SpanExporter: exporter,
-		ResourceOptions: []resource.Option{
-			resource.WithAttributes(attribute.String(TraceExporterAttribute, e.name())),
-		},
+	exporter, err := otlptracegrpc.New(
+		ctx,
+		otlptracegrpc.WithGRPCConn(conn),
+		otlptracegrpc.WithHeaders(cfg.Headers),
+		otlptracegrpc.WithTimeout(cfg.Timeout),
+	)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
 
-	logging.GetLogger().Info("using the otlp span exporter", zap.String("url", url))
-	return se, nil
+	logging.GetLogger().Info("using the otlp span exporter", zap.String("url", url), zap.Bool("insecure", cfg.Insecure))
+	return grpcSpanExporter{SpanExporter: exporter, conn: conn}, nil
+}
+
+// grpcSpanExporter closes the gRPC connection on shutdown, which the otlptracegrpc exporter
+// does not do for the connections passed using WithGRPCConn.
+type grpcSpanExporter struct {
+	traceSDK.SpanExporter
+	conn *grpc.ClientConn
+}
+
+func (e grpcSpanExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.conn.Close())
 }
 
 // NewTracerProvider creates a new distributed tracing provider.
 // If TracingEnabled is false, it will create a no-op provider.
 func NewTracerProvider(cfg TracerProviderConfig) (*Provider, error) {
-	if !cfg.TracingEnabled || cfg.SpanExporter.SpanExporter == nil {
+	if !cfg.TracingEnabled || (cfg.SpanExporter.SpanExporter == nil && len(cfg.Exporters) == 0) {
 		provider := &Provider{
 			TracerProvider: noProvider{},
 		}
@@ -146,7 +268,9 @@ func NewTracerProvider(cfg TracerProviderConfig) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
-	spanExporterResource, err := resource.New(ctx, cfg.SpanExporter.ResourceOptions...)
+
+	spanExporters, exporters, resourceOptions := cfg.spanExporters()
+	spanExporterResource, err := resource.New(ctx, resourceOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -169,14 +293,39 @@ func NewTracerProvider(cfg TracerProviderConfig) (*Provider, error) {
 		}
 	}
 
-	tracerProvider := traceSDK.NewTracerProvider(
-		traceSDK.WithBatcher(
-			cfg.SpanExporter.SpanExporter,
+	sampler := cfg.Sampler
+	if sampler == nil {
+		sampler, err = SamplerFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	processors := make(spanProcessors, 0, len(spanExporters))
+	for _, se := range spanExporters {
+		processors = append(processors, traceSDK.NewBatchSpanProcessor(
+			se,
 			traceSDK.WithBatchTimeout(cfg.BatchTimeout),
 			traceSDK.WithExportTimeout(cfg.ExportTimeout),
 			traceSDK.WithMaxExportBatchSize(cfg.MaxBatchSize),
 			traceSDK.WithMaxQueueSize(cfg.MaxQueueSize),
-		),
+		))
+	}
+	var processor traceSDK.SpanProcessor = processors
+	if len(processors) == 1 {
+		processor = processors[0]
+	}
+	if cfg.TailSampling != nil {
+		processor = NewTailSamplingProcessor(processor, *cfg.TailSampling)
+	}
+	if cfg.SpanMetrics != nil {
+		sampler = NewRecordingSampler(sampler)
+		processor = spanProcessors{NewSpanMetricsProcessor(*cfg.SpanMetrics), processor}
+	}
+
+	tracerProvider := traceSDK.NewTracerProvider(
+		traceSDK.WithSampler(sampler),
+		traceSDK.WithSpanProcessor(processor),
 
 		// This MUST be a COMPOSED resource, otherwise only the LAST CALL to WithResource() will be considered,
 		// even if this is a variadic function :P.
@@ -184,16 +333,40 @@ func NewTracerProvider(cfg TracerProviderConfig) (*Provider, error) {
 	)
 	provider := &Provider{
 		TracerProvider: tracerProvider,
+		exporters:      exporters,
 	}
 
 	return provider, nil
 }
 
+// spanExporters returns the span exporters, wrapping the Exporters to connect lazily, along with their resource options.
+func (cfg TracerProviderConfig) spanExporters() ([]traceSDK.SpanExporter, []*lazyExporter, []resource.Option) {
+	var spanExporters []traceSDK.SpanExporter
+	var exporters []*lazyExporter
+	resourceOptions := cfg.SpanExporter.ResourceOptions
+	if cfg.SpanExporter.SpanExporter != nil {
+		spanExporters = append(spanExporters, cfg.SpanExporter.SpanExporter)
+	}
+
+	var names []string
+	for _, e := range cfg.Exporters {
+		exporter := newLazyExporter(e)
+		spanExporters = append(spanExporters, exporter)
+		exporters = append(exporters, exporter)
+		names = append(names, e.Name())
+	}
+	if len(names) > 0 {
+		resourceOptions = append(resourceOptions, resource.WithAttributes(attribute.String(TraceExporterAttribute, strings.Join(names, ","))))
+	}
+	return spanExporters, exporters, resourceOptions
+}
+
 // Provider represents a wrapper around traceSDK.TracerProvider
 // which has more methods such as Shutdown. Unfortunately the
 // trace.TracerProvider does not have a Shutdown method.
 type Provider struct {
 	TracerProvider
+	exporters []*lazyExporter
 }
 
 // ForceFlush is a wrapper around traceSDK.TracerProvider.ForceFlush
@@ -276,49 +449,3 @@ func (noSpan) SetAttributes(...attribute.KeyValue) {
 func (noSpan) TracerProvider() trace.TracerProvider {
 	return noProvider{}
 }
-
-// NewTextMapPropagator represents a custom HTTP Inject/Extract propagator, used
-// to inject/extract the trace-id and span-id into/from the HTTP Headers.
-func NewTextMapPropagator(ctx context.Context) propagation.TextMapPropagator {
-	logger := sharedContext.Logger(ctx).With(zap.String("source", "open_telemetry"))
-	return propagator{logger: logger}
-}
-
-type propagator struct {
-	logger *zap.Logger
-	propagation.TraceContext
-}
-
-func (p propagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
-	sc := trace.SpanContextFromContext(ctx)
-	if !sc.IsValid() {
-		return
-	}
-
-	// Pick one :P
-	// Custom headers
-	carrier.Set(TraceIDHeader, sc.TraceID().String())
-	carrier.Set(SpanIDHeader, sc.SpanID().String())
-	// Default headers
-	p.TraceContext.Inject(ctx, carrier)
-}
-
-func (p propagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
-	// Pick one :P
-	// Custom headers
-	traceIDHeader := carrier.Get(TraceIDHeader)
-	spanIDHeader := carrier.Get(SpanIDHeader)
-	// Default headers
-	traceContextCtx := p.TraceContext.Extract(ctx, carrier)
-	return sharedContext.WithSpanContext(traceContextCtx, traceIDHeader, spanIDHeader)
-}
-
-func (p propagator) Fields() []string {
-	return append(
-		// Pick one :P
-		// Default headers
-		p.TraceContext.Fields(),
-		// Custom headers
-		TraceIDHeader, SpanIDHeader,
-	)
-}
@@ -0,0 +1,200 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	sharedContext "github.com/go-workshops/ppp/pkg/context"
+)
+
+// PropagatorsEnv is the comma separated list of propagators, in precedence order,
+// following the OTEL_PROPAGATORS semantics, i.e: tracecontext,baggage,b3multi.
+// https://opentelemetry.io/docs/specs/otel/configuration/sdk-environment-variables/#general-sdk-configuration
+const PropagatorsEnv = "OTEL_PROPAGATORS"
+
+// Supported propagators.
+const (
+	// TraceContextPropagator propagates the W3C trace context, i.e: traceparent: 00-{trace-id}-{span-id}-01.
+	TraceContextPropagator = "tracecontext"
+
+	// BaggagePropagator propagates the W3C baggage, i.e: baggage: user_id=1.
+	BaggagePropagator = "baggage"
+
+	// B3Propagator propagates the B3 single header, i.e: b3: {trace-id}-{span-id}-1.
+	B3Propagator = "b3"
+
+	// B3MultiPropagator propagates the B3 multiple headers, i.e: x-b3-traceid, x-b3-spanid and x-b3-sampled.
+	B3MultiPropagator = "b3multi"
+
+	// JaegerPropagator propagates the Jaeger header, i.e: uber-trace-id: {trace-id}:{span-id}:0:1.
+	JaegerPropagator = "jaeger"
+
+	// CustomPropagator propagates the legacy trace-id, span-id and trace-flags headers.
+	CustomPropagator = "custom"
+
+	// NonePropagator disables the propagation.
+	NonePropagator = "none"
+)
+
+// TraceFlagsHeader is the legacy custom header propagating the W3C trace flags, i.e: 01 if sampled.
+const TraceFlagsHeader = "trace-flags"
+
+// DefaultPropagators are the propagators used if PropagatorsEnv is not set.
+var DefaultPropagators = []string{TraceContextPropagator, BaggagePropagator, CustomPropagator}
+
+// ErrInvalidPropagator is returned for unsupported propagator names.
+var ErrInvalidPropagator = fmt.Errorf("invalid propagator")
+
+// NewPropagator creates a composite propagator out of the named propagators, in precedence order,
+// i.e: NewPropagator(ctx, TraceContextPropagator, B3MultiPropagator).
+// The span context is extracted from the first propagator that finds a valid one, along with its sampled flag,
+// so the other ones cannot override it. The span context is injected by all of them, so any downstream
+// service understands at least one format. The baggage is extracted and injected by the baggage propagator.
+func NewPropagator(ctx context.Context, names ...string) (propagation.TextMapPropagator, error) {
+	var p compositePropagator
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case TraceContextPropagator:
+			p = append(p, propagation.TraceContext{})
+		case BaggagePropagator:
+			p = append(p, propagation.Baggage{})
+		case B3Propagator:
+			p = append(p, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case B3MultiPropagator:
+			p = append(p, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case JaegerPropagator:
+			p = append(p, jaeger.Jaeger{})
+		case CustomPropagator:
+			p = append(p, customPropagator{logger: sharedContext.Logger(ctx).With(zap.String("source", "open_telemetry"))})
+		case NonePropagator, "":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPropagator, name)
+		}
+	}
+	return p, nil
+}
+
+// PropagatorFromEnv creates the composite propagator configured by PropagatorsEnv. (default DefaultPropagators)
+func PropagatorFromEnv(ctx context.Context) (propagation.TextMapPropagator, error) {
+	names := DefaultPropagators
+	if env := os.Getenv(PropagatorsEnv); env != "" {
+		names = strings.Split(env, ",")
+	}
+	return NewPropagator(ctx, names...)
+}
+
+// NewTextMapPropagator creates the composite propagator configured by PropagatorsEnv, see PropagatorFromEnv.
+// It falls back to DefaultPropagators if PropagatorsEnv is invalid.
+func NewTextMapPropagator(ctx context.Context) propagation.TextMapPropagator {
+	p, err := PropagatorFromEnv(ctx)
+	if err != nil {
+		sharedContext.Logger(ctx).Error("could not create propagator, using the default ones", zap.Error(err))
+		p, _ = NewPropagator(ctx, DefaultPropagators...)
+	}
+	return p
+}
+
+// compositePropagator extracts the span context from the first propagator that finds a valid one.
+// Unlike propagation.NewCompositeTextMapPropagator, the next propagators cannot override it.
+type compositePropagator []propagation.TextMapPropagator
+
+func (ps compositePropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	valid := trace.SpanContextFromContext(ctx).IsValid()
+	for _, p := range ps {
+		// Not to propagate a not sampled decision without a span context, i.e: b3: 0.
+		if _, ok := p.(propagation.Baggage); !ok && !valid {
+			continue
+		}
+		p.Inject(ctx, carrier)
+	}
+}
+
+func (ps compositePropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	parent := trace.SpanContextFromContext(ctx)
+	var extracted trace.SpanContext
+	for _, p := range ps {
+		ctx = p.Extract(ctx, carrier)
+		if sc := trace.SpanContextFromContext(ctx); !extracted.IsValid() && sc.IsValid() && !sc.Equal(parent) {
+			extracted = sc
+		}
+	}
+	if extracted.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, extracted)
+	}
+	return ctx
+}
+
+func (ps compositePropagator) Fields() []string {
+	var fields []string
+	for _, p := range ps {
+		fields = append(fields, p.Fields()...)
+	}
+	return fields
+}
+
+// customPropagator propagates the span context using the legacy trace-id, span-id and trace-flags headers.
+// The spans are sampled if the trace-flags header is missing, i.e: set by the services not propagating it yet.
+type customPropagator struct {
+	logger *zap.Logger
+}
+
+func (p customPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	carrier.Set(TraceIDHeader, sc.TraceID().String())
+	carrier.Set(SpanIDHeader, sc.SpanID().String())
+	carrier.Set(TraceFlagsHeader, sc.TraceFlags().String())
+}
+
+func (p customPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	traceIDHeader := carrier.Get(TraceIDHeader)
+	spanIDHeader := carrier.Get(SpanIDHeader)
+	if traceIDHeader == "" || spanIDHeader == "" {
+		return ctx
+	}
+
+	traceID, err := trace.TraceIDFromHex(traceIDHeader)
+	if err != nil {
+		p.logger.Error("could not convert trace-id from string", zap.Error(err))
+		return ctx
+	}
+	spanID, err := trace.SpanIDFromHex(spanIDHeader)
+	if err != nil {
+		p.logger.Error("could not convert span-id from string", zap.Error(err))
+		return ctx
+	}
+	flags := trace.FlagsSampled
+	if h := carrier.Get(TraceFlagsHeader); h != "" {
+		f, err := strconv.ParseUint(h, 16, 8)
+		if err != nil {
+			p.logger.Error("could not convert trace-flags from string", zap.Error(err))
+			return ctx
+		}
+		flags = trace.TraceFlags(f)
+	}
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		Remote:     true,
+	})
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func (p customPropagator) Fields() []string {
+	return []string{TraceIDHeader, SpanIDHeader, TraceFlagsHeader}
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testTraceID = trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	testSpanID  = trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+)
+
+func spanContext(sampled bool) trace.SpanContext {
+	cfg := trace.SpanContextConfig{TraceID: testTraceID, SpanID: testSpanID, Remote: true}
+	if sampled {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	return trace.NewSpanContext(cfg)
+}
+
+func TestPropagatorInterop(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		reference propagation.TextMapPropagator
+		header    string
+	}{
+		{name: TraceContextPropagator, reference: propagation.TraceContext{}, header: "Traceparent"},
+		{name: B3Propagator, reference: b3.New(), header: "B3"},
+		{name: B3MultiPropagator, reference: b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)), header: "X-B3-Traceid"},
+		{name: JaegerPropagator, reference: jaeger.Jaeger{}, header: "Uber-Trace-Id"},
+	} {
+		p, err := NewPropagator(context.Background(), tc.name)
+		if err != nil {
+			t.Fatalf("%s: could not create propagator: %v", tc.name, err)
+		}
+		for _, sampled := range []bool{true, false} {
+			want := spanContext(sampled)
+
+			// Injected by the propagator, extracted by the reference implementation.
+			h := http.Header{}
+			p.Inject(trace.ContextWithRemoteSpanContext(context.Background(), want), propagation.HeaderCarrier(h))
+			if h.Get(tc.header) == "" {
+				t.Errorf("%s: expected the %s header, got %v", tc.name, tc.header, h)
+			}
+			got := trace.SpanContextFromContext(tc.reference.Extract(context.Background(), propagation.HeaderCarrier(h)))
+			if !got.Equal(want) {
+				t.Errorf("%s: expected the reference to extract %v, got %v", tc.name, want, got)
+			}
+
+			// Injected by the reference implementation, extracted by the propagator.
+			h = http.Header{}
+			tc.reference.Inject(trace.ContextWithRemoteSpanContext(context.Background(), want), propagation.HeaderCarrier(h))
+			got = trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h)))
+			if !got.Equal(want) {
+				t.Errorf("%s: expected to extract %v, got %v", tc.name, want, got)
+			}
+		}
+	}
+}
+
+func TestCustomPropagator(t *testing.T) {
+	p, err := NewPropagator(context.Background(), CustomPropagator)
+	if err != nil {
+		t.Fatalf("could not create propagator: %v", err)
+	}
+
+	for _, sampled := range []bool{true, false} {
+		want := spanContext(sampled)
+		h := http.Header{}
+		p.Inject(trace.ContextWithRemoteSpanContext(context.Background(), want), propagation.HeaderCarrier(h))
+		if got := trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h))); !got.Equal(want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	}
+
+	// The legacy headers without trace flags are sampled.
+	h := http.Header{}
+	h.Set(TraceIDHeader, testTraceID.String())
+	h.Set(SpanIDHeader, testSpanID.String())
+	if got := trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h))); !got.Equal(spanContext(true)) {
+		t.Errorf("expected %v, got %v", spanContext(true), got)
+	}
+
+	h.Set(TraceFlagsHeader, "zz")
+	if got := trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h))); got.IsValid() {
+		t.Errorf("expected no span context for invalid trace flags, got %v", got)
+	}
+}
+
+func TestPropagatorPrecedence(t *testing.T) {
+	p, err := NewPropagator(context.Background(), DefaultPropagators...)
+	if err != nil {
+		t.Fatalf("could not create propagator: %v", err)
+	}
+
+	// A not sampled traceparent is not overridden by the legacy headers, which used to mark it as sampled.
+	h := http.Header{}
+	h.Set("traceparent", "00-"+testTraceID.String()+"-"+testSpanID.String()+"-00")
+	h.Set(TraceIDHeader, "0af7651916cd43dd8448eb211c80319c")
+	h.Set(SpanIDHeader, "b7ad6b7169203331")
+	h.Set("baggage", "user_id=1")
+	ctx := p.Extract(context.Background(), propagation.HeaderCarrier(h))
+	if got := trace.SpanContextFromContext(ctx); !got.Equal(spanContext(false)) {
+		t.Errorf("expected the traceparent span context %v, got %v", spanContext(false), got)
+	}
+	if got := baggage.FromContext(ctx).Member("user_id").Value(); got != "1" {
+		t.Errorf("expected the baggage to be extracted, got %q", got)
+	}
+
+	// The next propagators are used when the first ones find no valid span context.
+	h.Set("traceparent", "invalid")
+	if got := trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h))); got.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("expected the legacy headers span context, got %v", got)
+	}
+
+	// Nothing but the baggage is injected without a valid span context.
+	p, _ = NewPropagator(context.Background(), B3Propagator, BaggagePropagator)
+	h = http.Header{}
+	member, _ := baggage.NewMember("user_id", "1")
+	b, _ := baggage.New(member)
+	p.Inject(baggage.ContextWithBaggage(context.Background(), b), propagation.HeaderCarrier(h))
+	if len(h) != 1 || h.Get("baggage") != "user_id=1" {
+		t.Errorf("expected only the baggage header, got %v", h)
+	}
+}
+
+func TestPropagatorFromEnv(t *testing.T) {
+	t.Setenv(PropagatorsEnv, "b3multi, jaeger,none")
+	p, err := PropagatorFromEnv(context.Background())
+	if err != nil {
+		t.Fatalf("could not create propagator: %v", err)
+	}
+	want := []string{"x-b3-traceid", "x-b3-spanid", "x-b3-sampled", "x-b3-flags", "uber-trace-id"}
+	if got := p.Fields(); len(got) != len(want) {
+		t.Errorf("expected the %v fields, got %v", want, got)
+	}
+
+	t.Setenv(PropagatorsEnv, "tracecontext,xray")
+	if _, err := PropagatorFromEnv(context.Background()); !errors.Is(err, ErrInvalidPropagator) {
+		t.Errorf("expected %v, got %v", ErrInvalidPropagator, err)
+	}
+}
@@ -0,0 +1,249 @@
+package tracing
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sampler environment variables, following the OTEL_TRACES_SAMPLER semantics.
+// https://opentelemetry.io/docs/specs/otel/configuration/sdk-environment-variables/#general-sdk-configuration
+const (
+	// SamplerEnv is the sampler name, i.e: parentbased_traceidratio.
+	SamplerEnv = "OTEL_TRACES_SAMPLER"
+
+	// SamplerArgEnv is the sampler argument, i.e: 0.25 for traceidratio or 100 for ratelimited.
+	SamplerArgEnv = "OTEL_TRACES_SAMPLER_ARG"
+
+	// SamplerRoutesEnv are the per route sampling rules, as a comma separated list of route=sampler[:arg],
+	// i.e: /health=always_off,/register=always_on,/users/*=traceidratio:0.1
+	SamplerRoutesEnv = "OTEL_TRACES_SAMPLER_ROUTES"
+)
+
+// Supported samplers.
+const (
+	AlwaysOnSampler                = "always_on"
+	AlwaysOffSampler               = "always_off"
+	TraceIDRatioSampler            = "traceidratio"
+	RateLimitedSampler             = "ratelimited"
+	ParentBasedAlwaysOnSampler     = "parentbased_always_on"
+	ParentBasedAlwaysOffSampler    = "parentbased_always_off"
+	ParentBasedTraceIDRatioSampler = "parentbased_traceidratio"
+	ParentBasedRateLimitedSampler  = "parentbased_ratelimited"
+)
+
+// Default sampler configuration values.
+const (
+	DefaultSamplerRatio           = 1.0
+	DefaultSamplerTracesPerSecond = 10.0
+)
+
+// Sampler errors.
+var (
+	ErrInvalidSampler    = fmt.Errorf("invalid sampler")
+	ErrInvalidSamplerArg = fmt.Errorf("invalid sampler argument")
+	ErrInvalidRouteRule  = fmt.Errorf("invalid sampler route rule")
+)
+
+// Route attributes, checked in order, used to match the sampler route rules.
+var routeAttributes = []attribute.Key{"http.route", "url.path", "http.target"}
+
+// NewSampler creates the named sampler (i.e: traceidratio), using the sampler argument if any (i.e: 0.25).
+// An empty name returns the parentbased_always_on sampler, which is the Open Telemetry default.
+func NewSampler(name, arg string) (traceSDK.Sampler, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "", ParentBasedAlwaysOnSampler:
+		return traceSDK.ParentBased(traceSDK.AlwaysSample()), nil
+	case AlwaysOnSampler:
+		return traceSDK.AlwaysSample(), nil
+	case AlwaysOffSampler:
+		return traceSDK.NeverSample(), nil
+	case ParentBasedAlwaysOffSampler:
+		return traceSDK.ParentBased(traceSDK.NeverSample()), nil
+	case TraceIDRatioSampler, ParentBasedTraceIDRatioSampler:
+		ratio, err := parseSamplerArg(arg, DefaultSamplerRatio)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("%w: %q must be a ratio between 0 and 1", ErrInvalidSamplerArg, arg)
+		}
+		if strings.HasPrefix(name, "parentbased_") {
+			return traceSDK.ParentBased(traceSDK.TraceIDRatioBased(ratio)), nil
+		}
+		return traceSDK.TraceIDRatioBased(ratio), nil
+	case RateLimitedSampler, ParentBasedRateLimitedSampler:
+		perSecond, err := parseSamplerArg(arg, DefaultSamplerTracesPerSecond)
+		if err != nil || perSecond < 0 {
+			return nil, fmt.Errorf("%w: %q must be a positive number of traces per second", ErrInvalidSamplerArg, arg)
+		}
+		if strings.HasPrefix(name, "parentbased_") {
+			return traceSDK.ParentBased(NewRateLimitedSampler(perSecond)), nil
+		}
+		return NewRateLimitedSampler(perSecond), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSampler, name)
+	}
+}
+
+// SamplerFromEnv creates the sampler configured by the OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG
+// environment variables, along with the OTEL_TRACES_SAMPLER_ROUTES per route rules, if any.
+// If none are set, it returns the parentbased_always_on sampler, which samples every request.
+func SamplerFromEnv() (traceSDK.Sampler, error) {
+	sampler, err := NewSampler(os.Getenv(SamplerEnv), os.Getenv(SamplerArgEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	routes := strings.TrimSpace(os.Getenv(SamplerRoutesEnv))
+	if routes == "" {
+		return sampler, nil
+	}
+
+	var rules []RouteRule
+	for _, rule := range strings.Split(routes, ",") {
+		route, spec, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok || strings.TrimSpace(route) == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRouteRule, rule)
+		}
+		name, arg, _ := strings.Cut(spec, ":")
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRouteRule, rule)
+		}
+		s, err := NewSampler(name, arg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidRouteRule, rule, err)
+		}
+		rules = append(rules, RouteRule{Route: strings.TrimSpace(route), Sampler: s})
+	}
+	return NewRouteSampler(rules, sampler), nil
+}
+
+func parseSamplerArg(arg string, defaultValue float64) (float64, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseFloat(arg, 64)
+}
+
+// NewRateLimitedSampler creates a sampler which samples at most tracesPerSecond traces every second,
+// allowing bursts of up to tracesPerSecond traces (at least 1). Use it with traceSDK.ParentBased,
+// so the rate limit only applies to the root spans and the child spans follow their parent decision.
+func NewRateLimitedSampler(tracesPerSecond float64) traceSDK.Sampler {
+	return &rateLimitedSampler{
+		perSecond: tracesPerSecond,
+		burst:     max(tracesPerSecond, 1),
+		tokens:    max(tracesPerSecond, 1),
+		now:       time.Now,
+	}
+}
+
+type rateLimitedSampler struct {
+	perSecond float64
+	burst     float64
+	now       func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (s *rateLimitedSampler) ShouldSample(p traceSDK.SamplingParameters) traceSDK.SamplingResult {
+	decision := traceSDK.Drop
+	if s.allow() {
+		decision = traceSDK.RecordAndSample
+	}
+	return traceSDK.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+// allow takes a token from the token bucket, which is refilled at perSecond tokens every second.
+func (s *rateLimitedSampler) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if !s.last.IsZero() {
+		s.tokens = min(s.burst, s.tokens+now.Sub(s.last).Seconds()*s.perSecond)
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *rateLimitedSampler) Description() string {
+	return fmt.Sprintf("RateLimitedSampler{%g}", s.perSecond)
+}
+
+// RouteRule represents a per route sampling rule.
+type RouteRule struct {
+	// Route is the HTTP route, i.e: /register. A trailing * matches any route with the given prefix, i.e: /users/*.
+	Route string
+
+	// Sampler is the sampler used for the spans of the route, i.e: traceSDK.NeverSample() for /health.
+	Sampler traceSDK.Sampler
+}
+
+// NewRouteSampler creates a sampler which uses the sampler of the first rule matching the span HTTP route
+// (the http.route, url.path or http.target attributes, set by InstrumentHTTP), or the fallback sampler otherwise,
+// including for the spans without an HTTP route. The rules take precedence over the parent sampling decision.
+func NewRouteSampler(rules []RouteRule, fallback traceSDK.Sampler) traceSDK.Sampler {
+	if fallback == nil {
+		fallback = traceSDK.ParentBased(traceSDK.AlwaysSample())
+	}
+	return routeSampler{rules: rules, fallback: fallback}
+}
+
+type routeSampler struct {
+	rules    []RouteRule
+	fallback traceSDK.Sampler
+}
+
+func (s routeSampler) ShouldSample(p traceSDK.SamplingParameters) traceSDK.SamplingResult {
+	if route, ok := spanRoute(p.Attributes); ok {
+		for _, rule := range s.rules {
+			if matchRoute(rule.Route, route) {
+				return rule.Sampler.ShouldSample(p)
+			}
+		}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s routeSampler) Description() string {
+	rules := make([]string, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule.Route+":"+rule.Sampler.Description())
+	}
+	return fmt.Sprintf("RouteSampler{rules:[%s],fallback:%s}", strings.Join(rules, ","), s.fallback.Description())
+}
+
+func spanRoute(attributes []attribute.KeyValue) (string, bool) {
+	for _, key := range routeAttributes {
+		for _, a := range attributes {
+			if a.Key == key && a.Value.AsString() != "" {
+				route, _, _ := strings.Cut(a.Value.AsString(), "?")
+				return route, true
+			}
+		}
+	}
+	return "", false
+}
+
+func matchRoute(pattern, route string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(route, prefix)
+	}
+	return pattern == route
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewSampler(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		arg         string
+		description string
+		err         error
+	}{
+		{name: "", description: "ParentBased{root:AlwaysOnSampler,remoteParentSampled:AlwaysOnSampler,remoteParentNotSampled:AlwaysOffSampler,localParentSampled:AlwaysOnSampler,localParentNotSampled:AlwaysOffSampler}"},
+		{name: "always_on", description: "AlwaysOnSampler"},
+		{name: "ALWAYS_OFF", description: "AlwaysOffSampler"},
+		{name: "traceidratio", arg: "0.25", description: "TraceIDRatioBased{0.25}"},
+		{name: "traceidratio", description: "AlwaysOnSampler"},
+		{name: "parentbased_traceidratio", arg: "0.5", description: "ParentBased{root:TraceIDRatioBased{0.5},remoteParentSampled:AlwaysOnSampler,remoteParentNotSampled:AlwaysOffSampler,localParentSampled:AlwaysOnSampler,localParentNotSampled:AlwaysOffSampler}"},
+		{name: "ratelimited", arg: "100", description: "RateLimitedSampler{100}"},
+		{name: "parentbased_ratelimited", description: "ParentBased{root:RateLimitedSampler{10},remoteParentSampled:AlwaysOnSampler,remoteParentNotSampled:AlwaysOffSampler,localParentSampled:AlwaysOnSampler,localParentNotSampled:AlwaysOffSampler}"},
+		{name: "traceidratio", arg: "2", err: ErrInvalidSamplerArg},
+		{name: "ratelimited", arg: "many", err: ErrInvalidSamplerArg},
+		{name: "jaeger_remote", err: ErrInvalidSampler},
+	} {
+		sampler, err := NewSampler(tc.name, tc.arg)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s(%s): expected error %v, got %v", tc.name, tc.arg, tc.err, err)
+			continue
+		}
+		if err == nil && sampler.Description() != tc.description {
+			t.Errorf("%s(%s): expected %s, got %s", tc.name, tc.arg, tc.description, sampler.Description())
+		}
+	}
+}
+
+func TestSamplerFromEnv(t *testing.T) {
+	t.Setenv(SamplerEnv, "parentbased_always_off")
+	t.Setenv(SamplerArgEnv, "")
+	t.Setenv(SamplerRoutesEnv, "/health=always_off, /register=always_on,/users/*=traceidratio:0")
+
+	sampler, err := SamplerFromEnv()
+	if err != nil {
+		t.Fatalf("could not create sampler: %v", err)
+	}
+	for _, tc := range []struct {
+		target  string
+		sampled bool
+	}{
+		{target: "/health", sampled: false},
+		{target: "/register", sampled: true},
+		{target: "/register?source=web", sampled: true},
+		{target: "/users/1", sampled: false},
+		{target: "/notify", sampled: false},
+	} {
+		if got := sample(sampler, attribute.String("http.target", tc.target)); got != tc.sampled {
+			t.Errorf("%s: expected sampled %v, got %v", tc.target, tc.sampled, got)
+		}
+	}
+
+	t.Setenv(SamplerRoutesEnv, "/health")
+	if _, err := SamplerFromEnv(); !errors.Is(err, ErrInvalidRouteRule) {
+		t.Errorf("expected %v, got %v", ErrInvalidRouteRule, err)
+	}
+	t.Setenv(SamplerRoutesEnv, "/health=sometimes")
+	if _, err := SamplerFromEnv(); !errors.Is(err, ErrInvalidSampler) {
+		t.Errorf("expected %v, got %v", ErrInvalidSampler, err)
+	}
+}
+
+func TestRateLimitedSampler(t *testing.T) {
+	sampler := NewRateLimitedSampler(2).(*rateLimitedSampler)
+	now := time.Unix(1_700_000_000, 0)
+	sampler.now = func() time.Time { return now }
+
+	count := func(n int) int {
+		sampled := 0
+		for i := 0; i < n; i++ {
+			if sample(sampler) {
+				sampled++
+			}
+		}
+		return sampled
+	}
+	if got := count(10); got != 2 {
+		t.Errorf("expected a burst of 2 sampled traces, got %d", got)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if got := count(10); got != 1 {
+		t.Errorf("expected 1 sampled trace after 500ms, got %d", got)
+	}
+	now = now.Add(time.Minute)
+	if got := count(10); got != 2 {
+		t.Errorf("expected the burst to be capped at 2 sampled traces, got %d", got)
+	}
+}
+
+func TestRouteSamplerHTTP(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := traceSDK.NewTracerProvider(
+		traceSDK.WithSpanProcessor(recorder),
+		traceSDK.WithSampler(NewRouteSampler([]RouteRule{
+			{Route: "/health", Sampler: traceSDK.NeverSample()},
+			{Route: "/register", Sampler: traceSDK.AlwaysSample()},
+		}, traceSDK.ParentBased(traceSDK.NeverSample()))),
+	)
+	global := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(global)
+		_ = provider.Shutdown(context.Background())
+	})
+
+	h := InstrumentHTTP(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), "test")
+	for _, target := range []string{"/health", "/register", "/other"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 sampled span, got %d", len(spans))
+	}
+	for _, a := range spans[0].Attributes() {
+		if a.Key == "http.target" && a.Value.AsString() != "/register" {
+			t.Errorf("expected the /register span to be sampled, got %s", a.Value.AsString())
+		}
+	}
+}
+
+func sample(sampler traceSDK.Sampler, attributes ...attribute.KeyValue) bool {
+	res := sampler.ShouldSample(traceSDK.SamplingParameters{
+		ParentContext: context.Background(),
+		TraceID:       trace.TraceID{1},
+		Name:          "test",
+		Kind:          trace.SpanKindServer,
+		Attributes:    attributes,
+	})
+	return res.Decision == traceSDK.RecordAndSample
+}
@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+// Default span metrics configuration values.
+const (
+	DefaultSpanMetricsMaxSpanNames = 1_000
+)
+
+// SpanMetricsOverflowName is the span_name label of the spans whose name exceeded the span names limit.
+const SpanMetricsOverflowName = "other"
+
+// SpanMetricsOpts represents the span metrics processor configuration options.
+type SpanMetricsOpts struct {
+	// MaxSpanNames is the maximum number of distinct span names, used as the span_name label.
+	// Once reached, the spans with new names are recorded as SpanMetricsOverflowName. (default 1000)
+	MaxSpanNames int
+
+	// Buckets are the span duration histogram buckets, in seconds. (default Prometheus default buckets)
+	Buckets []float64
+
+	// Registry is the metrics registry used for the span metrics. (default metrics.Default())
+	Registry *metrics.Registry
+}
+
+// SpanMetricsProcessor represents a span processor deriving RED (rate, errors, duration) metrics
+// from every ended span, by span name, kind and status code:
+//   - span_calls_total: the number of spans.
+//   - span_errors_total: the number of spans with an error status.
+//   - span_duration_seconds: the span duration, with the trace id as exemplar for the sampled spans.
+//   - span_metrics_overflow_total: the number of spans recorded as SpanMetricsOverflowName.
+//
+// To derive the metrics from the spans which are not sampled as well, so they are accurate at any sampling rate,
+// the sampler must record the dropped spans, see NewRecordingSampler. TracerProviderConfig.SpanMetrics does that.
+type SpanMetricsProcessor struct {
+	maxSpanNames int
+
+	calls    metrics.CounterVecMetric
+	errors   metrics.CounterVecMetric
+	duration metrics.ObserverVecMetric
+	overflow metrics.CounterMetric
+
+	mu        sync.RWMutex
+	spanNames map[string]struct{}
+}
+
+// NewSpanMetricsProcessor creates a new span metrics span processor.
+func NewSpanMetricsProcessor(opts SpanMetricsOpts) *SpanMetricsProcessor {
+	if opts.MaxSpanNames < 1 {
+		opts.MaxSpanNames = DefaultSpanMetricsMaxSpanNames
+	}
+	r := opts.Registry
+	if r == nil {
+		r = metrics.Default()
+	}
+
+	return &SpanMetricsProcessor{
+		maxSpanNames: opts.MaxSpanNames,
+		calls:        r.CounterVec("span_calls_total", "Number of spans", "span_name", "span_kind", "status_code"),
+		errors:       r.CounterVec("span_errors_total", "Number of spans with an error status", "span_name", "span_kind"),
+		duration: r.HistogramVecWithOpts(
+			"span_duration_seconds",
+			metrics.HistogramOpts{Buckets: opts.Buckets},
+			"Span duration",
+			"span_name", "span_kind", "status_code",
+		),
+		overflow:  r.Counter("span_metrics_overflow_total", "Number of spans whose name exceeded the span names limit"),
+		spanNames: map[string]struct{}{},
+	}
+}
+
+// OnStart is a no-op, the metrics are derived once the spans end.
+func (p *SpanMetricsProcessor) OnStart(context.Context, traceSDK.ReadWriteSpan) {
+}
+
+// OnEnd records the span metrics, whether the span is sampled or not.
+func (p *SpanMetricsProcessor) OnEnd(s traceSDK.ReadOnlySpan) {
+	name := p.spanName(s.Name())
+	kind := s.SpanKind().String()
+	status := s.Status().Code.String()
+
+	p.calls.WithLabelValues(name, kind, status).Inc()
+	if s.Status().Code == codes.Error {
+		p.errors.WithLabelValues(name, kind).Inc()
+	}
+	ctx := trace.ContextWithSpanContext(context.Background(), s.SpanContext())
+	metrics.ObserveDuration(ctx, p.duration.WithLabelValues(name, kind, status), s.EndTime().Sub(s.StartTime()), time.Second)
+}
+
+// ForceFlush is a no-op, the metrics are recorded right away.
+func (p *SpanMetricsProcessor) ForceFlush(context.Context) error {
+	return nil
+}
+
+// Shutdown is a no-op, the metrics are recorded right away.
+func (p *SpanMetricsProcessor) Shutdown(context.Context) error {
+	return nil
+}
+
+// spanName returns the span_name label, enforcing the span names limit.
+func (p *SpanMetricsProcessor) spanName(name string) string {
+	p.mu.RLock()
+	_, ok := p.spanNames[name]
+	p.mu.RUnlock()
+	if ok {
+		return name
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.spanNames[name]; ok {
+		return name
+	}
+	if len(p.spanNames) >= p.maxSpanNames {
+		p.overflow.Inc()
+		return SpanMetricsOverflowName
+	}
+	p.spanNames[name] = struct{}{}
+	return name
+}
+
+// recordingSampler records the spans dropped by the sampler without sampling them,
+// so the span processors see every span, while only the sampled ones are exported.
+type recordingSampler struct {
+	traceSDK.Sampler
+}
+
+// NewRecordingSampler wraps the sampler so the spans it drops are still recorded, but not sampled (i.e: exported),
+// which the SpanMetricsProcessor needs to see every span. The sampling decision propagated downstream is unchanged.
+// Recording every span has a cost, since the dropped spans are not no-op spans anymore.
+func NewRecordingSampler(sampler traceSDK.Sampler) traceSDK.Sampler {
+	return recordingSampler{Sampler: sampler}
+}
+
+func (s recordingSampler) ShouldSample(p traceSDK.SamplingParameters) traceSDK.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if result.Decision == traceSDK.Drop {
+		result.Decision = traceSDK.RecordOnly
+	}
+	return result
+}
+
+func (s recordingSampler) Description() string {
+	return "Recording{" + s.Sampler.Description() + "}"
+}
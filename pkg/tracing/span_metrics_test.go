@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+func TestTracerProviderSpanMetrics(t *testing.T) {
+	r := metrics.New(metrics.RegistryOpts{Prefix: "test"})
+	exporter := &flakyExporter{name: "memory", up: true, exporter: tracetest.NewInMemoryExporter()}
+	provider, err := NewTracerProvider(TracerProviderConfig{
+		TracingEnabled: true,
+		ServiceName:    "test",
+		Exporters:      []SpanExporter{exporter},
+		Sampler:        traceSDK.ParentBased(traceSDK.TraceIDRatioBased(0)),
+		SpanMetrics:    &SpanMetricsOpts{Registry: r},
+		BatchTimeout:   time.Hour,
+		ExportTimeout:  time.Second,
+		MaxBatchSize:   512,
+		MaxQueueSize:   2048,
+	})
+	if err != nil {
+		t.Fatalf("could not create provider: %v", err)
+	}
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	tracer := provider.Tracer("span_metrics_test")
+	for i := 0; i < 3; i++ {
+		ctx, root := tracer.Start(context.Background(), "register_user_endpoint", trace.WithSpanKind(trace.SpanKindServer))
+		_, child := tracer.Start(ctx, "register_user_txn", trace.WithSpanKind(trace.SpanKindClient))
+		if i == 0 {
+			RecordError(trace.ContextWithSpan(ctx, child), errors.New("boom"), "could not register user")
+		}
+		child.End()
+		if sc := root.SpanContext(); sc.IsSampled() {
+			t.Errorf("expected the span not to be sampled")
+		}
+		root.End()
+	}
+	_ = provider.ForceFlush(context.Background())
+
+	// The metrics are derived from every span, while none of them is exported.
+	if got := len(exporter.exporter.GetSpans()); got != 0 {
+		t.Errorf("expected no exported spans, got %d", got)
+	}
+	out := scrape(t, r)
+	for _, s := range []string{
+		`test_span_calls_total{span_kind="server",span_name="register_user_endpoint",status_code="Unset"} 3`,
+		`test_span_calls_total{span_kind="client",span_name="register_user_txn",status_code="Unset"} 2`,
+		`test_span_calls_total{span_kind="client",span_name="register_user_txn",status_code="Error"} 1`,
+		`test_span_errors_total{span_kind="client",span_name="register_user_txn"} 1`,
+		`test_span_duration_seconds_count{span_kind="server",span_name="register_user_endpoint",status_code="Unset"} 3`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %s in:\n%s", s, out)
+		}
+	}
+}
+
+func TestSpanMetricsMaxSpanNames(t *testing.T) {
+	r := metrics.New(metrics.RegistryOpts{Prefix: "test"})
+	provider := traceSDK.NewTracerProvider(traceSDK.WithSpanProcessor(NewSpanMetricsProcessor(SpanMetricsOpts{MaxSpanNames: 2, Registry: r})))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	for _, name := range []string{"a", "b", "c", "a", "d"} {
+		_, span := provider.Tracer("span_metrics_test").Start(context.Background(), name)
+		span.End()
+	}
+
+	out := scrape(t, r)
+	for _, s := range []string{
+		`test_span_calls_total{span_kind="internal",span_name="a",status_code="Unset"} 2`,
+		`test_span_calls_total{span_kind="internal",span_name="b",status_code="Unset"} 1`,
+		`test_span_calls_total{span_kind="internal",span_name="other",status_code="Unset"} 2`,
+		`test_span_metrics_overflow_total 2`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %s in:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package tracing
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+// Default tail sampling configuration values.
+const (
+	DefaultTailLatencyThreshold = 500 * time.Millisecond
+	DefaultTailSampleRatio      = 0.01
+	DefaultTailTraceTimeout     = 30 * time.Second
+	DefaultTailMaxTraces        = 10_000
+	DefaultTailMaxSpansPerTrace = 1_000
+	DefaultTailMaxDecisions     = 10_000
+)
+
+// Tail sampling decisions and reasons, used as the decision and reason labels of the tail_sampling_traces_total metric.
+const (
+	tailDecisionKept    = "kept"
+	tailDecisionDropped = "dropped"
+
+	tailReasonError   = "error"
+	tailReasonLatency = "latency"
+	tailReasonRatio   = "ratio"
+	tailReasonTimeout = "timeout"
+	tailReasonEvicted = "evicted"
+)
+
+// TailSamplingOpts represents the tail sampling span processor configuration options.
+type TailSamplingOpts struct {
+	// LatencyThreshold is the root span duration above which the whole trace is kept. (default 500ms)
+	LatencyThreshold time.Duration
+
+	// SampleRatio is the ratio of the traces kept, out of the ones without errors or slow roots. (default 0.01)
+	// The decision is based on the trace-id, so all the services of a trace make the same decision.
+	// Use a negative ratio to drop all of them.
+	SampleRatio float64
+
+	// TraceTimeout is the maximum time a trace is buffered, waiting for its root span to end.
+	// Orphaned traces (i.e: their root span is never ended) are only kept if they have errors. (default 30s)
+	TraceTimeout time.Duration
+
+	// MaxTraces is the maximum number of buffered traces. Once reached, the oldest trace is decided right away,
+	// the same as the orphaned traces. (default 10000)
+	MaxTraces int
+
+	// MaxSpansPerTrace is the maximum number of buffered spans of a trace, the others are dropped. (default 1000)
+	MaxSpansPerTrace int
+
+	// MaxDecisions is the maximum number of recent decisions remembered, to decide the late spans of the
+	// already decided traces the same, i.e: the children ending after their root span. (default 10000)
+	MaxDecisions int
+
+	// Registry is the metrics registry used for the tail sampling metrics. (default metrics.Default())
+	Registry *metrics.Registry
+}
+
+// TailSamplingProcessor represents a tail sampling span processor, which buffers the spans of every trace
+// until its local root span ends, then decides whether the whole trace is kept and passed on to the next
+// span processor (i.e: the batch span processor), or dropped. The spans ending after the decision follow it.
+// A trace is kept when:
+//   - any of its spans has an error status, i.e: set by RecordError.
+//   - its root span lasted longer than the latency threshold.
+//   - it is sampled by the sample ratio.
+//
+// Only the sampled spans are buffered, so the head sampler should sample every trace, i.e: AlwaysOnSampler.
+// The processor exports the following metrics:
+//   - tail_sampling_traces_total: the number of traces decided, by decision and reason.
+//   - tail_sampling_buffered_traces: the number of traces currently buffered.
+//   - tail_sampling_dropped_spans_total: the number of spans dropped because their trace had too many spans.
+type TailSamplingProcessor struct {
+	next      traceSDK.SpanProcessor
+	opts      TailSamplingOpts
+	threshold uint64
+	now       func() time.Time
+
+	decisions    metrics.CounterVecMetric
+	buffered     metrics.GaugeMetric
+	droppedSpans metrics.CounterMetric
+
+	mu     sync.Mutex
+	traces map[trace.TraceID]*tailTrace
+	order  *list.List
+
+	// recent holds whether the recently decided traces were kept, recentOrder their trace ids, oldest first.
+	recent      map[trace.TraceID]bool
+	recentOrder *list.List
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type tailTrace struct {
+	id      trace.TraceID
+	spans   []traceSDK.ReadOnlySpan
+	failed  bool
+	started time.Time
+	elem    *list.Element
+}
+
+type tailDecision struct {
+	t      *tailTrace
+	kept   bool
+	reason string
+}
+
+// NewTailSamplingProcessor creates a new tail sampling span processor, which passes the spans
+// of the kept traces on to next. It starts expiring the orphaned traces right away.
+func NewTailSamplingProcessor(next traceSDK.SpanProcessor, opts TailSamplingOpts) *TailSamplingProcessor {
+	if opts.LatencyThreshold <= 0 {
+		opts.LatencyThreshold = DefaultTailLatencyThreshold
+	}
+	if opts.SampleRatio == 0 {
+		opts.SampleRatio = DefaultTailSampleRatio
+	}
+	if opts.TraceTimeout <= 0 {
+		opts.TraceTimeout = DefaultTailTraceTimeout
+	}
+	if opts.MaxTraces < 1 {
+		opts.MaxTraces = DefaultTailMaxTraces
+	}
+	if opts.MaxSpansPerTrace < 1 {
+		opts.MaxSpansPerTrace = DefaultTailMaxSpansPerTrace
+	}
+	if opts.MaxDecisions < 1 {
+		opts.MaxDecisions = DefaultTailMaxDecisions
+	}
+	r := opts.Registry
+	if r == nil {
+		r = metrics.Default()
+	}
+
+	p := &TailSamplingProcessor{
+		next:         next,
+		opts:         opts,
+		threshold:    ratioThreshold(opts.SampleRatio),
+		now:          time.Now,
+		decisions:    r.CounterVec("tail_sampling_traces_total", "Number of traces decided by the tail sampler", "decision", "reason"),
+		buffered:     r.Gauge("tail_sampling_buffered_traces", "Number of traces buffered by the tail sampler"),
+		droppedSpans: r.Counter("tail_sampling_dropped_spans_total", "Number of spans dropped because their trace had too many spans"),
+		traces:       map[trace.TraceID]*tailTrace{},
+		order:        list.New(),
+		recent:       map[trace.TraceID]bool{},
+		recentOrder:  list.New(),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// OnStart is a no-op, the spans are only buffered once they end.
+func (p *TailSamplingProcessor) OnStart(context.Context, traceSDK.ReadWriteSpan) {
+}
+
+// OnEnd buffers the ended span, deciding the trace once its local root span ends.
+// The late spans of an already decided trace are kept or dropped right away, the same as the trace.
+func (p *TailSamplingProcessor) OnEnd(s traceSDK.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+
+	var decided []tailDecision
+
+	p.mu.Lock()
+	id := s.SpanContext().TraceID()
+	t, ok := p.traces[id]
+	if !ok {
+		if kept, ok := p.recent[id]; ok {
+			p.mu.Unlock()
+			if kept {
+				p.next.OnEnd(s)
+			}
+			return
+		}
+		if p.order.Len() >= p.opts.MaxTraces {
+			oldest := p.remove(p.order.Front().Value.(*tailTrace))
+			decided = append(decided, p.decideIncomplete(oldest, tailReasonEvicted))
+		}
+		t = &tailTrace{id: id, started: p.now()}
+		t.elem = p.order.PushBack(t)
+		p.traces[id] = t
+	}
+	if len(t.spans) < p.opts.MaxSpansPerTrace {
+		t.spans = append(t.spans, s)
+	} else {
+		p.droppedSpans.Inc()
+	}
+	if s.Status().Code == codes.Error {
+		t.failed = true
+	}
+	if !s.Parent().IsValid() || s.Parent().IsRemote() {
+		decided = append(decided, p.decide(p.remove(t), s.EndTime().Sub(s.StartTime())))
+	}
+	p.buffered.Set(float64(p.order.Len()))
+	p.mu.Unlock()
+
+	p.apply(decided)
+}
+
+// ForceFlush passes the pending spans of the kept traces on to the next span processor.
+// The buffered traces are not flushed, since they are not decided yet.
+func (p *TailSamplingProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// Shutdown stops expiring the orphaned traces, decides the buffered traces the same as the orphaned ones,
+// then shuts down the next span processor.
+func (p *TailSamplingProcessor) Shutdown(ctx context.Context) error {
+	p.once.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	p.mu.Lock()
+	var pending []tailDecision
+	for p.order.Len() > 0 {
+		pending = append(pending, p.decideIncomplete(p.remove(p.order.Front().Value.(*tailTrace)), tailReasonTimeout))
+	}
+	p.buffered.Set(0)
+	p.mu.Unlock()
+
+	p.apply(pending)
+	return p.next.Shutdown(ctx)
+}
+
+func (p *TailSamplingProcessor) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.opts.TraceTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.expire()
+		}
+	}
+}
+
+// expire decides the traces buffered for longer than the trace timeout.
+func (p *TailSamplingProcessor) expire() {
+	p.mu.Lock()
+	var expired []tailDecision
+	now := p.now()
+	for p.order.Len() > 0 {
+		t := p.order.Front().Value.(*tailTrace)
+		if now.Sub(t.started) < p.opts.TraceTimeout {
+			break
+		}
+		expired = append(expired, p.decideIncomplete(p.remove(t), tailReasonTimeout))
+	}
+	p.buffered.Set(float64(p.order.Len()))
+	p.mu.Unlock()
+
+	p.apply(expired)
+}
+
+// remove removes the trace from the buffer. It must be called with the lock held.
+func (p *TailSamplingProcessor) remove(t *tailTrace) *tailTrace {
+	p.order.Remove(t.elem)
+	delete(p.traces, t.id)
+	return t
+}
+
+// decide decides a trace whose root span ended. It must be called with the lock held.
+func (p *TailSamplingProcessor) decide(t *tailTrace, latency time.Duration) tailDecision {
+	switch {
+	case t.failed:
+		return p.remember(t, true, tailReasonError)
+	case latency > p.opts.LatencyThreshold:
+		return p.remember(t, true, tailReasonLatency)
+	case p.sampled(t.id):
+		return p.remember(t, true, tailReasonRatio)
+	default:
+		return p.remember(t, false, tailReasonRatio)
+	}
+}
+
+// decideIncomplete decides a trace whose root span did not end, keeping it only if it has errors.
+// It must be called with the lock held.
+func (p *TailSamplingProcessor) decideIncomplete(t *tailTrace, reason string) tailDecision {
+	if t.failed {
+		return p.remember(t, true, tailReasonError)
+	}
+	return p.remember(t, false, reason)
+}
+
+// remember records the decision for the late spans of the trace, forgetting the oldest decision once
+// the maximum number of decisions is reached. It must be called with the lock held.
+func (p *TailSamplingProcessor) remember(t *tailTrace, kept bool, reason string) tailDecision {
+	if _, ok := p.recent[t.id]; !ok {
+		if p.recentOrder.Len() >= p.opts.MaxDecisions {
+			delete(p.recent, p.recentOrder.Remove(p.recentOrder.Front()).(trace.TraceID))
+		}
+		p.recentOrder.PushBack(t.id)
+	}
+	p.recent[t.id] = kept
+	return tailDecision{t: t, kept: kept, reason: reason}
+}
+
+// apply counts the decisions and passes the spans of the kept traces on to the next span processor.
+func (p *TailSamplingProcessor) apply(decisions []tailDecision) {
+	for _, d := range decisions {
+		if !d.kept {
+			p.decisions.WithLabelValues(tailDecisionDropped, d.reason).Inc()
+			continue
+		}
+		p.decisions.WithLabelValues(tailDecisionKept, d.reason).Inc()
+		for _, s := range d.t.spans {
+			p.next.OnEnd(s)
+		}
+	}
+}
+
+// sampled reports whether the trace is sampled by the sample ratio, the same as traceSDK.TraceIDRatioBased.
+func (p *TailSamplingProcessor) sampled(id trace.TraceID) bool {
+	return binary.BigEndian.Uint64(id[8:16])>>1 < p.threshold
+}
+
+func ratioThreshold(ratio float64) uint64 {
+	if ratio >= 1 {
+		return 1 << 63
+	}
+	if ratio <= 0 {
+		return 0
+	}
+	return uint64(ratio * (1 << 63))
+}
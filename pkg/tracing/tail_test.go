@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
+)
+
+func newTailTracer(t *testing.T, opts TailSamplingOpts) (trace.Tracer, *TailSamplingProcessor, *tracetest.SpanRecorder, *metrics.Registry) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	r := metrics.New(metrics.RegistryOpts{Prefix: "test"})
+	opts.Registry = r
+	processor := NewTailSamplingProcessor(recorder, opts)
+	provider := traceSDK.NewTracerProvider(traceSDK.WithSampler(traceSDK.AlwaysSample()), traceSDK.WithSpanProcessor(processor))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+	return provider.Tracer("tail_test"), processor, recorder, r
+}
+
+func scrape(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatalf("could not read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestTailSamplingDecisions(t *testing.T) {
+	tracer, _, recorder, r := newTailTracer(t, TailSamplingOpts{LatencyThreshold: time.Second, SampleRatio: -1})
+	start := time.Now()
+
+	// A failed trace is kept, along with all its spans.
+	ctx, root := tracer.Start(context.Background(), "failed")
+	_, child := tracer.Start(ctx, "failed_child")
+	RecordError(trace.ContextWithSpan(ctx, child), errors.New("boom"), "could not do it")
+	child.End()
+	root.End()
+
+	// A slow trace is kept.
+	_, root = tracer.Start(context.Background(), "slow", trace.WithTimestamp(start))
+	root.End(trace.WithTimestamp(start.Add(2 * time.Second)))
+
+	// A fast trace without errors is dropped.
+	ctx, root = tracer.Start(context.Background(), "fast", trace.WithTimestamp(start))
+	_, child = tracer.Start(ctx, "fast_child")
+	child.End()
+	root.End(trace.WithTimestamp(start.Add(100 * time.Millisecond)))
+
+	var names []string
+	for _, s := range recorder.Ended() {
+		names = append(names, s.Name())
+	}
+	if got := strings.Join(names, ","); got != "failed_child,failed,slow" {
+		t.Errorf("expected the failed and slow traces to be kept, got %s", got)
+	}
+
+	body := scrape(t, r)
+	for _, line := range []string{
+		`test_tail_sampling_traces_total{decision="kept",reason="error"} 1`,
+		`test_tail_sampling_traces_total{decision="kept",reason="latency"} 1`,
+		`test_tail_sampling_traces_total{decision="dropped",reason="ratio"} 1`,
+		`test_tail_sampling_buffered_traces 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestTailSamplingRatio(t *testing.T) {
+	tracer, _, recorder, _ := newTailTracer(t, TailSamplingOpts{SampleRatio: 1})
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "fast")
+		span.End()
+	}
+	if got := len(recorder.Ended()); got != 10 {
+		t.Errorf("expected all the traces to be kept, got %d", got)
+	}
+}
+
+func TestTailSamplingOrphanedTraces(t *testing.T) {
+	tracer, processor, recorder, r := newTailTracer(t, TailSamplingOpts{TraceTimeout: time.Hour})
+	now := time.Now()
+	processor.now = func() time.Time { return now }
+
+	// The roots never end.
+	ctx, _ := tracer.Start(context.Background(), "orphaned")
+	_, child := tracer.Start(ctx, "orphaned_child")
+	child.End()
+	ctx, _ = tracer.Start(context.Background(), "orphaned_failed")
+	_, child = tracer.Start(ctx, "orphaned_failed_child")
+	RecordError(trace.ContextWithSpan(ctx, child), errors.New("boom"), "could not do it")
+	child.End()
+
+	processor.expire()
+	if got := len(recorder.Ended()); got != 0 {
+		t.Errorf("expected the traces to be buffered until the timeout, got %d kept spans", got)
+	}
+	if body := scrape(t, r); !strings.Contains(body, "test_tail_sampling_buffered_traces 2") {
+		t.Errorf("expected 2 buffered traces in:\n%s", body)
+	}
+
+	now = now.Add(time.Hour)
+	processor.expire()
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "orphaned_failed_child" {
+		t.Errorf("expected only the failed orphaned trace to be kept, got %v", spans)
+	}
+	body := scrape(t, r)
+	for _, line := range []string{
+		`test_tail_sampling_traces_total{decision="dropped",reason="timeout"} 1`,
+		`test_tail_sampling_traces_total{decision="kept",reason="error"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestTailSamplingBounds(t *testing.T) {
+	tracer, _, recorder, r := newTailTracer(t, TailSamplingOpts{MaxTraces: 1, MaxSpansPerTrace: 2, SampleRatio: 1})
+
+	ctx, first := tracer.Start(context.Background(), "first")
+	for i := 0; i < 3; i++ {
+		_, child := tracer.Start(ctx, "first_child")
+		child.End()
+	}
+	// Buffering the second trace evicts the first one.
+	ctx, second := tracer.Start(context.Background(), "second")
+	_, child := tracer.Start(ctx, "second_child")
+	child.End()
+	second.End()
+	// The first trace was dropped when evicted, so is its late root span.
+	first.End()
+
+	var names []string
+	for _, s := range recorder.Ended() {
+		names = append(names, s.Name())
+	}
+	if got := strings.Join(names, ","); got != "second_child,second" {
+		t.Errorf("expected the first trace to be evicted, got %s", got)
+	}
+
+	body := scrape(t, r)
+	for _, line := range []string{
+		`test_tail_sampling_traces_total{decision="dropped",reason="evicted"} 1`,
+		`test_tail_sampling_dropped_spans_total 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestTailSamplingLateSpans(t *testing.T) {
+	tracer, processor, recorder, r := newTailTracer(t, TailSamplingOpts{LatencyThreshold: time.Second, SampleRatio: -1, MaxDecisions: 1})
+	start := time.Now()
+
+	// The children of the slow trace end after its root, i.e: fire and forget goroutines, and are kept.
+	ctx, root := tracer.Start(context.Background(), "slow", trace.WithTimestamp(start))
+	_, child := tracer.Start(ctx, "slow_child")
+	root.End(trace.WithTimestamp(start.Add(2 * time.Second)))
+	child.End()
+
+	// The children of the fast trace end after its root and are dropped.
+	ctx, root = tracer.Start(context.Background(), "fast", trace.WithTimestamp(start))
+	_, child = tracer.Start(ctx, "fast_child")
+	root.End(trace.WithTimestamp(start.Add(100 * time.Millisecond)))
+	child.End()
+
+	var names []string
+	for _, s := range recorder.Ended() {
+		names = append(names, s.Name())
+	}
+	if got := strings.Join(names, ","); got != "slow,slow_child" {
+		t.Errorf("expected the late child of the slow trace to be kept, got %s", got)
+	}
+
+	processor.mu.Lock()
+	remembered := len(processor.recent)
+	processor.mu.Unlock()
+	if remembered != 1 {
+		t.Errorf("expected 1 remembered decision, got %d", remembered)
+	}
+
+	body := scrape(t, r)
+	for _, line := range []string{
+		`test_tail_sampling_traces_total{decision="kept",reason="latency"} 1`,
+		`test_tail_sampling_traces_total{decision="dropped",reason="ratio"} 1`,
+		`test_tail_sampling_buffered_traces 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `reason="timeout"`) {
+		t.Errorf("expected the late children not to time out, got:\n%s", body)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -22,12 +22,13 @@ const (
 )
 
 // StartPostgres creates a new Postgres Open Telemetry tracing Span.
-// Use this every time you want to trace a Postgres database operation.
+// Use this every time you want to trace a Postgres database operation, or StartDB to trace a single statement.
 func StartPostgres(ctx context.Context, traceName string) (context.Context, trace.Span) {
 	tr := otel.Tracer(traceName)
 	attributes := []attribute.KeyValue{
 		operationNameAttribute(traceName),
 		attribute.String(DBAttributeKey, PostgresAttributeValue),
+		semconv.DBSystemPostgreSQL,
 	}
 
 	ctx, span := tr.Start(ctx, traceName, trace.WithSpanKind(trace.SpanKindClient))
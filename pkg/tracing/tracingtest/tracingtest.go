@@ -0,0 +1,315 @@
+// Package tracingtest provides an in-memory span recorder and span tree assertions,
+// to test that the services produce the expected traces.
+package tracingtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultWaitTimeout is the maximum time WaitForSpan waits for a span to end.
+const DefaultWaitTimeout = 5 * time.Second
+
+// Any matches any attribute value, only asserting that the attribute is set, i.e: a generated user id.
+var Any = anyValue{}
+
+type anyValue struct{}
+
+func (anyValue) String() string {
+	return "<any>"
+}
+
+// Span represents an expected span, along with its expected children. The zero fields are not asserted.
+type Span struct {
+	// Name is the span name.
+	Name string
+
+	// Kind is the span kind, i.e: trace.SpanKindServer.
+	Kind trace.SpanKind
+
+	// Attributes are the expected span attributes, the span may have others.
+	// Use Any to only assert that the attribute is set.
+	Attributes map[string]any
+
+	// Events are the expected span event names, in order. The span may have others.
+	Events []string
+
+	// Status is the span status code: Unset, Error or Ok.
+	Status string
+
+	// Children are the expected child spans, in any order. The span may have others.
+	Children []Span
+}
+
+// Recorder represents an in-memory span recorder, backing the global Open Telemetry tracer provider.
+type Recorder struct {
+	*tracetest.SpanRecorder
+	provider *traceSDK.TracerProvider
+}
+
+// NewRecorder installs a tracer provider sampling every trace and recording the spans in memory,
+// along with the W3C trace context and baggage propagator, as the global Open Telemetry state.
+// The previous global state is restored once the test completes.
+// Create the instrumented handlers and clients after NewRecorder, since they may capture the global tracer provider.
+func NewRecorder(t testing.TB) *Recorder {
+	t.Helper()
+	return NewRecorderWithPropagator(t, propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// NewRecorderWithPropagator is like NewRecorder, installing the given propagator instead,
+// i.e: tracing.NewTextMapPropagator, to test the propagation the services use.
+func NewRecorderWithPropagator(t testing.TB, p propagation.TextMapPropagator) *Recorder {
+	t.Helper()
+
+	r := &Recorder{SpanRecorder: tracetest.NewSpanRecorder()}
+	r.provider = traceSDK.NewTracerProvider(traceSDK.WithSampler(traceSDK.AlwaysSample()), traceSDK.WithSpanProcessor(r.SpanRecorder))
+
+	tracerProvider := otel.GetTracerProvider()
+	propagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(r.provider)
+	otel.SetTextMapPropagator(p)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(propagator)
+		_ = r.provider.Shutdown(context.Background())
+	})
+	return r
+}
+
+// Provider returns the recording tracer provider.
+func (r *Recorder) Provider() *traceSDK.TracerProvider {
+	return r.provider
+}
+
+// Span returns the first ended span with the given name.
+func (r *Recorder) Span(name string) (traceSDK.ReadOnlySpan, bool) {
+	for _, s := range r.Ended() {
+		if s.Name() == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// WaitForSpan waits until a span with the given name ended, failing the test after DefaultWaitTimeout.
+// Use it for the spans ended asynchronously, i.e: the server spans, which end after the response is sent.
+func (r *Recorder) WaitForSpan(t testing.TB, name string) traceSDK.ReadOnlySpan {
+	t.Helper()
+
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for {
+		if s, ok := r.Span(name); ok {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("span %q did not end within %s, got:\n%s", name, DefaultWaitTimeout, r)
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// AssertTree asserts that one of the recorded span trees matches the expected span tree, reporting whether it does.
+// The spans of every service share the recorder, so the tree spans across services when the context is propagated.
+func (r *Recorder) AssertTree(t testing.TB, want Span) bool {
+	t.Helper()
+
+	for _, root := range r.trees() {
+		if root.find(want) {
+			return true
+		}
+	}
+	t.Errorf("no span tree matches the expected span tree\nwant:\n%sgot:\n%s", want, r)
+	return false
+}
+
+// String pretty prints the recorded span trees, i.e:
+//
+//	register_user_endpoint [server] status=Unset http.method="GET"
+//	└── register_user_txn [client] status=Unset db="postgresql" events=[create user identity, create user profile]
+func (r *Recorder) String() string {
+	var b strings.Builder
+	for _, root := range r.trees() {
+		root.print(&b, "", "")
+	}
+	return b.String()
+}
+
+type node struct {
+	span     traceSDK.ReadOnlySpan
+	children []*node
+}
+
+// trees returns the recorded span trees, the ones started first first.
+// The spans whose parent was not recorded are roots.
+func (r *Recorder) trees() []*node {
+	spans := r.Ended()
+	nodes := make(map[trace.SpanID]*node, len(spans))
+	for _, s := range spans {
+		nodes[s.SpanContext().SpanID()] = &node{span: s}
+	}
+
+	var roots []*node
+	for _, s := range spans {
+		n := nodes[s.SpanContext().SpanID()]
+		if parent, ok := nodes[s.Parent().SpanID()]; ok && s.Parent().IsValid() {
+			parent.children = append(parent.children, n)
+			continue
+		}
+		roots = append(roots, n)
+	}
+	for _, n := range nodes {
+		sortNodes(n.children)
+	}
+	sortNodes(roots)
+	return roots
+}
+
+func sortNodes(nodes []*node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].span.StartTime().Before(nodes[j].span.StartTime())
+	})
+}
+
+// find reports whether the node or any of its descendants match the expected span tree.
+func (n *node) find(want Span) bool {
+	if n.match(want) {
+		return true
+	}
+	for _, c := range n.children {
+		if c.find(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the node matches the expected span tree, every expected child matching a distinct child.
+func (n *node) match(want Span) bool {
+	s := n.span
+	if want.Name != "" && s.Name() != want.Name {
+		return false
+	}
+	if want.Kind != trace.SpanKindUnspecified && s.SpanKind() != want.Kind {
+		return false
+	}
+	if want.Status != "" && !strings.EqualFold(s.Status().Code.String(), want.Status) {
+		return false
+	}
+	attributes := attribute.NewSet(s.Attributes()...)
+	for k, v := range want.Attributes {
+		got, ok := attributes.Value(attribute.Key(k))
+		if !ok || (v != Any && fmt.Sprint(got.AsInterface()) != fmt.Sprint(v)) {
+			return false
+		}
+	}
+	events := s.Events()
+	for _, name := range want.Events {
+		for len(events) > 0 && events[0].Name != name {
+			events = events[1:]
+		}
+		if len(events) == 0 {
+			return false
+		}
+		events = events[1:]
+	}
+	return matchChildren(n.children, want.Children, map[*node]bool{})
+}
+
+// matchChildren backtracks over the children, so every expected child matches a distinct child.
+func matchChildren(children []*node, want []Span, used map[*node]bool) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, c := range children {
+		if used[c] || !c.match(want[0]) {
+			continue
+		}
+		used[c] = true
+		if matchChildren(children, want[1:], used) {
+			return true
+		}
+		used[c] = false
+	}
+	return false
+}
+
+func (n *node) print(b *strings.Builder, prefix, childPrefix string) {
+	s := n.span
+	attributes := make(map[string]any, len(s.Attributes()))
+	for _, a := range s.Attributes() {
+		attributes[string(a.Key)] = a.Value.AsInterface()
+	}
+	events := make([]string, 0, len(s.Events()))
+	for _, e := range s.Events() {
+		events = append(events, e.Name)
+	}
+	b.WriteString(prefix)
+	b.WriteString(line(s.Name(), s.SpanKind(), s.Status().Code.String(), attributes, events))
+	for i, c := range n.children {
+		if i == len(n.children)-1 {
+			c.print(b, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			c.print(b, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
+
+// String pretty prints the expected span tree, the same as Recorder.String.
+func (s Span) String() string {
+	var b strings.Builder
+	s.print(&b, "", "")
+	return b.String()
+}
+
+func (s Span) print(b *strings.Builder, prefix, childPrefix string) {
+	b.WriteString(prefix)
+	b.WriteString(line(s.Name, s.Kind, s.Status, s.Attributes, s.Events))
+	for i, c := range s.Children {
+		if i == len(s.Children)-1 {
+			c.print(b, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			c.print(b, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
+
+func line(name string, kind trace.SpanKind, status string, attributes map[string]any, events []string) string {
+	if name == "" {
+		name = "*"
+	}
+	parts := []string{name}
+	if kind != trace.SpanKindUnspecified {
+		parts = append(parts, "["+kind.String()+"]")
+	}
+	if status != "" {
+		parts = append(parts, "status="+status)
+	}
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := attributes[k].(string); ok {
+			parts = append(parts, fmt.Sprintf("%s=%q", k, v))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", k, attributes[k]))
+	}
+	if len(events) > 0 {
+		parts = append(parts, "events=["+strings.Join(events, ", ")+"]")
+	}
+	return strings.Join(parts, " ") + "\n"
+}
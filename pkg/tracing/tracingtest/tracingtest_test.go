@@ -0,0 +1,108 @@
+package tracingtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingT records the assertion failures, instead of failing the test.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func recordTrace() {
+	tracer := otel.Tracer("tracingtest_test")
+	ctx, root := tracer.Start(context.Background(), "register_user_endpoint", trace.WithSpanKind(trace.SpanKindServer))
+	root.SetAttributes(attribute.String("http.method", "POST"), attribute.Int("http.status_code", 500))
+
+	_, txn := tracer.Start(ctx, "register_user_txn", trace.WithSpanKind(trace.SpanKindClient))
+	txn.AddEvent("create user identity")
+	txn.AddEvent("create user profile")
+	txn.End()
+
+	_, notify := tracer.Start(ctx, "notify_user")
+	notify.RecordError(errors.New("boom"))
+	notify.SetStatus(codes.Error, "could not notify user")
+	notify.End()
+
+	root.End()
+}
+
+func TestRecorderAssertTree(t *testing.T) {
+	r := NewRecorder(t)
+	recordTrace()
+
+	r.AssertTree(t, Span{
+		Name:       "register_user_endpoint",
+		Kind:       trace.SpanKindServer,
+		Attributes: map[string]any{"http.method": "POST", "http.status_code": 500},
+		Children: []Span{
+			{Name: "notify_user", Status: "Error", Events: []string{"exception"}},
+			{Name: "register_user_txn", Events: []string{"create user identity", "create user profile"}},
+		},
+	})
+	// Subtrees match too.
+	r.AssertTree(t, Span{Name: "register_user_txn", Attributes: map[string]any{}})
+}
+
+func TestRecorderAssertTreeFailure(t *testing.T) {
+	r := NewRecorder(t)
+	recordTrace()
+
+	for _, want := range []Span{
+		{Name: "register_user_endpoint", Attributes: map[string]any{"http.method": "GET"}},
+		{Name: "register_user_endpoint", Attributes: map[string]any{"user_id": Any}},
+		{Name: "register_user_endpoint", Children: []Span{{Name: "notify_user", Status: "Ok"}}},
+		{Name: "register_user_endpoint", Children: []Span{{Name: "register_user_txn"}, {Name: "register_user_txn"}}},
+		{Name: "register_user_txn", Events: []string{"create user profile", "create user identity"}},
+		{Name: "notify_user", Children: []Span{{Name: "register_user_txn"}}},
+	} {
+		rt := &recordingT{TB: t}
+		if r.AssertTree(rt, want) || len(rt.errors) != 1 {
+			t.Errorf("expected the assertion to fail for:\n%s", want)
+		}
+	}
+
+	rt := &recordingT{TB: t}
+	r.AssertTree(rt, Span{Name: "register_user_endpoint", Children: []Span{{Name: "send_email"}}})
+	want := `want:
+register_user_endpoint
+└── send_email
+got:
+register_user_endpoint [server] status=Unset http.method="POST" http.status_code=500
+├── register_user_txn [client] status=Unset events=[create user identity, create user profile]
+└── notify_user [internal] status=Error events=[exception]
+`
+	if len(rt.errors) != 1 || !strings.HasSuffix(rt.errors[0], want) {
+		t.Errorf("expected the failure to pretty print the span trees, got:\n%s", rt.errors)
+	}
+}
+
+func TestNewRecorderRestoresGlobals(t *testing.T) {
+	tracerProvider := otel.GetTracerProvider()
+	propagator := otel.GetTextMapPropagator()
+
+	t.Run("recorder", func(t *testing.T) {
+		r := NewRecorder(t)
+		if otel.GetTracerProvider() != r.Provider() {
+			t.Error("expected the recorder provider to be the global tracer provider")
+		}
+	})
+
+	if otel.GetTracerProvider() != tracerProvider || otel.GetTextMapPropagator() != propagator {
+		t.Error("expected the global tracer provider and propagator to be restored")
+	}
+}
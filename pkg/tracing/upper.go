@@ -0,0 +1,186 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/upper/db/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TxOperation is the operation of the upper/db transaction spans.
+const TxOperation = "TRANSACTION"
+
+type dbSessionKey struct{}
+
+type dbSession struct {
+	system string
+	name   string
+}
+
+// tracedSession traces the transactions of an upper/db session,
+// marking its context so its queries are traced by the queryTracer.
+type tracedSession struct {
+	db.Session
+	dbSession dbSession
+}
+
+var traceQueriesOnce sync.Once
+
+// placeholderRE matches the bind placeholders of the compiled upper/db queries, i.e: ? for SQLite and $1 for PostgreSQL.
+var placeholderRE = regexp.MustCompile(`\?|\$[0-9]+`)
+
+// TraceQueries enables the query tracing of the sessions wrapped with TraceSession, for the whole process.
+// The queries are traced through the upper/db logging collector, which is the only upper/db hook seeing the queries,
+// so TraceQueries replaces the process wide upper/db logger with one wrapping the logger set before,
+// and lowers the upper/db log level to debug for the queries to reach it. The queries of all the sessions are still
+// logged as the level configured before did, while only the ones of the traced sessions are traced.
+//
+// Call it once on application setup, after configuring the upper/db logger,
+// since setting another upper/db logger afterwards (db.LC().SetLogger) disables the query tracing.
+func TraceQueries() {
+	traceQueriesOnce.Do(func() {
+		level := db.LC().Level()
+		db.LC().SetLogger(&queryTracer{next: upperLogger(), level: level})
+		if level > db.LogLevelDebug {
+			db.LC().SetLevel(db.LogLevelDebug)
+		}
+	})
+}
+
+// TraceSession wraps the upper/db session, so every transaction is traced, along with every query
+// once TraceQueries enabled the query tracing, i.e:
+//
+//	tracing.TraceQueries()
+//	sess = tracing.TraceSession(sess, tracing.PostgresAttributeValue)
+//	err := sess.WithContext(ctx).Tx(func(tx db.Session) error {
+//		_, err := tx.SQL().InsertInto("authors").Values(a).Exec()
+//		return err
+//	})
+//
+// The queries are children of the session context span, set with WithContext, or of the transaction span.
+// The statements prepared with Prepare are not traced, as their executions do not go through upper/db,
+// except for the ones without any placeholder, which upper/db reports the same as the queries without arguments.
+func TraceSession(sess db.Session, system string) db.Session {
+	s := &tracedSession{dbSession: dbSession{system: system, name: sess.Name()}}
+	s.Session = sess.WithContext(s.withSession(sess.Context()))
+	return s
+}
+
+func (s *tracedSession) withSession(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, dbSessionKey{}, s.dbSession)
+}
+
+func (s *tracedSession) WithContext(ctx context.Context) db.Session {
+	return &tracedSession{
+		Session:   s.Session.WithContext(s.withSession(ctx)),
+		dbSession: s.dbSession,
+	}
+}
+
+func (s *tracedSession) Tx(fn func(sess db.Session) error) error {
+	return s.TxContext(s.Context(), fn, nil)
+}
+
+func (s *tracedSession) TxContext(ctx context.Context, fn func(sess db.Session) error, opts *sql.TxOptions) error {
+	ctx, span := StartDB(s.withSession(ctx), s.dbSession.system, TxOperation, WithDBName(s.dbSession.name))
+	err := s.Session.TxContext(ctx, func(tx db.Session) error {
+		return fn(&tracedSession{Session: tx, dbSession: s.dbSession})
+	}, opts)
+	span.EndQuery(-1, err)
+	return err
+}
+
+// upperLogger returns the logger of the upper/db logging collector.
+func upperLogger() db.Logger {
+	if lc, ok := db.LC().(interface{ Logger() db.Logger }); ok {
+		return lc.Logger()
+	}
+	return log.New(os.Stdout, "", log.LstdFlags)
+}
+
+// queryTracer is the upper/db logger tracing the queries of the traced sessions,
+// logging the queries to the next logger as the upper/db log level does.
+type queryTracer struct {
+	next  db.Logger
+	level db.LogLevel
+}
+
+func (t *queryTracer) Print(v ...any) {
+	for _, e := range v {
+		status, ok := e.(*db.QueryStatus)
+		if !ok {
+			t.next.Print(e)
+			continue
+		}
+		traceQuery(status)
+		level := db.LogLevelDebug
+		if status.Err != nil {
+			level = db.LogLevelWarn
+		}
+		if level >= t.level {
+			t.next.Print(status)
+		}
+	}
+}
+
+func (t *queryTracer) Printf(format string, v ...any) {
+	t.next.Printf(format, v...)
+}
+
+func (t *queryTracer) Fatal(v ...any) {
+	t.next.Fatal(v...)
+}
+
+func (t *queryTracer) Fatalf(format string, v ...any) {
+	t.next.Fatalf(format, v...)
+}
+
+func (t *queryTracer) Panic(v ...any) {
+	t.next.Panic(v...)
+}
+
+func (t *queryTracer) Panicf(format string, v ...any) {
+	t.next.Panicf(format, v...)
+}
+
+// traceQuery creates the span of a completed query, if it was run by a traced session.
+func traceQuery(status *db.QueryStatus) {
+	if status.Context == nil {
+		return
+	}
+	sess, ok := status.Context.Value(dbSessionKey{}).(dbSession)
+	if !ok || isPrepare(status) {
+		return
+	}
+
+	_, span := StartDB(status.Context, sess.system, "",
+		WithDBName(sess.name),
+		WithStatement(status.Query()),
+		withSpanStartOption(trace.WithTimestamp(status.Start)),
+	)
+	rowsAffected := int64(-1)
+	if status.RowsAffected != nil {
+		rowsAffected = *status.RowsAffected
+	}
+	err := status.Err
+	if errors.Is(err, db.ErrWarnSlowQuery) {
+		err = nil
+	}
+	span.EndQuery(rowsAffected, err, trace.WithTimestamp(status.End))
+}
+
+// isPrepare reports whether the query status is reported by the upper/db Prepare, which only prepares the statement,
+// so it is not a query on its own. The prepared statements are reported without arguments, while the queries
+// with placeholders always have their arguments bound.
+func isPrepare(status *db.QueryStatus) bool {
+	return status.Args == nil && placeholderRE.MatchString(status.RawQuery)
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/upper/db/v4"
+	"github.com/upper/db/v4/adapter/sqlite"
+
+	"github.com/go-workshops/ppp/pkg/tracing/tracingtest"
+)
+
+// statusLogger records the query statuses logged by upper/db.
+type statusLogger struct {
+	db.Logger
+
+	mu       sync.Mutex
+	statuses []*db.QueryStatus
+}
+
+func (l *statusLogger) Print(v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range v {
+		if status, ok := e.(*db.QueryStatus); ok {
+			l.statuses = append(l.statuses, status)
+		}
+	}
+}
+
+func (l *statusLogger) logged() []*db.QueryStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*db.QueryStatus{}, l.statuses...)
+}
+
+type author struct {
+	ID   int    `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestTraceSession(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t)
+	sess, err := sqlite.Open(sqlite.ConnectionURL{Database: filepath.Join(t.TempDir(), "library.db")})
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer func() { _ = sess.Close() }()
+	if _, err = sess.SQL().Exec(`CREATE TABLE "authors" ("id" INTEGER PRIMARY KEY, "name" TEXT)`); err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+
+	logger := &statusLogger{}
+	db.LC().SetLogger(logger)
+	db.LC().SetLevel(db.LogLevelWarn)
+	TraceQueries()
+	traced := TraceSession(sess, "sqlite")
+
+	ctx, parent := recorder.Provider().Tracer("upper_test").Start(context.Background(), "register_author")
+	err = traced.WithContext(ctx).Tx(func(tx db.Session) error {
+		if _, err := tx.SQL().InsertInto("authors").Values(author{ID: 1, Name: "Ursula"}).Exec(); err != nil {
+			return err
+		}
+		_, err := tx.SQL().InsertInto("authors").Values(author{ID: 1, Name: "Terry"}).Exec()
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected the transaction error")
+	}
+
+	stmt, err := traced.WithContext(ctx).SQL().Select("name").From("authors").Where("id", 1).Prepare()
+	if err != nil {
+		t.Fatalf("could not prepare statement: %v", err)
+	}
+	_ = stmt.Close()
+	// The queries without placeholders are traced, even if they have no arguments.
+	if _, err = traced.WithContext(ctx).SQL().Exec(`DELETE FROM "authors" WHERE "id" > 1`); err != nil {
+		t.Fatalf("could not run query: %v", err)
+	}
+	parent.End()
+
+	// Not traced session queries are not traced.
+	if _, err = sess.WithContext(ctx).SQL().Exec("SELECT 1"); err != nil {
+		t.Fatalf("could not run query: %v", err)
+	}
+
+	recorder.AssertTree(t, tracingtest.Span{
+		Name: "register_author",
+		Children: []tracingtest.Span{
+			{
+				Name:       "TRANSACTION",
+				Status:     "Error",
+				Attributes: map[string]any{"db.system": "sqlite"},
+				Children: []tracingtest.Span{
+					{
+						Name: "INSERT authors",
+						Attributes: map[string]any{
+							"db.query.text":    `INSERT INTO "authors" ("id", "name") VALUES (?, ?)`,
+							"db.rows_affected": 1,
+						},
+						Status: "Unset",
+					},
+					{Name: "INSERT authors", Status: "Error"},
+				},
+			},
+			{Name: "DELETE authors", Attributes: map[string]any{"db.rows_affected": 0}},
+		},
+	})
+	for _, s := range recorder.Ended() {
+		if s.Name() == "SELECT authors" || s.Name() == "SELECT" {
+			t.Errorf("expected the prepared and not traced statements not to be traced, got:\n%s", recorder)
+		}
+	}
+
+	// The logger set before is kept, at the level set before: only the failed query is logged.
+	if statuses := logger.logged(); len(statuses) != 1 || statuses[0].Err == nil {
+		t.Errorf("expected only the failed query to be logged, got %v", statuses)
+	}
+}
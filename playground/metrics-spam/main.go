@@ -1,20 +1,27 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
 func main() {
+	ctx := context.Background()
 	var wg sync.WaitGroup
-	limiter := make(chan struct{}, 30)
+	limiter := metrics.NewLimiter(metrics.LimiterOpts{Name: "metrics_spam", Capacity: 30})
 	numberOfRequests := 10000
 	for i := 1; i <= numberOfRequests; i++ {
-		limiter <- struct{}{}
-		wg.Add(2)
+		// Acquiring before starting the goroutines bounds the number of goroutines, not only the in-flight requests.
+		acquire(ctx, limiter)
+		wg.Add(1)
 		go reqM1(&wg, limiter)
+		acquire(ctx, limiter)
+		wg.Add(1)
 		go reqM2(&wg, limiter)
 		time.Sleep(500 * time.Millisecond)
 	}
@@ -22,9 +29,15 @@ func main() {
 	wg.Wait()
 }
 
-func reqM1(wg *sync.WaitGroup, limiter chan struct{}) {
+func acquire(ctx context.Context, limiter *metrics.Limiter) {
+	if err := limiter.Acquire(ctx); err != nil {
+		log.Fatalf("could not acquire limiter: %v", err)
+	}
+}
+
+func reqM1(wg *sync.WaitGroup, limiter *metrics.Limiter) {
 	defer wg.Done()
-	defer func() { <-limiter }()
+	defer limiter.Release()
 	res, err := http.Get("http://localhost:8080/m1")
 	if err != nil {
 		log.Fatalf("could not send request: %v", err)
@@ -32,9 +45,9 @@ func reqM1(wg *sync.WaitGroup, limiter chan struct{}) {
 	defer func() { _ = res.Body.Close() }()
 }
 
-func reqM2(wg *sync.WaitGroup, limiter chan struct{}) {
+func reqM2(wg *sync.WaitGroup, limiter *metrics.Limiter) {
 	defer wg.Done()
-	defer func() { <-limiter }()
+	defer limiter.Release()
 	res, err := http.Get("http://localhost:8080/m2")
 	if err != nil {
 		log.Fatalf("could not send request: %v", err)
@@ -6,9 +6,13 @@ import (
 	"net/http/pprof"
 	"time"
 
+	"github.com/go-workshops/ppp/pkg/metrics"
 	"github.com/go-workshops/ppp/playground/pprof-mem-leak-subtle/workers"
 )
 
+// leaks samples the goroutine stacks, reporting the Leak worker goroutines that outlive their context.
+var leaks = metrics.NewLeakDetector(metrics.LeakDetectorOpts{Interval: 5 * time.Second})
+
 func main() {
 	go func() {
 		log.Fatalln(http.ListenAndServe(":8080", router()))
@@ -21,6 +25,8 @@ func main() {
 
 func router() http.Handler {
 	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.PrometheusHandler())
+	mux.Handle("/leaks", leaks.Handler())
 	mux.HandleFunc("/pprof/", pprof.Index)
 	mux.HandleFunc("/pprof/cmdline", pprof.Cmdline)
 	mux.HandleFunc("/pprof/profile", pprof.Profile)
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"sync"
 	"time"
+
+	"github.com/go-workshops/ppp/pkg/metrics"
 )
 
 func line(level, msg string, fields map[string]interface{}) string {
@@ -23,9 +26,9 @@ func line(level, msg string, fields map[string]interface{}) string {
 	return string(bs)
 }
 
-func req(wg *sync.WaitGroup, id int, limiter chan struct{}) {
+func req(wg *sync.WaitGroup, id int, limiter *metrics.Limiter) {
 	defer wg.Done()
-	defer func() { <-limiter }()
+	defer limiter.Release()
 	log.Println(line("info", "request", map[string]any{"req_id": id}))
 }
 
@@ -38,11 +41,14 @@ func main() {
 	log.SetOutput(file)
 	log.SetFlags(0)
 
+	ctx := context.Background()
 	var wg sync.WaitGroup
-	limiter := make(chan struct{}, 1000)
+	limiter := metrics.NewLimiter(metrics.LimiterOpts{Name: "without_sampling", Capacity: 1000})
 	numberOfRequests, start := 10_000_000, time.Now()
 	for i := 1; i <= numberOfRequests; i++ {
-		limiter <- struct{}{}
+		if err := limiter.Acquire(ctx); err != nil {
+			log.Fatalf("could not acquire limiter: %v", err)
+		}
 		wg.Add(1)
 		go req(&wg, i, limiter)
 	}